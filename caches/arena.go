@@ -0,0 +1,275 @@
+package caches
+
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// wordSize 是缓冲区中一个字的大小，所有数据都按字对齐，这样访问时间才能使用 atomic 包进行读写。
+	wordSize = 8
+
+	// arenaHeaderWords 是每个数据头部占用的字数。
+	// 0: 数据总大小 | key 的长度 << 32
+	// 1: Atime
+	// 2: Ctime
+	// 3: Expire
+	// 4: Ttl
+	// 5: due
+	// 6: value 的长度 | Mode << 32 | Flags << 40 | ContentType 的长度 << 48
+	// 7: value 压缩之前的长度 | ClientFlags << 32
+	// 8: Version
+	// 9: Mtime
+	// 头部后面依次是 key、ContentType 和 value。
+	arenaHeaderWords = 10
+
+	// arenaHeaderSize 是每个数据头部占用的字节数。
+	arenaHeaderSize = arenaHeaderWords * wordSize
+
+	// minArenaSize 是每个 segment 缓冲区的最小大小。
+	minArenaSize = 64 * 1024
+)
+
+var (
+	// entryTooLargeErr 是数据比整个缓冲区还大的错误。
+	entryTooLargeErr = errors.New("the entry is too large to be stored in arena")
+)
+
+// arenaStorage 是使用预分配的环形字节缓冲区存储数据的引擎，参考了 bigcache 和 freecache 的做法。
+// 所有数据都序列化到同一个大的缓冲区中，索引只是 key 的哈希值到数据位置的映射，里面没有任何指针，所以 Go 的 GC 不需要扫描它们。
+// 新数据总是写在缓冲区的末尾，写满之后就从头覆盖，被覆盖的数据就被淘汰了。
+// 删除数据只会删除索引，数据占用的空间要等到被覆盖时才会真正释放。
+type arenaStorage struct {
+
+	// words 是缓冲区，使用 uint64 分配可以保证按字对齐。
+	words []uint64
+
+	// buf 是缓冲区的字节视图，和 words 共享同一块内存。
+	buf []byte
+
+	// index 是 key 的哈希值到数据在缓冲区中位置的映射。
+	index map[uint64]uint32
+
+	// hash 是计算 key 哈希值的算法，使用带随机种子的算法可以避免有人故意制造哈希冲突来淘汰数据。
+	hash Hasher
+
+	// begin 是最旧的数据的逻辑位置。
+	// 逻辑位置只增不减，对缓冲区大小取模之后才是真正的位置。
+	begin int64
+
+	// end 是下一个数据写入的逻辑位置。
+	end int64
+
+	// onEvict 会在数据被覆盖淘汰时调用，参数是 key、数据的大小、数据压缩之前的大小和 ContentType 的长度。
+	onEvict func(key string, size int, rawSize int, contentTypeSize int)
+}
+
+// newArenaStorage 返回一个缓冲区大小为 size 的存储引擎。
+func newArenaStorage(size int, hash Hasher, onEvict func(key string, size int, rawSize int, contentTypeSize int)) *arenaStorage {
+	if size < minArenaSize {
+		size = minArenaSize
+	}
+
+	words := make([]uint64, size/wordSize)
+	return &arenaStorage{
+		words:   words,
+		buf:     unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*wordSize),
+		index:   make(map[uint64]uint32),
+		hash:    hash,
+		onEvict: onEvict,
+	}
+}
+
+// align 将 size 向上对齐到字的大小。
+func align(size int) int {
+	return (size + wordSize - 1) &^ (wordSize - 1)
+}
+
+// word 返回 offset 开始的第 i 个字在 words 中的下标。
+func (as *arenaStorage) word(offset uint32, i int) int {
+	return (int(offset)/wordSize + i) % len(as.words)
+}
+
+// read 从缓冲区的 offset 位置读取数据到 dst 中，到了缓冲区末尾就从头继续读。
+func (as *arenaStorage) read(dst []byte, offset int) {
+	offset %= len(as.buf)
+	n := copy(dst, as.buf[offset:])
+	copy(dst[n:], as.buf)
+}
+
+// write 将 src 写入到缓冲区的 offset 位置，到了缓冲区末尾就从头继续写。
+func (as *arenaStorage) write(src []byte, offset int) {
+	offset %= len(as.buf)
+	n := copy(as.buf[offset:], src)
+	copy(as.buf, src[n:])
+}
+
+// sizes 返回 offset 位置的数据的总大小、key 的长度和 value 的长度。
+func (as *arenaStorage) sizes(offset uint32) (size int, keySize int, valueSize int) {
+	word := as.words[as.word(offset, 0)]
+	return int(uint32(word)), int(word >> 32), int(uint32(as.words[as.word(offset, 6)]))
+}
+
+// contentTypeSize 返回 offset 位置的数据的 ContentType 的长度。
+func (as *arenaStorage) contentTypeSize(offset uint32) int {
+	return int(byte(as.words[as.word(offset, 6)] >> 48))
+}
+
+// keyAt 返回 offset 位置的数据的 key。
+func (as *arenaStorage) keyAt(offset uint32) string {
+	_, keySize, _ := as.sizes(offset)
+	key := make([]byte, keySize)
+	as.read(key, int(offset)+arenaHeaderSize)
+	return string(key)
+}
+
+// locate 返回 key 对应的数据在缓冲区中的位置。
+func (as *arenaStorage) locate(key string) (uint32, bool) {
+	offset, ok := as.index[as.hash(key)]
+	if !ok || as.keyAt(offset) != key {
+		return 0, false
+	}
+	return offset, true
+}
+
+// decode 从 offset 位置解析出数据，数据会被复制出来。
+func (as *arenaStorage) decode(offset uint32) *value {
+	_, keySize, valueSize := as.sizes(offset)
+	v := &value{
+		Data:        make([]byte, valueSize),
+		Atime:       int64(atomic.LoadUint64(&as.words[as.word(offset, 1)])),
+		Ctime:       int64(as.words[as.word(offset, 2)]),
+		Expire:      int64(as.words[as.word(offset, 3)]),
+		Ttl:         int64(as.words[as.word(offset, 4)]),
+		due:         int64(as.words[as.word(offset, 5)]),
+		Mode:        ExpireMode(as.words[as.word(offset, 6)] >> 32),
+		Flags:       byte(as.words[as.word(offset, 6)] >> 40),
+		RawSize:     int(uint32(as.words[as.word(offset, 7)])),
+		ClientFlags: uint32(as.words[as.word(offset, 7)] >> 32),
+		Version:     as.words[as.word(offset, 8)],
+		Mtime:       int64(as.words[as.word(offset, 9)]),
+	}
+
+	contentType := make([]byte, as.contentTypeSize(offset))
+	as.read(contentType, int(offset)+arenaHeaderSize+keySize)
+	v.ContentType = string(contentType)
+	as.read(v.Data, int(offset)+arenaHeaderSize+keySize+len(contentType))
+	return v
+}
+
+// encodeHeader 将数据的头部写入到 offset 位置，size 是数据的总大小。
+func (as *arenaStorage) encodeHeader(offset uint32, size int, keySize int, v *value) {
+	as.words[as.word(offset, 0)] = uint64(uint32(size)) | uint64(keySize)<<32
+	atomic.StoreUint64(&as.words[as.word(offset, 1)], uint64(atomic.LoadInt64(&v.Atime)))
+	as.words[as.word(offset, 2)] = uint64(v.Ctime)
+	as.words[as.word(offset, 3)] = uint64(v.Expire)
+	as.words[as.word(offset, 4)] = uint64(v.Ttl)
+	as.words[as.word(offset, 5)] = uint64(v.due)
+	as.words[as.word(offset, 6)] = uint64(uint32(len(v.Data))) | uint64(v.Mode)<<32 | uint64(v.Flags)<<40 | uint64(len(v.ContentType))<<48
+	as.words[as.word(offset, 7)] = uint64(uint32(v.RawSize)) | uint64(v.ClientFlags)<<32
+	as.words[as.word(offset, 8)] = v.Version
+	as.words[as.word(offset, 9)] = uint64(v.Mtime)
+}
+
+// rawSizeAt 返回 offset 位置的数据压缩之前的大小。
+func (as *arenaStorage) rawSizeAt(offset uint32) int {
+	if rawSize := int(uint32(as.words[as.word(offset, 7)])); rawSize != 0 {
+		return rawSize
+	}
+	_, _, valueSize := as.sizes(offset)
+	return valueSize
+}
+
+// evictOldest 淘汰掉缓冲区中最旧的数据，如果这个数据已经被删除或者覆盖了，就只需要回收空间。
+func (as *arenaStorage) evictOldest() {
+	offset := uint32(as.begin % int64(len(as.buf)))
+	size, _, valueSize := as.sizes(offset)
+	key := as.keyAt(offset)
+	hash := as.hash(key)
+	if current, ok := as.index[hash]; ok && current == offset {
+		delete(as.index, hash)
+		as.onEvict(key, valueSize, as.rawSizeAt(offset), as.contentTypeSize(offset))
+	}
+	as.begin += int64(size)
+}
+
+func (as *arenaStorage) get(key string) (*value, bool) {
+	offset, ok := as.locate(key)
+	if !ok {
+		return nil, false
+	}
+	return as.decode(offset), true
+}
+
+func (as *arenaStorage) set(key string, v *value) error {
+	size := align(arenaHeaderSize + len(key) + len(v.ContentType) + len(v.Data))
+	if size > len(as.buf) {
+		return entryTooLargeErr
+	}
+
+	// 哈希值冲突的话，旧的 key 就只能被淘汰了
+	hash := as.hash(key)
+	if offset, ok := as.index[hash]; ok {
+		delete(as.index, hash)
+		if oldKey := as.keyAt(offset); oldKey != key {
+			_, _, valueSize := as.sizes(offset)
+			as.onEvict(oldKey, valueSize, as.rawSizeAt(offset), as.contentTypeSize(offset))
+		}
+	}
+
+	// 空间不够就淘汰最旧的数据
+	for as.end+int64(size)-as.begin > int64(len(as.buf)) {
+		as.evictOldest()
+	}
+
+	offset := uint32(as.end % int64(len(as.buf)))
+	as.encodeHeader(offset, size, len(key), v)
+	as.write([]byte(key), int(offset)+arenaHeaderSize)
+	as.write([]byte(v.ContentType), int(offset)+arenaHeaderSize+len(key))
+	as.write(v.Data, int(offset)+arenaHeaderSize+len(key)+len(v.ContentType))
+	as.index[hash] = offset
+	as.end += int64(size)
+	return nil
+}
+
+func (as *arenaStorage) update(key string, fn func(v *value)) bool {
+	offset, ok := as.locate(key)
+	if !ok {
+		return false
+	}
+
+	v := as.decode(offset)
+	fn(v)
+	size, keySize, _ := as.sizes(offset)
+	as.encodeHeader(offset, size, keySize, v)
+	return true
+}
+
+func (as *arenaStorage) touch(key string, v *value, atime int64) {
+	if offset, ok := as.locate(key); ok {
+		atomic.StoreUint64(&as.words[as.word(offset, 1)], uint64(atime))
+	}
+}
+
+func (as *arenaStorage) delete(key string) {
+	if _, ok := as.locate(key); ok {
+		delete(as.index, as.hash(key))
+	}
+}
+
+func (as *arenaStorage) each(fn func(key string, v *value) bool) {
+	for _, offset := range as.index {
+		if !fn(as.keyAt(offset), as.decode(offset)) {
+			return
+		}
+	}
+}
+
+func (as *arenaStorage) cost(key string, size int, contentTypeSize int) int64 {
+	return int64(align(arenaHeaderSize + len(key) + contentTypeSize + size))
+}
+
+func (as *arenaStorage) evicts() bool {
+	return true
+}
@@ -0,0 +1,139 @@
+package caches
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestArenaStorage$
+func TestArenaStorage(t *testing.T) {
+
+	evicted := make(map[string]bool)
+	arena := newArenaStorage(minArenaSize, newFnvHasher(0), func(key string, size int, rawSize int, contentTypeSize int) {
+		evicted[key] = true
+	})
+
+	// 写入远超缓冲区大小的数据，让缓冲区绕好几圈
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		if err := arena.set(key, newValue(bytes.Repeat([]byte(key), i%7+1), SetOptions{})); err != nil {
+			t.Fatal(err)
+		}
+
+		if i%3 == 0 {
+			arena.delete(key)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		v, ok := arena.get(key)
+		if i%3 == 0 || evicted[key] {
+			if ok {
+				t.Fatalf("key %s should not exist", key)
+			}
+			continue
+		}
+
+		if !ok || !bytes.Equal(v.Data, bytes.Repeat([]byte(key), i%7+1)) {
+			t.Fatalf("value of key %s is wrong", key)
+		}
+	}
+
+	if len(evicted) == 0 || evicted["9999"] {
+		t.Fatal("only the oldest entries should be evicted")
+	}
+
+	if err := arena.set("large", newValue(make([]byte, minArenaSize), SetOptions{})); err != entryTooLargeErr {
+		t.Fatalf("entry larger than arena should be rejected but got %v", err)
+	}
+}
+
+// go test -v -run=^TestCacheArenaStorage$
+func TestCacheArenaStorage(t *testing.T) {
+
+	options := DefaultOptions()
+	options.StorageEngine = ArenaStorage
+	options.MaxMemory = "64MB"
+	cache := NewCacheWith(options)
+
+	for i := 0; i < 10000; i++ {
+		data := strconv.Itoa(i)
+		if err := cache.Set(data, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache.Delete("0")
+	cache.Expire("1", 1)
+	if _, ok := cache.Get("0"); ok {
+		t.Fatal("deleted key should not exist")
+	}
+
+	if _, ok := cache.Get("1"); ok {
+		t.Fatal("expired key should not exist")
+	}
+
+	if value, ok := cache.Get("2"); !ok || string(value) != "2" {
+		t.Fatalf("value of key 2 is wrong: %s", value)
+	}
+
+	if ttl, ok := cache.TTL("2"); !ok || ttl != NeverDie {
+		t.Fatalf("ttl of key 2 should be NeverDie but got %s", ttl)
+	}
+
+	if status := cache.Status(); status.Count != 9998 {
+		t.Fatalf("count of entries should be 9998 but got %d", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheContentTypeMemory$
+func TestCacheContentTypeMemory(t *testing.T) {
+
+	// ContentType 也会被存储引擎保存下来，所以估算内存占用时也要算上
+	for _, engine := range []string{MapStorage, ArenaStorage} {
+		options := DefaultOptions()
+		options.StorageEngine = engine
+		cache := NewCacheWith(options)
+
+		cache.Set("key", []byte("value"))
+		plain := cache.Status().UsedMemory
+		cache.SetWith("key", []byte("value"), SetOptions{ContentType: strings.Repeat("t", 64)})
+		if used := cache.Status().UsedMemory; used < plain+64 {
+			t.Fatalf("used memory of %s engine should include the content type but got %d, %d", engine, plain, used)
+		}
+
+		cache.Delete("key")
+		if used := cache.Status().UsedMemory; used != 0 {
+			t.Fatalf("used memory of %s engine should be 0 after deleting but got %d", engine, used)
+		}
+	}
+}
+
+// go test -v -run=^TestStorageUpdate$
+func TestStorageUpdate(t *testing.T) {
+
+	for _, engine := range []string{MapStorage, ArenaStorage} {
+		options := DefaultOptions()
+		options.StorageEngine = engine
+		data := newStorage(&options, newFnvHasher(0), func(key string, size int, rawSize int, contentTypeSize int) {})
+		data.set("key", newValue([]byte("value"), SetOptions{}))
+
+		// get 返回的数据会在锁外面被读取，update 不能修改它，否则就是数据竞争
+		before, _ := data.get("key")
+		data.update("key", func(v *value) {
+			v.Ttl = int64(time.Minute)
+		})
+
+		if before.Ttl != NeverDie {
+			t.Fatalf("%s storage should not modify the value returned by get", engine)
+		}
+
+		if after, _ := data.get("key"); after.Ttl != int64(time.Minute) {
+			t.Fatalf("%s storage should update the value but got ttl %d", engine, after.Ttl)
+		}
+	}
+}
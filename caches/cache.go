@@ -1,27 +1,68 @@
 package caches
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // Cache 是代表缓存的结构体。
+// 缓存中的数据按照命名空间隔离，Cache 自己的 Get、Set 等方法操作的都是默认的命名空间。
 type Cache struct {
 
-	// segmentSize 是 segment 的数量，这个数量越多，理论上并发的性能就越好。
-	segmentSize int
+	// options 是缓存配置，创建之后就不会再修改，运行时可以修改的配置需要从 current 中读取。
+	options *Options
 
-	// segments 存储着所有的 segment 实例。
-	segments []*segment
+	// runtime 存储着运行时的配置，类型是 *Options，Reload 会整个替换掉它。
+	runtime atomic.Value
 
-	// options 是缓存配置。
-	options *Options
+	// reloaded 会在每次 Reload 之后被关闭并替换成新的管道，后台任务可以通过它知道配置变了。
+	reloaded chan struct{}
+
+	// reloadLock 用于保护 reloaded。
+	reloadLock *sync.Mutex
+
+	// memory 记录着整个缓存的内存使用情况，所有命名空间的内存记录都以它为上级。
+	memory *memory
+
+	// hash 是选择 segment 使用的哈希算法。
+	hash Hasher
+
+	// compressor 是压缩数据使用的算法，为 nil 表示不压缩。
+	compressor *compressor
+
+	// versions 是整个缓存最新的数据版本号，所有命名空间共享，需要使用 atomic 包进行读写。
+	versions uint64
+
+	// defaultNamespace 是默认的命名空间。
+	defaultNamespace *Namespace
+
+	// namespaces 存储着所有的命名空间，包括默认的命名空间。
+	namespaces map[string]*Namespace
+
+	// namespacesLock 用于保护 namespaces。
+	namespacesLock *sync.RWMutex
 
 	// dumping 标识当前缓存是否处于持久化状态。1 表示处于持久化状态。
 	// 因为现在的 cache 是没有全局锁的，而持久化需要记录下当前的状态，不允许有更新，所以使用一个变量记录着，
 	// 如果处于持久化状态，就让所有更新操作进入自旋状态，等待持久化完成再进行。
 	dumping int32
+
+	// gcStatus 记录着 gc 的运行情况，里面的字段都需要使用 atomic 包进行读写。
+	gcStatus *GcStatus
+
+	// gcCursor 记录着 AdaptiveGc 策略下次从哪个 segment 开始抽样，用完时间预算时可以从中断的地方继续。
+	gcCursor int
+
+	// ctx 会在缓存关闭的时候被取消，后台的 gc 和持久化任务会因此退出。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
+
+	// background 记录着正在运行的后台任务，关闭缓存的时候需要等待它们退出。
+	background *sync.WaitGroup
 }
 
 // NewCache 返回一个默认配置的缓存实例。
@@ -32,22 +73,62 @@ func NewCache() *Cache {
 // NewCacheWith 返回一个使用 options 初始化过的缓存实例
 func NewCacheWith(options Options) *Cache {
 	// 尝试从持久化文件中恢复
-	if cache, ok := recoverFromDumpFile(options.DumpFile); ok {
+	if cache, ok := recoverFromDumpFile(options); ok {
 		return cache
 	}
-	return &Cache{
-		segmentSize: options.SegmentSize,
+	return newCache(options)
+}
+
+// newCache 返回一个使用 options 初始化过的空缓存实例。
+// segment 的个数会向上取整到 2 的幂，哈希算法不存在的话就使用默认的哈希算法，压缩算法不存在的话就不压缩。
+// Options.Namespaces 中配置的命名空间会在这里预先创建，配置不合法的会被跳过，所以使用前最好先检查配置。
+func newCache(options Options) *Cache {
+	options.SegmentSize = roundToPowerOfTwo(options.SegmentSize)
+	hash, err := newHasher(options.HashFunction)
+	if err != nil {
+		hash, _ = newHasher(DefaultOptions().HashFunction)
+	}
 
-		// 初始化所有的 segment
-		segments: newSegments(&options),
-		options:  &options,
-		dumping:  0,
+	compressor, err := compressorOf(options.Compression)
+	if err != nil {
+		compressor = nil
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := &Cache{
+		options:        &options,
+		memory:         newMemory(options.maxMemory(), nil),
+		hash:           hash,
+		compressor:     compressor,
+		namespaces:     make(map[string]*Namespace),
+		namespacesLock: &sync.RWMutex{},
+		dumping:        0,
+		gcStatus:       &GcStatus{Strategy: options.GcStrategy},
+		ctx:            ctx,
+		cancel:         cancel,
+		background:     &sync.WaitGroup{},
+		reloaded:       make(chan struct{}),
+		reloadLock:     &sync.Mutex{},
+	}
+	runtime := options
+	cache.runtime.Store(&runtime)
+
+	// 默认的命名空间直接使用缓存的配置，没有单独的内存配额
+	cache.defaultNamespace, _ = newNamespace(cache, DefaultNamespace, NamespaceOptions{
+		EvictionPolicy: NoEviction,
+		SegmentSize:    options.SegmentSize,
+	}, &options)
+	cache.namespaces[DefaultNamespace] = cache.defaultNamespace
+
+	for name, namespaceOptions := range options.Namespaces {
+		cache.CreateNamespace(name, namespaceOptions)
+	}
+	return cache
 }
 
-// recoverFromDumpFile 从持久化文件中恢复缓存。
-func recoverFromDumpFile(dumpFile string) (*Cache, bool) {
-	cache, err := newEmptyDump().from(dumpFile)
+// recoverFromDumpFile 从 options 中配置的持久化文件中恢复缓存，恢复出来的缓存使用 options 作为配置。
+func recoverFromDumpFile(options Options) (*Cache, bool) {
+	cache, err := newEmptyDump().from(options.DumpFile, options)
 	if err != nil {
 		return nil, false
 	}
@@ -55,95 +136,217 @@ func recoverFromDumpFile(dumpFile string) (*Cache, bool) {
 }
 
 // newSegments 返回初始化好的 segment 实例列表。
-func newSegments(options *Options) []*segment {
+func newSegments(options *Options, memory *memory, hash Hasher, tags *tagIndex, versions *uint64) []*segment {
 	// 根据配置的数量生成 segment
 	segments := make([]*segment, options.SegmentSize)
 	for i := 0; i < options.SegmentSize; i++ {
-		segments[i] = newSegment(options)
+		segments[i] = newSegment(options, memory, hash, tags, versions)
 	}
 	return segments
 }
 
-// index 是选择 segment 的“特殊算法”。
-// 这里参考了 Java 中的哈希生成逻辑，尽可能避免重复。不用去纠结为什么这么写，因为没有唯一的写法。
-// 为了能使用到哈希值的全部数据，这里使用高位和低位进行异或操作。
-func index(key string) int {
-	index := 0
-	keyBytes := []byte(key)
-	for _, b := range keyBytes {
-		index = 31*index + int(b&0xff)
-	}
-	return index ^ (index >> 16)
-}
-
-// segmentOf 返回 key 对应的 segment。
-// 使用 index 生成的哈希值去获取 segment，这里使用 & 运算也是 Java 中的奇淫技巧。
-func (c *Cache) segmentOf(key string) *segment {
-	return c.segments[index(key)&(c.segmentSize-1)]
-}
-
-// Get 返回指定 key 的数据。
+// Get 返回默认命名空间中指定 key 的数据。
 func (c *Cache) Get(key string) ([]byte, bool) {
-	// 这边会等待持久化完成
-	c.waitForDumping()
-	return c.segmentOf(key).get(key)
+	return c.defaultNamespace.Get(key)
 }
 
-// Set 添加指定的数据到缓存中。
+// Set 添加指定的数据到默认命名空间中。
 func (c *Cache) Set(key string, value []byte) error {
 	return c.SetWithTTL(key, value, NeverDie)
 }
 
-// SetWithTTL 添加指定的数据到缓存中，并设置相应的有效期。
+// SetWithTTL 添加指定的数据到默认命名空间中，并设置相应的有效期。
+// ttl 的单位是秒，需要更高精度的话请使用 SetWithDuration。
 func (c *Cache) SetWithTTL(key string, value []byte, ttl int64) error {
-	// 这边会等待持久化完成
-	c.waitForDumping()
-	return c.segmentOf(key).set(key, value, ttl)
+	return c.SetWithDuration(key, value, time.Duration(ttl)*time.Second)
+}
+
+// SetWithDuration 添加指定的数据到默认命名空间中，并设置相应的有效期。
+// 和 SetWithTTL 不同的是，这里的 ttl 可以精确到纳秒，比如 250 * time.Millisecond。
+func (c *Cache) SetWithDuration(key string, value []byte, ttl time.Duration) error {
+	return c.SetWith(key, value, SetOptions{Ttl: ttl})
 }
 
-// Delete 从缓存中删除指定 key 的数据。
+// SetWithExpireAt 添加指定的数据到默认命名空间中，并在 expireAt 这个时间点过期。
+func (c *Cache) SetWithExpireAt(key string, value []byte, expireAt time.Time) error {
+	return c.SetWith(key, value, SetOptions{ExpireAt: expireAt})
+}
+
+// SetWith 使用 options 添加指定的数据到默认命名空间中，可以通过 options 选择绝对过期或者滑动过期。
+func (c *Cache) SetWith(key string, value []byte, options SetOptions) error {
+	return c.defaultNamespace.SetWith(key, value, options)
+}
+
+// Delete 从默认命名空间中删除指定 key 的数据。
 func (c *Cache) Delete(key string) error {
+	return c.defaultNamespace.Delete(key)
+}
+
+// Expire 重新设置默认命名空间中指定 key 的寿命，寿命从现在开始计算，如果 key 不存在就返回 false。
+func (c *Cache) Expire(key string, ttl time.Duration) bool {
+	return c.defaultNamespace.Expire(key, ttl)
+}
+
+// Persist 移除默认命名空间中指定 key 的寿命，让它永不过期，如果 key 不存在就返回 false。
+func (c *Cache) Persist(key string) bool {
+	return c.defaultNamespace.Persist(key)
+}
+
+// TTL 返回默认命名空间中指定 key 剩余的寿命，如果 key 永不过期就返回 NeverDie，如果 key 不存在就返回 false。
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	return c.defaultNamespace.TTL(key)
+}
+
+// Touch 更新默认命名空间中指定 key 的访问时间但不读取数据，如果 key 不存在就返回 false。
+func (c *Cache) Touch(key string) bool {
+	return c.defaultNamespace.Touch(key)
+}
+
+// InvalidateTag 删除默认命名空间中所有带有 tag 这个标签的数据，返回删除的数据个数。
+func (c *Cache) InvalidateTag(tag string) int {
+	return c.defaultNamespace.InvalidateTag(tag)
+}
+
+// Flush 清空所有命名空间中的数据，命名空间本身和它们的配置会保留下来。
+// 清空的过程中会锁住所有的 segment，所以其他操作要么看到清空前的数据，要么看到清空后的数据，不会看到清空了一半的缓存。
+func (c *Cache) Flush() {
 	// 这边会等待持久化完成
 	c.waitForDumping()
-	c.segmentOf(key).delete(key)
-	return nil
+	namespaces := c.allNamespaces()
+	var segments []*segment
+	for _, namespace := range namespaces {
+		segments = append(segments, namespace.segments...)
+	}
+
+	lockSegments(segments)
+	defer unlockSegments(segments)
+	for _, namespace := range namespaces {
+		namespace.reset()
+	}
 }
 
-// Status 返回缓存当前的情况。
+// Status 返回整个缓存当前的情况，包括所有的命名空间。
 func (c *Cache) Status() Status {
 	result := NewStatus()
-	for _, segment := range c.segments {
-		status := segment.status()
+	for _, namespace := range c.allNamespaces() {
+		status := namespace.Status()
 		result.Count += status.Count
 		result.KeySize += status.KeySize
 		result.ValueSize += status.ValueSize
+		result.RawValueSize += status.RawValueSize
+		result.CompressedCount += status.CompressedCount
+		result.ExpiredBacklog += status.ExpiredBacklog
+	}
+
+	result.UsedMemory = c.memory.usage()
+	result.MaxMemory = atomic.LoadInt64(&c.memory.limit)
+
+	result.Gc = GcStatus{
+		Strategy:       c.gcStatus.Strategy,
+		Cycles:         atomic.LoadInt64(&c.gcStatus.Cycles),
+		Sampled:        atomic.LoadInt64(&c.gcStatus.Sampled),
+		Expired:        atomic.LoadInt64(&c.gcStatus.Expired),
+		BudgetExceeded: atomic.LoadInt64(&c.gcStatus.BudgetExceeded),
+		LastCycleTime:  atomic.LoadInt64(&c.gcStatus.LastCycleTime),
 	}
 	return *result
 }
 
+// allNamespaces 返回所有的命名空间，按照名称排好序，默认的命名空间排在第一个。
+func (c *Cache) allNamespaces() []*Namespace {
+	names := c.Namespaces()
+	c.namespacesLock.RLock()
+	defer c.namespacesLock.RUnlock()
+	namespaces := make([]*Namespace, 0, len(names))
+	for _, name := range names {
+		if namespace, ok := c.namespaces[name]; ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// allSegments 返回所有命名空间的 segment，顺序是固定的，这样 gcCursor 才有意义。
+func (c *Cache) allSegments() []*segment {
+	var segments []*segment
+	for _, namespace := range c.allNamespaces() {
+		segments = append(segments, namespace.segments...)
+	}
+	return segments
+}
+
 // gc 会清理缓存中过期的数据。
 func (c *Cache) gc() {
 	// 这边会等待持久化完成
 	c.waitForDumping()
+	beginTime := time.Now()
+	if c.options.GcStrategy == AdaptiveGc {
+		c.adaptiveGc(c.current())
+	} else {
+		c.wheelGc(c.current())
+	}
+	atomic.AddInt64(&c.gcStatus.Cycles, 1)
+	atomic.StoreInt64(&c.gcStatus.LastCycleTime, int64(time.Since(beginTime)))
+}
+
+// wheelGc 会并发地清理所有 segment 的时间轮中已经到期的数据。
+func (c *Cache) wheelGc(options *Options) {
 	wg := &sync.WaitGroup{}
-	for _, seg := range c.segments {
+	for _, seg := range c.allSegments() {
 		wg.Add(1)
 		go func(s *segment) {
 			defer wg.Done()
-			s.gc()
+			atomic.AddInt64(&c.gcStatus.Expired, int64(s.gc(options.MaxGcCount)))
 		}(seg)
 	}
 	wg.Wait()
 }
 
+// adaptiveGc 会依次在每个 segment 中抽样清理过期数据。
+// 如果一轮抽样中过期数据的占比超过了 GcExpiredThreshold，说明这个 segment 里可能还有很多过期数据，就马上再抽样一轮。
+// 整个过程不能超过 GcTimeBudget，用完时间预算就结束，下次从中断的 segment 继续。
+func (c *Cache) adaptiveGc(options *Options) {
+	beginTime := time.Now()
+	budget := time.Duration(options.GcTimeBudget) * time.Millisecond
+	segments := c.allSegments()
+	for i := 0; i < len(segments); i++ {
+		index := (c.gcCursor + i) % len(segments)
+		for {
+			sampled, expired := segments[index].sample(options.GcSampleSize)
+			atomic.AddInt64(&c.gcStatus.Sampled, int64(sampled))
+			atomic.AddInt64(&c.gcStatus.Expired, int64(expired))
+			if sampled == 0 || expired*100 <= sampled*options.GcExpiredThreshold {
+				break
+			}
+
+			if time.Since(beginTime) > budget {
+				break
+			}
+		}
+
+		if time.Since(beginTime) > budget {
+			c.gcCursor = (index + 1) % len(segments)
+			atomic.AddInt64(&c.gcStatus.BudgetExceeded, 1)
+			return
+		}
+	}
+}
+
 // AutoGc 会开启一个异步任务去定时清理过期的数据。
+// 每次执行的间隔也就是时间轮一个刻度的时长。
+// 缓存关闭之后这个任务就会退出，间隔必须和时间轮的刻度一致，所以 Reload 不会修改它。
 func (c *Cache) AutoGc() {
+	c.background.Add(1)
 	go func() {
-		ticker := time.NewTicker(time.Duration(c.options.GcDuration) * time.Minute)
+		defer c.background.Done()
+		ticker := time.NewTicker(time.Duration(c.options.gcTick()))
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				c.gc()
+			case <-c.ctx.Done():
+				return
 			}
 		}
 	}()
@@ -157,23 +360,42 @@ func (c *Cache) dump() error {
 	return newDump(c).to(c.options.DumpFile)
 }
 
-// AutoDump 会开启一个异步任务去定时持久化缓存数据。
+// AutoDump 会开启一个异步任务去定时持久化缓存数据，缓存关闭之后这个任务就会退出。
+// Reload 修改了 DumpDuration 的话会马上按照新的间隔执行。
 func (c *Cache) AutoDump() {
+	c.background.Add(1)
 	go func() {
-		ticker := time.NewTicker(time.Duration(c.options.DumpDuration) * time.Minute)
+		defer c.background.Done()
+		ticker := time.NewTicker(time.Duration(c.current().DumpDuration) * time.Minute)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				c.dump()
+			case <-c.reloadedChan():
+				ticker.Reset(time.Duration(c.current().DumpDuration) * time.Minute)
+			case <-c.ctx.Done():
+				return
 			}
 		}
 	}()
 }
 
+// Close 关闭缓存，会停止后台的 gc 和持久化任务，并在等它们退出之后做最后一次持久化。
+// 没有设置持久化文件的话就不会持久化。关闭之后缓存还是可以读写，只是不会再自动清理和持久化了。
+func (c *Cache) Close() error {
+	c.cancel()
+	c.background.Wait()
+	if c.options.DumpFile == "" {
+		return nil
+	}
+	return c.dump()
+}
+
 // waitForDumping 会等待持久化完成才返回。
 func (c *Cache) waitForDumping() {
 	for atomic.LoadInt32(&c.dumping) != 0 {
 		// 每次循环都会等待一定的时间，如果不睡眠，会导致 CPU 空转消耗资源
-		time.Sleep(time.Duration(c.options.CasSleepTime) * time.Microsecond)
+		time.Sleep(time.Duration(c.current().CasSleepTime) * time.Microsecond)
 	}
 }
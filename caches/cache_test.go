@@ -1,6 +1,7 @@
 package caches
 
 import (
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
@@ -49,3 +50,320 @@ func TestCacheSetGet(t *testing.T) {
 
 	t.Logf("读取消耗时间为 %s。", readTime)
 }
+
+// go test -v -run=^TestCacheSetWithDuration$
+func TestCacheSetWithDuration(t *testing.T) {
+
+	cache := NewCache()
+	if err := cache.SetWithDuration("key", []byte("value"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("key should be alive before ttl")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("key should be expired after ttl")
+	}
+
+	if err := cache.SetWithExpireAt("key", []byte("value"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("key should not exist if expireAt is in the past")
+	}
+}
+
+// go test -v -run=^TestCacheExpireMode$
+func TestCacheExpireMode(t *testing.T) {
+
+	cache := NewCache()
+	cache.SetWith("absolute", []byte("value"), SetOptions{Ttl: 100 * time.Millisecond})
+	cache.SetWith("sliding", []byte("value"), SetOptions{Ttl: 100 * time.Millisecond, ExpireMode: ExpireSliding})
+	cache.SetWith("max", []byte("value"), SetOptions{Ttl: 100 * time.Millisecond, ExpireMode: ExpireSlidingWithMax, MaxLifetime: 150 * time.Millisecond})
+
+	// 一直访问数据，只有滑动过期的数据才会延长寿命
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		cache.Get("absolute")
+		cache.Get("sliding")
+		cache.Get("max")
+	}
+
+	if _, ok := cache.Get("absolute"); ok {
+		t.Fatal("absolute key should be expired even if it is visited")
+	}
+
+	if _, ok := cache.Get("sliding"); !ok {
+		t.Fatal("sliding key should be alive while it is visited")
+	}
+
+	if _, ok := cache.Get("max"); ok {
+		t.Fatal("sliding key should be expired after max lifetime")
+	}
+}
+
+// go test -v -run=^TestCacheTTL$
+func TestCacheTTL(t *testing.T) {
+
+	cache := NewCache()
+	if cache.Expire("key", time.Second) {
+		t.Fatal("expire should fail if key doesn't exist")
+	}
+
+	cache.Set("key", []byte("value"))
+	if ttl, ok := cache.TTL("key"); !ok || ttl != NeverDie {
+		t.Fatalf("ttl of key should be NeverDie but got %s", ttl)
+	}
+
+	cache.Expire("key", time.Minute)
+	if ttl, ok := cache.TTL("key"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("ttl of key should be in (0, 1m] but got %s", ttl)
+	}
+
+	cache.Persist("key")
+	if ttl, _ := cache.TTL("key"); ttl != NeverDie {
+		t.Fatalf("ttl of key should be NeverDie after persist but got %s", ttl)
+	}
+
+	cache.Expire("key", 0)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("key should be deleted if ttl isn't positive")
+	}
+}
+
+// go test -v -run=^TestSegmentDeleteExpired$
+func TestSegmentDeleteExpired(t *testing.T) {
+
+	for _, engine := range []string{MapStorage, ArenaStorage} {
+		options := DefaultOptions()
+		options.StorageEngine = engine
+		options.MaxMemory = "64MB"
+		cache := newCache(options)
+		segment := cache.defaultNamespace.segmentOf("key")
+		cache.SetWithDuration("key", []byte("value"), 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		expired, _ := segment.data.get("key")
+
+		// 模拟读取时发现数据过期，在换成写锁之前数据被重新写入了，新的数据不能被删除，创建时间也不能沿用过期的数据
+		cache.Set("key", []byte("fresh"))
+		segment.deleteExpired("key", expired)
+		if value, ok := cache.Get("key"); !ok || string(value) != "fresh" {
+			t.Fatalf("%s: fresh value should not be deleted but got %s, %v", engine, value, ok)
+		}
+
+		if meta, _ := cache.Meta("key"); meta.CreatedAt.UnixNano() == expired.Ctime {
+			t.Fatalf("%s: overwriting an expired value should not inherit its ctime", engine)
+		}
+
+		cache.SetWithDuration("other", []byte("value"), 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := cache.Get("other"); ok || cache.Status().Count != 1 {
+			t.Fatalf("%s: expired value should be deleted but got status %+v", engine, cache.Status())
+		}
+	}
+}
+
+// go test -v -run=^TestCacheAutoGc$
+func TestCacheAutoGc(t *testing.T) {
+
+	for _, strategy := range []string{WheelGc, AdaptiveGc} {
+		options := DefaultOptions()
+		options.GcTick = 10
+		options.GcStrategy = strategy
+		cache := NewCacheWith(options)
+		cache.AutoGc()
+
+		for i := 0; i < 1000; i++ {
+			cache.SetWithDuration(strconv.Itoa(i), []byte("value"), 50*time.Millisecond)
+		}
+		cache.Set("persistent", []byte("value"))
+
+		// 不访问数据，过期的数据也要被 gc 清理掉
+		time.Sleep(200 * time.Millisecond)
+		status := cache.Status()
+		if status.Count != 1 || status.ExpiredBacklog != 0 || status.Gc.Expired != 1000 {
+			t.Fatalf("expired entries should be cleaned by %s gc but got status %+v", strategy, status)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheMaxMemory$
+func TestCacheMaxMemory(t *testing.T) {
+
+	options := DefaultOptions()
+	options.MaxMemory = "64KB"
+	cache := NewCacheWith(options)
+
+	// 内存上限是整个缓存共享的，不会按 segment 的个数平分，所以即使 segment 很多也能用满
+	value := make([]byte, 1024)
+	count := 0
+	for cache.Set(strconv.Itoa(count), value) == nil {
+		count++
+	}
+
+	status := cache.Status()
+	if status.MaxMemory != 64*1024 || status.UsedMemory > status.MaxMemory {
+		t.Fatalf("used memory should not exceed the limit but got status %+v", status)
+	}
+
+	if count < 50 || count >= 64 {
+		t.Fatalf("count of entries %d should be close to the limit", count)
+	}
+
+	cache.Delete("0")
+	if err := cache.Set("0", value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// go test -v -run=^TestCacheFlush$
+func TestCacheFlush(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	ns, _ := cache.Namespace("flush")
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		cache.SetWith(key, []byte(key), SetOptions{Ttl: time.Minute, Tags: []string{"tag"}})
+		ns.Set(key, []byte(key))
+	}
+
+	// 清空的同时还在不停地写入，清空之后的状态要和剩下的数据保持一致
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cache.Set("concurrent"+strconv.Itoa(i), []byte("value"))
+		}
+	}()
+	cache.Flush()
+	wg.Wait()
+
+	if _, ok := ns.Get("1"); ok {
+		t.Fatal("data in all namespaces should be flushed")
+	}
+
+	if invalidated := cache.InvalidateTag("tag"); invalidated != 0 {
+		t.Fatalf("tags should be flushed but %d entries are invalidated", invalidated)
+	}
+
+	count := 0
+	for i := 0; i < 1000; i++ {
+		if _, ok := cache.Get("concurrent" + strconv.Itoa(i)); ok {
+			count++
+		}
+	}
+
+	status := cache.Status()
+	if status.Count != count || status.UsedMemory != cache.defaultNamespace.Status().UsedMemory {
+		t.Fatalf("status should match %d remaining entries but got %+v", count, status)
+	}
+}
+
+// go test -v -run=^TestCacheClose$
+func TestCacheClose(t *testing.T) {
+
+	options := DefaultOptions()
+	options.GcTick = 10
+	options.DumpFile = filepath.Join(t.TempDir(), "close.dump")
+	cache := NewCacheWith(options)
+	cache.AutoGc()
+	cache.AutoDump()
+	cache.Set("key", []byte("value"))
+
+	// 关闭之后后台任务要退出，并且最后一次持久化的数据可以恢复出来
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cycles := cache.Status().Gc.Cycles
+	time.Sleep(50 * time.Millisecond)
+	if cache.Status().Gc.Cycles != cycles {
+		t.Fatal("gc should stop after closing the cache")
+	}
+
+	recovered := NewCacheWith(options)
+	if value, ok := recovered.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("the final dump should contain key but got %s, %v", value, ok)
+	}
+}
+
+// go test -v -run=^TestCacheRestoreLegacyDump$
+func TestCacheRestoreLegacyDump(t *testing.T) {
+
+	// 以前版本持久化的数据中 Ttl 和 Ctime 的单位都是秒
+	now := time.Now().Unix()
+	tests := []struct {
+		key   string
+		value *value
+		alive bool
+	}{
+		{key: "expired", value: &value{Data: []byte("expired"), Ttl: 1, Ctime: now - 100}, alive: false},
+		{key: "alive", value: &value{Data: []byte("alive"), Ttl: 1000, Ctime: now - 100}, alive: true},
+		{key: "permanent", value: &value{Data: []byte("permanent"), Ttl: NeverDie, Ctime: now - 100}, alive: true},
+	}
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(t.TempDir(), "legacy.dump")
+	data := make(map[string]*value)
+	for _, test := range tests {
+		data[test.key] = test.value
+	}
+	d := &dump{SegmentSize: 1, Segments: []*segmentDump{{Data: data}}, Options: &options}
+	if err := d.to(options.DumpFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCacheWith(options)
+	for _, test := range tests {
+		if _, ok := cache.Get(test.key); ok != test.alive {
+			t.Fatalf("%s should be alive %v after restoring", test.key, test.alive)
+		}
+	}
+
+	if ttl, _ := cache.TTL("alive"); ttl < 890*time.Second || ttl > 900*time.Second {
+		t.Fatalf("ttl of alive should be about 900s but got %s", ttl)
+	}
+
+	if ttl, _ := cache.TTL("permanent"); ttl != NeverDie {
+		t.Fatalf("ttl of permanent should be NeverDie but got %s", ttl)
+	}
+}
+
+// go test -v -run=^TestCacheRestoreWithRuntimeOptions$
+func TestCacheRestoreWithRuntimeOptions(t *testing.T) {
+
+	dumped := DefaultOptions()
+	dumped.MaxMemory = "1MB"
+	dumped.SegmentSize = 1
+	dumped.GcTick = 10
+	dumped.DumpFile = filepath.Join(t.TempDir(), "options.dump")
+	cache := newCache(dumped)
+	cache.Set("key", []byte("value"))
+	if err := newDump(cache).to(dumped.DumpFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// 持久化的配置不能覆盖运行时的配置，数据还是要恢复出来
+	options := DefaultOptions()
+	options.MaxMemory = "2MB"
+	options.SegmentSize = 16
+	options.GcTick = 50
+	options.DumpFile = dumped.DumpFile
+	recovered := NewCacheWith(options)
+	if value, ok := recovered.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("key should be restored but got %s, %v", value, ok)
+	}
+
+	if status := recovered.Status(); status.MaxMemory != 2*1024*1024 {
+		t.Fatalf("max memory should be 2MB but got %d", status.MaxMemory)
+	}
+
+	if recovered.current().GcTick != 50 || recovered.defaultNamespace.segmentSize != 16 {
+		t.Fatalf("runtime options should be used but got %+v", recovered.current())
+	}
+}
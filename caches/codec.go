@@ -0,0 +1,104 @@
+package caches
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+var (
+	// notProtoMessageErr 是数据没有实现 protobuf 序列化方法的错误。
+	notProtoMessageErr = errors.New("value doesn't implement Marshal() ([]byte, error) and Unmarshal([]byte) error")
+)
+
+// Codec 是序列化和反序列化数据的编解码器，TypedCache 使用它将数据转换成字节存储到缓存中。
+type Codec interface {
+
+	// Marshal 将 v 序列化成字节。
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal 将字节反序列化到 v 中，v 必须是一个指针。
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec 是使用 JSON 格式的编解码器。
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec 是使用 gob 格式的编解码器，只适合 Go 程序之间使用。
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	err := gob.NewEncoder(buffer).Encode(v)
+	return buffer.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MsgpackCodec 是使用 msgpack 格式的编解码器，序列化后的数据比 JSON 更小。
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, &codec.MsgpackHandle{}).Encode(v)
+	return data, err
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, &codec.MsgpackHandle{}).Decode(v)
+}
+
+// protoMessage 是 protobuf 生成的代码中带有的序列化方法，比如 gogo/protobuf 和 vtprotobuf 生成的代码。
+type protoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// ProtoCodec 是使用 protobuf 格式的编解码器。
+// 为了不引入 protobuf 的依赖，这里要求数据自己实现 Marshal 和 Unmarshal 方法，没有实现的话会返回错误。
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(protoMessage)
+	if !ok {
+		return nil, notProtoMessageErr
+	}
+	return message.Marshal()
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	if message, ok := v.(protoMessage); ok {
+		return message.Unmarshal(data)
+	}
+
+	// 数据类型一般是 *Message，所以这里传进来的 v 会是 **Message，需要先创建出 Message 实例
+	pointer := reflect.ValueOf(v)
+	if pointer.Kind() != reflect.Ptr || pointer.Elem().Kind() != reflect.Ptr {
+		return notProtoMessageErr
+	}
+
+	message := reflect.New(pointer.Elem().Type().Elem())
+	if _, ok := message.Interface().(protoMessage); !ok {
+		return notProtoMessageErr
+	}
+
+	if err := message.Interface().(protoMessage).Unmarshal(data); err != nil {
+		return err
+	}
+	pointer.Elem().Set(message)
+	return nil
+}
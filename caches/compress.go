@@ -0,0 +1,151 @@
+package caches
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// NoCompression 表示不压缩数据。
+	NoCompression = "none"
+
+	// SnappyCompression 是 snappy 压缩算法，压缩率一般，但是速度非常快。
+	SnappyCompression = "snappy"
+
+	// ZstdCompression 是 zstd 压缩算法，压缩率和速度都比较好。
+	ZstdCompression = "zstd"
+
+	// GzipCompression 是 gzip 压缩算法，压缩率比较高，但是速度比较慢。
+	GzipCompression = "gzip"
+)
+
+const (
+	// compressionMask 是 value.Flags 中记录压缩算法的位，0 表示数据没有压缩。
+	// 因为压缩算法记录在数据上，所以修改了配置之后，以前压缩过的数据也还能正常读取。
+	compressionMask = 0x0f
+)
+
+// compressor 是一种压缩算法。
+type compressor struct {
+
+	// id 是压缩算法记录在 value.Flags 中的编号，不能修改，否则持久化的数据就读不出来了。
+	id byte
+
+	// compress 压缩数据。
+	compress func(data []byte) ([]byte, error)
+
+	// decompress 解压数据。
+	decompress func(data []byte) ([]byte, error)
+}
+
+var (
+	// zstdEncoder 和 zstdDecoder 是共享的 zstd 编解码器，它们的 EncodeAll 和 DecodeAll 方法是并发安全的。
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+
+	// compressors 存储着所有支持的压缩算法。
+	compressors = map[string]*compressor{
+		SnappyCompression: {
+			id: 1,
+			compress: func(data []byte) ([]byte, error) {
+				return snappy.Encode(nil, data), nil
+			},
+			decompress: func(data []byte) ([]byte, error) {
+				return snappy.Decode(nil, data)
+			},
+		},
+		ZstdCompression: {
+			id: 2,
+			compress: func(data []byte) ([]byte, error) {
+				return zstdEncoder.EncodeAll(data, nil), nil
+			},
+			decompress: func(data []byte) ([]byte, error) {
+				return zstdDecoder.DecodeAll(data, nil)
+			},
+		},
+		GzipCompression: {
+			id:         3,
+			compress:   gzipCompress,
+			decompress: gzipDecompress,
+		},
+	}
+
+	// compressorsById 是压缩算法的编号到压缩算法的映射。
+	compressorsById = func() map[byte]*compressor {
+		result := make(map[byte]*compressor, len(compressors))
+		for _, c := range compressors {
+			result[c.id] = c
+		}
+		return result
+	}()
+)
+
+// gzipCompress 使用 gzip 压缩数据。
+func gzipCompress(data []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// gzipDecompress 解压 gzip 压缩过的数据。
+func gzipDecompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// compressorOf 返回名为 name 的压缩算法，不压缩的话返回 nil。
+func compressorOf(name string) (*compressor, error) {
+	if name == "" || name == NoCompression {
+		return nil, nil
+	}
+
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %s", name)
+	}
+	return c, nil
+}
+
+// compress 使用 c 压缩数据，并返回压缩后的数据和需要记录在 value.Flags 中的压缩算法编号。
+// 数据小于 threshold 或者压缩之后并没有变小的话就不压缩，这时返回的编号是 0。
+func compress(c *compressor, threshold int, data []byte) ([]byte, byte) {
+	if c == nil || len(data) < threshold {
+		return data, 0
+	}
+
+	compressed, err := c.compress(data)
+	if err != nil || len(compressed) >= len(data) {
+		return data, 0
+	}
+	return compressed, c.id
+}
+
+// decompress 根据 flags 中记录的压缩算法解压数据，没有压缩过的数据会原样返回。
+func decompress(flags byte, data []byte) ([]byte, error) {
+	id := flags & compressionMask
+	if id == 0 {
+		return data, nil
+	}
+
+	c, ok := compressorsById[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression id %d", id)
+	}
+	return c.decompress(data)
+}
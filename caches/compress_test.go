@@ -0,0 +1,47 @@
+package caches
+
+import (
+	"bytes"
+	"testing"
+)
+
+// go test -v -run=^TestCacheCompression$
+func TestCacheCompression(t *testing.T) {
+
+	for _, engine := range []string{MapStorage, ArenaStorage} {
+		for _, compression := range []string{SnappyCompression, ZstdCompression, GzipCompression} {
+			options := DefaultOptions()
+			options.MaxMemory = "16MB"
+			options.SegmentSize = 4
+			options.StorageEngine = engine
+			options.Compression = compression
+			options.CompressionThreshold = 64
+			cache := newCache(options)
+
+			large := bytes.Repeat([]byte(`{"name":"kafo","tags":["cache"]}`), 64)
+			small := []byte("small")
+			cache.Set("large", large)
+			cache.Set("small", small)
+
+			if got, ok := cache.Get("large"); !ok || !bytes.Equal(got, large) {
+				t.Fatalf("%s %s: large value is broken after compression", engine, compression)
+			}
+
+			if got, ok := cache.Get("small"); !ok || !bytes.Equal(got, small) {
+				t.Fatalf("%s %s: small value is broken", engine, compression)
+			}
+
+			status := cache.Status()
+			if status.CompressedCount != 1 || status.RawValueSize != int64(len(large)+len(small)) || status.ValueSize >= status.RawValueSize {
+				t.Fatalf("%s %s: wrong status %+v", engine, compression, status)
+			}
+
+			// 删除之后压缩的记录也要减掉
+			cache.Delete("large")
+			status = cache.Status()
+			if status.CompressedCount != 0 || status.RawValueSize != int64(len(small)) || status.ValueSize != int64(len(small)) {
+				t.Fatalf("%s %s: wrong status after deleting %+v", engine, compression, status)
+			}
+		}
+	}
+}
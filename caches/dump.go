@@ -3,7 +3,6 @@ package caches
 import (
 	"encoding/gob"
 	"os"
-	"sync"
 	"time"
 )
 
@@ -11,11 +10,36 @@ type dump struct {
 	// SegmentSize 是 segment 的数量。
 	SegmentSize int
 
-	// Segments 存储着所有的 segment 实例。
-	Segments []*segment
+	// Segments 存储着所有 segment 的数据。
+	Segments []*segmentDump
 
-	// Options 是缓存的选项配置。
+	// Options 是持久化时缓存的选项配置，只用来排查问题，恢复时使用的是运行时的配置。
 	Options *Options
+
+	// Namespaces 存储着除了默认命名空间之外所有命名空间的数据，默认命名空间的数据还是存储在 Segments 中，这样可以兼容以前的持久化文件。
+	Namespaces map[string]*namespaceDump
+
+	// Tags 存储着默认命名空间中每个 key 的标签。
+	Tags map[string][]string
+}
+
+// namespaceDump 是一个命名空间持久化的数据。
+type namespaceDump struct {
+	// Options 是命名空间的选项配置。
+	Options NamespaceOptions
+
+	// Segments 存储着这个命名空间所有 segment 的数据。
+	Segments []*segmentDump
+
+	// Tags 存储着这个命名空间中每个 key 的标签。
+	Tags map[string][]string
+}
+
+// segmentDump 是一个 segment 持久化的数据。
+// 因为 segment 的存储引擎是可以选择的，所以这里统一转换成 map 再持久化，恢复的时候可以使用另一种存储引擎。
+type segmentDump struct {
+	// Data 存储着这个 segment 的数据。
+	Data map[string]*value
 }
 
 // newEmptyDump 返回一个空的持久化实例。
@@ -25,10 +49,51 @@ func newEmptyDump() *dump {
 
 // newDump 返回一个从缓存实例初始化过来的持久化实例。
 func newDump(c *Cache) *dump {
+	namespaces := make(map[string]*namespaceDump)
+	for _, namespace := range c.allNamespaces() {
+		if namespace.name != DefaultNamespace {
+			namespaces[namespace.name] = &namespaceDump{
+				Options:  namespace.options,
+				Segments: namespace.dump(),
+				Tags:     namespace.tags.snapshot(),
+			}
+		}
+	}
+
 	return &dump{
-		SegmentSize: c.segmentSize,
-		Segments:    c.segments,
-		Options:     c.options,
+		SegmentSize: c.defaultNamespace.segmentSize,
+		Segments:    c.defaultNamespace.dump(),
+		Options:     c.current(),
+		Namespaces:  namespaces,
+		Tags:        c.defaultNamespace.tags.snapshot(),
+	}
+}
+
+// dump 返回命名空间中所有 segment 需要持久化的数据。
+func (ns *Namespace) dump() []*segmentDump {
+	segments := make([]*segmentDump, len(ns.segments))
+	for i, segment := range ns.segments {
+		segments[i] = segment.dump()
+	}
+	return segments
+}
+
+// restore 将持久化的数据和标签恢复到命名空间中，已经过期的数据和它们的标签不会被恢复。
+// 因为哈希算法的种子每次都不一样，所以数据需要重新选择 segment。
+func (ns *Namespace) restore(segments []*segmentDump, tags map[string][]string) {
+	restored := make(map[string]bool)
+	for _, segmentDump := range segments {
+		for key, value := range segmentDump.Data {
+			if ok, _ := ns.segmentOf(key).restore(key, value); ok {
+				restored[key] = true
+			}
+		}
+	}
+
+	for key, keyTags := range tags {
+		if restored[key] {
+			ns.tags.replace(key, keyTags)
+		}
 	}
 }
 
@@ -59,8 +124,8 @@ func (d *dump) to(dumpFile string) error {
 	return os.Rename(newDumpFile, dumpFile)
 }
 
-// from 返回一个从持久化文件中恢复的缓存实例。
-func (d *dump) from(dumpFile string) (*Cache, error) {
+// from 返回一个从持久化文件中恢复的缓存实例，缓存使用 options 作为配置。
+func (d *dump) from(dumpFile string, options Options) (*Cache, error) {
 
 	file, err := os.Open(dumpFile)
 	if err != nil {
@@ -72,16 +137,19 @@ func (d *dump) from(dumpFile string) (*Cache, error) {
 		return nil, err
 	}
 
-	// 恢复出 segment 之后需要为每一个 segment 的未导出字段进行初始化
-	for _, segment := range d.Segments {
-		segment.options = d.Options
-		segment.lock = &sync.RWMutex{}
+	// 使用运行时的选项配置重新创建缓存，并把数据添加进去，这样过期时间和内存占用也会重新记录
+	// 持久化的选项配置不能覆盖配置文件、环境变量和命令行设置的配置，否则修改配置之后重启也不会生效
+	// 恢复时每个数据都会重新选择 segment，所以 segment 的个数和哈希算法修改了也没关系
+	cache := newCache(options)
+	cache.defaultNamespace.restore(d.Segments, d.Tags)
+
+	// 运行时配置了的命名空间已经创建好了，这里会直接返回它们，持久化的命名空间配置只用于没有配置的命名空间
+	for name, namespaceDump := range d.Namespaces {
+		namespace, err := cache.createNamespace(name, namespaceDump.Options, false)
+		if err != nil {
+			return nil, err
+		}
+		namespace.restore(namespaceDump.Segments, namespaceDump.Tags)
 	}
-
-	return &Cache{
-		segmentSize: d.SegmentSize,
-		segments:    d.Segments,
-		options:     d.Options,
-		dumping:     0,
-	}, nil
+	return cache, nil
 }
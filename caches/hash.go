@@ -0,0 +1,101 @@
+package caches
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// MaphashHash 是 Go 标准库中的 maphash 算法，每个进程使用随机的种子，可以抵御哈希洪水攻击。
+	MaphashHash = "maphash"
+
+	// FnvHash 是 FNV-1a 算法，实现简单，但是没有种子，无法抵御哈希洪水攻击，只适合 key 不受客户端控制的场景。
+	FnvHash = "fnv"
+
+	// XxHash 是 xxhash 算法，速度很快，分布也很好，每个进程使用随机的种子，可以抵御哈希洪水攻击。
+	XxHash = "xxhash"
+)
+
+// Hasher 是计算 key 哈希值的函数，缓存使用它来选择 segment。
+type Hasher func(key string) uint64
+
+var (
+	// hashers 存储着所有注册的哈希算法，值是使用种子创建 Hasher 的函数。
+	hashers = map[string]func(seed uint64) Hasher{
+		MaphashHash: newMaphashHasher,
+		FnvHash:     newFnvHasher,
+		XxHash:      newXxHasher,
+	}
+
+	// hashersLock 用于保护 hashers。
+	hashersLock = &sync.RWMutex{}
+)
+
+// RegisterHasher 注册一个名为 name 的哈希算法，注册之后就可以在 Options.HashFunction 中使用了。
+// newHasher 的参数是一个随机的种子，哈希算法可以用它来抵御哈希洪水攻击。
+func RegisterHasher(name string, newHasher func(seed uint64) Hasher) {
+	hashersLock.Lock()
+	defer hashersLock.Unlock()
+	hashers[name] = newHasher
+}
+
+// newHasher 返回名为 name 的哈希算法，每次返回的 Hasher 都使用不同的随机种子。
+func newHasher(name string) (Hasher, error) {
+	hashersLock.RLock()
+	defer hashersLock.RUnlock()
+	newHasher, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %s", name)
+	}
+	return newHasher(rand.New(rand.NewSource(time.Now().UnixNano())).Uint64()), nil
+}
+
+// newMaphashHasher 返回一个 maphash 算法的 Hasher。
+// maphash 的种子只能由标准库生成，所以这里忽略了传进来的种子。
+func newMaphashHasher(seed uint64) Hasher {
+	mapSeed := maphash.MakeSeed()
+	return func(key string) uint64 {
+		var hash maphash.Hash
+		hash.SetSeed(mapSeed)
+		hash.WriteString(key)
+		return hash.Sum64()
+	}
+}
+
+// newFnvHasher 返回一个 FNV-1a 算法的 Hasher，这里直接实现是为了避免标准库中的内存分配。
+// 只修改 FNV 的初始值并不能让碰撞的 key 分开，所以这里忽略了传进来的种子。
+func newFnvHasher(seed uint64) Hasher {
+	return func(key string) uint64 {
+		hash := uint64(14695981039346656037)
+		for i := 0; i < len(key); i++ {
+			hash ^= uint64(key[i])
+			hash *= 1099511628211
+		}
+		return hash
+	}
+}
+
+// newXxHasher 返回一个使用 seed 作为种子的 xxhash 算法的 Hasher。
+func newXxHasher(seed uint64) Hasher {
+	return func(key string) uint64 {
+		var digest xxhash.Digest
+		digest.ResetWithSeed(seed)
+		digest.WriteString(key)
+		return digest.Sum64()
+	}
+}
+
+// roundToPowerOfTwo 将 n 向上取整到 2 的幂。
+// segment 的个数必须是 2 的幂，这样才能使用 & 运算代替取模来选择 segment。
+func roundToPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power <<= 1
+	}
+	return power
+}
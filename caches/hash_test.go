@@ -0,0 +1,91 @@
+package caches
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// keyPatterns 是常见的 key 格式，用于测试哈希算法的分布情况。
+var keyPatterns = map[string]func(i int) string{
+	"number":  strconv.Itoa,
+	"prefix":  func(i int) string { return "user:" + strconv.Itoa(i) },
+	"suffix":  func(i int) string { return strconv.Itoa(i) + ":session" },
+	"padding": func(i int) string { return fmt.Sprintf("order-%010d", i) },
+}
+
+// distribution 返回 count 个 key 落在 segmentSize 个 segment 中的变异系数，越小说明分布越均匀。
+func distribution(hash Hasher, pattern func(i int) string, count int, segmentSize int) float64 {
+	counts := make([]int, segmentSize)
+	for i := 0; i < count; i++ {
+		counts[hash(pattern(i))&uint64(segmentSize-1)]++
+	}
+
+	mean := float64(count) / float64(segmentSize)
+	variance := 0.0
+	for _, c := range counts {
+		variance += (float64(c) - mean) * (float64(c) - mean)
+	}
+	return math.Sqrt(variance/float64(segmentSize)) / mean
+}
+
+// go test -v -run=^TestRoundToPowerOfTwo$
+func TestRoundToPowerOfTwo(t *testing.T) {
+	for n, expected := range map[int]int{0: 1, 1: 1, 2: 2, 3: 4, 1000: 1024, 1024: 1024} {
+		if got := roundToPowerOfTwo(n); got != expected {
+			t.Fatalf("%d should be rounded to %d but got %d", n, expected, got)
+		}
+	}
+
+	options := DefaultOptions()
+	options.SegmentSize = 1000
+	if cache := NewCacheWith(options); len(cache.defaultNamespace.segments) != 1024 {
+		t.Fatalf("segment size should be rounded to 1024 but got %d", len(cache.defaultNamespace.segments))
+	}
+}
+
+// go test -v -run=^TestHasherSeed$
+func TestHasherSeed(t *testing.T) {
+
+	// 使用种子的算法在不同的种子下要得到不同的哈希值，这样客户端就没法提前构造出碰撞的 key
+	tests := []struct {
+		newHasher func(seed uint64) Hasher
+		name      string
+		seeded    bool
+	}{
+		{newHasher: newMaphashHasher, name: MaphashHash, seeded: true},
+		{newHasher: newXxHasher, name: XxHash, seeded: true},
+		{newHasher: newFnvHasher, name: FnvHash, seeded: false},
+	}
+
+	for _, test := range tests {
+		first, second := test.newHasher(1), test.newHasher(2)
+		if first("key") != first("key") {
+			t.Fatalf("%s should return the same hash for the same key", test.name)
+		}
+
+		if seeded := first("key") != second("key"); seeded != test.seeded {
+			t.Fatalf("%s should be seeded %v", test.name, test.seeded)
+		}
+	}
+}
+
+// go test -v -run=^$ -bench=^BenchmarkHasherDistribution$
+func BenchmarkHasherDistribution(b *testing.B) {
+	for _, name := range []string{MaphashHash, FnvHash, XxHash} {
+		for patternName, pattern := range keyPatterns {
+			b.Run(name+"/"+patternName, func(b *testing.B) {
+				hash, err := newHasher(name)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				for i := 0; i < b.N; i++ {
+					hash(pattern(i))
+				}
+				b.ReportMetric(distribution(hash, pattern, 1000000, 1024), "cv")
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package caches
+
+import (
+	"math"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// mapEntryOverhead 是 map 中一个键值对额外占用的空间，包括 key 的字符串头、指向 value 的指针以及 tophash 等。
+	// 这是一个估算值，map 的 bucket 和扩容带来的空间浪费都平摊在这里面了。
+	mapEntryOverhead = int64(unsafe.Sizeof("")) + int64(unsafe.Sizeof(&value{})) + 8
+)
+
+// unlimitedMemory 表示内存没有上限，只受上级内存记录的约束。
+const unlimitedMemory = math.MaxInt64
+
+// entryOverhead 是 map 存储引擎中每个键值对除了 key 和 value 本身之外额外占用的空间。
+var entryOverhead = mapEntryOverhead + int64(unsafe.Sizeof(value{}))
+
+// memory 记录着整个缓存的内存使用情况，所有 segment 共享同一个实例。
+// 这样即使 key 的分布不均匀，某些 segment 的数据特别多，也能用满整个缓存的内存上限。
+// 每个命名空间也有自己的 memory 实例，它的上级就是整个缓存的实例，申请内存时两个上限都要满足。
+type memory struct {
+
+	// used 是已经使用的内存大小，需要使用 atomic 包进行读写。
+	used int64
+
+	// limit 是内存的上限，可以在运行时修改，需要使用 atomic 包进行读写。
+	limit int64
+
+	// parent 是上级的内存记录，为 nil 表示没有上级。
+	parent *memory
+}
+
+// newMemory 返回一个上限为 limit 的内存记录实例，parent 是上级的内存记录。
+func newMemory(limit int64, parent *memory) *memory {
+	return &memory{
+		limit:  limit,
+		parent: parent,
+	}
+}
+
+// acquire 申请 n 个字节的内存，如果超过了上限就返回 false。
+// n 可以是负数，表示释放内存，这种情况肯定会成功。
+func (m *memory) acquire(n int64) bool {
+	if atomic.AddInt64(&m.used, n) > atomic.LoadInt64(&m.limit) && n > 0 {
+		atomic.AddInt64(&m.used, -n)
+		return false
+	}
+
+	if m.parent != nil && !m.parent.acquire(n) {
+		atomic.AddInt64(&m.used, -n)
+		return false
+	}
+	return true
+}
+
+// grow 不检查上限直接记录 n 个字节的内存，用于从持久化文件中恢复数据。
+func (m *memory) grow(n int64) {
+	atomic.AddInt64(&m.used, n)
+	if m.parent != nil {
+		m.parent.grow(n)
+	}
+}
+
+// release 释放 n 个字节的内存。
+func (m *memory) release(n int64) {
+	atomic.AddInt64(&m.used, -n)
+	if m.parent != nil {
+		m.parent.release(n)
+	}
+}
+
+// reset 把已经使用的内存清零，并从上级中释放掉这部分内存。
+func (m *memory) reset() {
+	used := atomic.SwapInt64(&m.used, 0)
+	if m.parent != nil {
+		m.parent.release(used)
+	}
+}
+
+// usage 返回已经使用的内存大小。
+func (m *memory) usage() int64 {
+	return atomic.LoadInt64(&m.used)
+}
+
+// max 返回实际能使用的内存上限，没有上限的话就是上级的上限。
+func (m *memory) max() int64 {
+	limit := atomic.LoadInt64(&m.limit)
+	if limit == unlimitedMemory && m.parent != nil {
+		return m.parent.max()
+	}
+	return limit
+}
+
+// setLimit 修改内存的上限，已经使用的内存超过新的上限的话，要等到内存释放之后才能继续写入。
+func (m *memory) setLimit(limit int64) {
+	atomic.StoreInt64(&m.limit, limit)
+}
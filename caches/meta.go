@@ -0,0 +1,123 @@
+package caches
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxContentTypeSize 是数据的内容类型最多能有多少个字节，arena 引擎中只使用一个字节记录它的长度。
+	maxContentTypeSize = 255
+)
+
+var (
+	// contentTypeTooLongErr 是数据的内容类型太长的错误。
+	contentTypeTooLongErr = errors.New("the content type is too long")
+
+	// PreconditionFailedErr 是写入数据时 SetOptions.IfVersion 或者 SetOptions.IfAbsent 的条件不满足的错误。
+	PreconditionFailedErr = errors.New("the precondition of setting this entry failed")
+)
+
+// Meta 是数据的元信息。
+type Meta struct {
+
+	// Version 是数据的版本号，每次写入数据都会得到一个更大的版本号，可以用来判断数据有没有被修改过。
+	// 版本号在整个缓存中递增，所以删除之后重新写入的数据也不会和以前的版本号重复。
+	Version uint64 `json:"version"`
+
+	// CreatedAt 是数据第一次写入的时间，覆盖数据时保持不变。
+	CreatedAt time.Time `json:"createdAt"`
+
+	// UpdatedAt 是数据最后一次写入的时间。
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Flags 是客户端写入数据时设置的标记，缓存不会理解它的含义，类似于 memcached 中的 flags。
+	Flags uint32 `json:"flags"`
+
+	// ContentType 是客户端写入数据时设置的内容类型，比如 application/json。
+	ContentType string `json:"contentType"`
+
+	// Size 是数据压缩之前的大小。
+	Size int `json:"size"`
+}
+
+// meta 返回这个数据的元信息。
+func (v *value) meta() Meta {
+	return Meta{
+		Version:     v.Version,
+		CreatedAt:   time.Unix(0, v.Ctime),
+		UpdatedAt:   time.Unix(0, v.Mtime),
+		Flags:       v.ClientFlags,
+		ContentType: v.ContentType,
+		Size:        v.rawSize(),
+	}
+}
+
+// nextVersion 返回 versions 的下一个版本号。
+func nextVersion(versions *uint64) uint64 {
+	return atomic.AddUint64(versions, 1)
+}
+
+// observeVersion 保证 versions 不小于 version，恢复持久化的数据时需要调用，这样新的版本号才不会和恢复的数据重复。
+func observeVersion(versions *uint64, version uint64) {
+	for {
+		current := atomic.LoadUint64(versions)
+		if current >= version || atomic.CompareAndSwapUint64(versions, current, version) {
+			return
+		}
+	}
+}
+
+// Meta 返回指定 key 的数据的元信息，这个方法不会解压数据。
+func (ns *Namespace) Meta(key string) (Meta, bool) {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	v, ok := ns.segmentOf(key).get(key)
+	if !ok {
+		return Meta{}, false
+	}
+	return v.meta(), true
+}
+
+// GetWithMeta 返回指定 key 的数据和它的元信息。
+func (ns *Namespace) GetWithMeta(key string) ([]byte, Meta, bool) {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	v, ok := ns.segmentOf(key).get(key)
+	if !ok {
+		return nil, Meta{}, false
+	}
+
+	// 解压失败说明数据已经损坏了，当作不存在处理
+	data, err := decompress(v.Flags, v.Data)
+	if err != nil {
+		return nil, Meta{}, false
+	}
+	return data, v.meta(), true
+}
+
+// SetWithMeta 和 SetWith 一样添加数据到缓存中，并返回写入的数据的元信息，比如新的版本号。
+// 如果 options.ExpireAt 已经过去了，数据会被直接删除，这时返回的元信息是零值。
+func (ns *Namespace) SetWithMeta(key string, value []byte, options SetOptions) (Meta, error) {
+	v, err := ns.set(key, value, options)
+	if err != nil || v == nil {
+		return Meta{}, err
+	}
+	return v.meta(), nil
+}
+
+// Meta 返回默认命名空间中指定 key 的数据的元信息。
+func (c *Cache) Meta(key string) (Meta, bool) {
+	return c.defaultNamespace.Meta(key)
+}
+
+// GetWithMeta 返回默认命名空间中指定 key 的数据和它的元信息。
+func (c *Cache) GetWithMeta(key string) ([]byte, Meta, bool) {
+	return c.defaultNamespace.GetWithMeta(key)
+}
+
+// SetWithMeta 添加数据到默认命名空间中，并返回写入的数据的元信息。
+func (c *Cache) SetWithMeta(key string, value []byte, options SetOptions) (Meta, error) {
+	return c.defaultNamespace.SetWithMeta(key, value, options)
+}
@@ -0,0 +1,117 @@
+package caches
+
+import (
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestCacheMeta$
+func TestCacheMeta(t *testing.T) {
+
+	for _, engine := range []string{MapStorage, ArenaStorage} {
+		options := DefaultOptions()
+		options.MaxMemory = "16MB"
+		options.SegmentSize = 4
+		options.StorageEngine = engine
+		cache := newCache(options)
+
+		cache.SetWith("user", []byte(`{"id":1}`), SetOptions{Flags: 7, ContentType: "application/json"})
+		data, first, ok := cache.GetWithMeta("user")
+		if !ok || string(data) != `{"id":1}` {
+			t.Fatalf("%s: wrong data %s", engine, data)
+		}
+
+		if first.Version == 0 || first.Flags != 7 || first.ContentType != "application/json" || first.Size != len(data) {
+			t.Fatalf("%s: wrong meta %+v", engine, first)
+		}
+
+		// 覆盖数据之后版本号变大，创建时间保持不变
+		time.Sleep(time.Millisecond)
+		cache.Set("user", []byte(`{"id":2}`))
+		second, ok := cache.Meta("user")
+		if !ok || second.Version <= first.Version || !second.CreatedAt.Equal(first.CreatedAt) || !second.UpdatedAt.After(first.UpdatedAt) {
+			t.Fatalf("%s: wrong meta after overwriting %+v, first is %+v", engine, second, first)
+		}
+
+		if second.Flags != 0 || second.ContentType != "" {
+			t.Fatalf("%s: flags and content type should be replaced but got %+v", engine, second)
+		}
+
+		// 删除之后重新写入的数据也不会使用以前的版本号
+		cache.Delete("user")
+		cache.Set("user", []byte(`{"id":3}`))
+		if third, _ := cache.Meta("user"); third.Version <= second.Version {
+			t.Fatalf("%s: version %d should be greater than %d", engine, third.Version, second.Version)
+		}
+
+		if err := cache.SetWith("user", nil, SetOptions{ContentType: string(make([]byte, maxContentTypeSize+1))}); err != contentTypeTooLongErr {
+			t.Fatalf("%s: content type should be too long but got %v", engine, err)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheSetWithCondition$
+func TestCacheSetWithCondition(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	first, err := cache.SetWithMeta("key", []byte("v1"), SetOptions{IfAbsent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.SetWithMeta("key", []byte("v2"), SetOptions{IfAbsent: true}); err != PreconditionFailedErr {
+		t.Fatalf("setting an existing key with IfAbsent should fail but got %v", err)
+	}
+
+	second, err := cache.SetWithMeta("key", []byte("v2"), SetOptions{IfVersion: first.Version})
+	if err != nil || second.Version <= first.Version {
+		t.Fatalf("setting with the current version should succeed but got %+v, %v", second, err)
+	}
+
+	// 旧的版本号已经失效了
+	if err = cache.SetWith("key", []byte("v3"), SetOptions{IfVersion: first.Version}); err != PreconditionFailedErr {
+		t.Fatalf("setting with a stale version should fail but got %v", err)
+	}
+
+	if err = cache.SetWith("missing", []byte("v1"), SetOptions{IfVersion: first.Version}); err != PreconditionFailedErr {
+		t.Fatalf("setting a missing key with IfVersion should fail but got %v", err)
+	}
+
+	if value, _ := cache.Get("key"); string(value) != "v2" {
+		t.Fatalf("value should be v2 but got %s", value)
+	}
+}
+
+// go test -v -run=^TestCacheSetExpiredWithCondition$
+func TestCacheSetExpiredWithCondition(t *testing.T) {
+
+	// 过期时间已经过去的写入相当于删除，但是写入条件不满足的话不能删除数据
+	past := time.Now().Add(-time.Second)
+	tests := []struct {
+		name    string
+		options func(version uint64) SetOptions
+		err     error
+		deleted bool
+	}{
+		{name: "unconditional", options: func(version uint64) SetOptions { return SetOptions{ExpireAt: past} }, deleted: true},
+		{name: "current version", options: func(version uint64) SetOptions { return SetOptions{ExpireAt: past, IfVersion: version} }, deleted: true},
+		{name: "stale version", options: func(version uint64) SetOptions { return SetOptions{ExpireAt: past, IfVersion: version + 1} }, err: PreconditionFailedErr},
+		{name: "if absent", options: func(version uint64) SetOptions { return SetOptions{ExpireAt: past, IfAbsent: true} }, err: PreconditionFailedErr},
+	}
+
+	for _, test := range tests {
+		cache := newCache(DefaultOptions())
+		meta, err := cache.SetWithMeta("key", []byte("value"), SetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = cache.SetWith("key", []byte("new"), test.options(meta.Version)); err != test.err {
+			t.Fatalf("%s should return %v but got %v", test.name, test.err, err)
+		}
+
+		if _, ok := cache.Get("key"); ok == test.deleted {
+			t.Fatalf("%s should delete the key %v", test.name, test.deleted)
+		}
+	}
+}
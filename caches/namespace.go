@@ -0,0 +1,422 @@
+package caches
+
+import (
+	"Rcache/helpers"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultNamespace 是默认的命名空间，不指定命名空间的操作都在这里进行，它的内存上限就是整个缓存的内存上限。
+	DefaultNamespace = ""
+
+	// NoEviction 是不淘汰数据的策略，内存达到上限之后就拒绝写入。
+	NoEviction = "noeviction"
+
+	// LruEviction 是近似 LRU 的淘汰策略，每次抽样几个数据，淘汰掉其中最久没有访问的，类似于 Redis 中的 allkeys-lru。
+	LruEviction = "lru"
+
+	// RandomEviction 是随机淘汰数据的策略，类似于 Redis 中的 allkeys-random。
+	RandomEviction = "random"
+
+	// evictionSamples 是 LruEviction 策略下每次淘汰时抽样的数据个数。
+	evictionSamples = 5
+)
+
+var (
+	// memoryLimitExceededErr 是写入数据会超过内存上限的错误。
+	memoryLimitExceededErr = errors.New("the memory limit will exceed if you set this entry")
+
+	// tooManyNamespacesErr 是没有预先配置的命名空间个数达到上限的错误。
+	tooManyNamespacesErr = errors.New("the count of namespaces reaches the limit")
+
+	// namespacePattern 是命名空间名称的格式，名称会出现在 URL 中，所以只允许使用字母、数字和少数几个符号。
+	namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// NamespaceOptions 是命名空间的选项配置。
+type NamespaceOptions struct {
+
+	// MaxMemory 指这个命名空间最多能使用的内存，比如 512MB，为空表示只受整个缓存的内存上限约束。
+	MaxMemory string
+
+	// DefaultTtl 指添加数据时没有设置寿命的话使用的默认寿命，为 0 表示永不过期。
+	// 单位是秒。
+	DefaultTtl int
+
+	// EvictionPolicy 指内存达到上限时的淘汰策略，可以是 NoEviction、LruEviction 或者 RandomEviction。
+	EvictionPolicy string
+
+	// SegmentSize 指这个命名空间中有多少个 segment，会向上取整到 2 的幂。
+	SegmentSize int
+}
+
+// DefaultNamespaceOptions 返回命名空间默认的选项配置。
+func DefaultNamespaceOptions() NamespaceOptions {
+	return NamespaceOptions{
+		MaxMemory:      "",
+		DefaultTtl:     0,
+		EvictionPolicy: NoEviction,
+		SegmentSize:    64,
+	}
+}
+
+// validate 检查选项配置是否合法，并返回解析出来的内存上限。
+func (o *NamespaceOptions) validate() (int64, error) {
+	switch o.EvictionPolicy {
+	case NoEviction, LruEviction, RandomEviction:
+	default:
+		return 0, fmt.Errorf("unknown eviction policy %s", o.EvictionPolicy)
+	}
+
+	if o.SegmentSize <= 0 {
+		return 0, fmt.Errorf("segment size of namespace must be positive but got %d", o.SegmentSize)
+	}
+
+	if o.MaxMemory == "" {
+		return unlimitedMemory, nil
+	}
+	return helpers.ParseSize(o.MaxMemory)
+}
+
+// Validate 检查选项配置是否合法。
+func (o *NamespaceOptions) Validate() error {
+	_, err := o.validate()
+	return err
+}
+
+// Namespace 是缓存中一个独立的命名空间，不同命名空间中相同的 key 互不影响。
+// 每个命名空间都有自己的 segment、内存配额、默认寿命和淘汰策略，可以单独查看情况和清空。
+type Namespace struct {
+
+	// name 是命名空间的名称。
+	name string
+
+	// options 是命名空间的选项配置。
+	options NamespaceOptions
+
+	// cache 是命名空间所属的缓存。
+	cache *Cache
+
+	// segmentSize 是 segment 的数量。
+	segmentSize int
+
+	// segments 存储着这个命名空间所有的 segment 实例。
+	segments []*segment
+
+	// memory 记录着这个命名空间的内存使用情况，它的上级就是整个缓存的内存记录。
+	memory *memory
+
+	// tags 记录着这个命名空间中数据的标签。
+	tags *tagIndex
+}
+
+// newNamespace 返回一个命名空间实例，segmentOptions 是创建 segment 使用的选项配置。
+func newNamespace(cache *Cache, name string, options NamespaceOptions, segmentOptions *Options) (*Namespace, error) {
+	limit, err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	memory := newMemory(limit, cache.memory)
+	tags := newTagIndex()
+	return &Namespace{
+		name:        name,
+		options:     options,
+		cache:       cache,
+		segmentSize: segmentOptions.SegmentSize,
+		segments:    newSegments(segmentOptions, memory, cache.hash, tags, &cache.versions),
+		memory:      memory,
+		tags:        tags,
+	}, nil
+}
+
+// Name 返回命名空间的名称。
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Options 返回命名空间的选项配置。
+func (ns *Namespace) Options() NamespaceOptions {
+	return ns.options
+}
+
+// segmentOf 返回 key 对应的 segment。
+func (ns *Namespace) segmentOf(key string) *segment {
+	return ns.segments[ns.cache.hash(key)&uint64(ns.segmentSize-1)]
+}
+
+// Get 返回指定 key 的数据。
+func (ns *Namespace) Get(key string) ([]byte, bool) {
+	// 这边会等待持久化完成
+	data, _, ok := ns.GetWithMeta(key)
+	return data, ok
+}
+
+// Set 添加指定的数据到缓存中，数据会使用命名空间的默认寿命。
+func (ns *Namespace) Set(key string, value []byte) error {
+	return ns.SetWith(key, value, SetOptions{})
+}
+
+// SetWithTTL 添加指定的数据到缓存中，并设置相应的有效期。
+// ttl 的单位是秒，需要更高精度的话请使用 SetWithDuration。
+func (ns *Namespace) SetWithTTL(key string, value []byte, ttl int64) error {
+	return ns.SetWithDuration(key, value, time.Duration(ttl)*time.Second)
+}
+
+// SetWithDuration 添加指定的数据到缓存中，并设置相应的有效期。
+func (ns *Namespace) SetWithDuration(key string, value []byte, ttl time.Duration) error {
+	return ns.SetWith(key, value, SetOptions{Ttl: ttl})
+}
+
+// SetWithExpireAt 添加指定的数据到缓存中，并在 expireAt 这个时间点过期。
+func (ns *Namespace) SetWithExpireAt(key string, value []byte, expireAt time.Time) error {
+	return ns.SetWith(key, value, SetOptions{ExpireAt: expireAt})
+}
+
+// SetWith 使用 options 添加指定的数据到缓存中，如果 options 没有设置寿命，就使用命名空间的默认寿命。
+// 如果内存达到了上限，会按照命名空间的淘汰策略淘汰数据，直到能写入或者没有数据可以淘汰为止。
+func (ns *Namespace) SetWith(key string, value []byte, options SetOptions) error {
+	_, err := ns.set(key, value, options)
+	return err
+}
+
+// set 是 SetWith 的具体实现，返回写入的数据，如果数据因为已经过期而被删除了，返回的数据就是 nil。
+func (ns *Namespace) set(key string, value []byte, options SetOptions) (*value, error) {
+	if !options.ExpireAt.IsZero() && !options.ExpireAt.After(time.Now()) {
+		ns.cache.waitForDumping()
+		return nil, ns.segmentOf(key).setExpired(key, options)
+	}
+
+	if len(options.ContentType) > maxContentTypeSize {
+		return nil, contentTypeTooLongErr
+	}
+
+	if options.Ttl == NeverDie && options.ExpireAt.IsZero() {
+		options.Ttl = time.Duration(ns.options.DefaultTtl) * time.Second
+	}
+
+	// 压缩比较耗时，所以在加锁之前完成
+	newValue := newValue(value, options)
+	if data, id := compress(ns.cache.compressor, ns.cache.current().CompressionThreshold, value); id != 0 {
+		newValue.Data = data
+		newValue.Flags |= id
+		newValue.RawSize = len(value)
+	}
+
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	segment := ns.segmentOf(key)
+	for {
+		err := segment.set(key, newValue, options)
+		if err != memoryLimitExceededErr || !ns.evict() {
+			if err != nil {
+				return nil, err
+			}
+			return newValue, nil
+		}
+	}
+}
+
+// Delete 从缓存中删除指定 key 的数据。
+func (ns *Namespace) Delete(key string) error {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	ns.segmentOf(key).delete(key)
+	return nil
+}
+
+// Expire 重新设置指定 key 的寿命，寿命从现在开始计算，如果 key 不存在就返回 false。
+// 如果 ttl 不是正数，那这个数据马上就过期了，所以直接删除。
+func (ns *Namespace) Expire(key string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		_, ok := ns.TTL(key)
+		ns.Delete(key)
+		return ok
+	}
+
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	return ns.segmentOf(key).update(key, func(v *value) {
+		v.expire(ttl)
+	})
+}
+
+// Persist 移除指定 key 的寿命，让它永不过期，如果 key 不存在就返回 false。
+func (ns *Namespace) Persist(key string) bool {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	return ns.segmentOf(key).update(key, func(v *value) {
+		v.persist()
+	})
+}
+
+// TTL 返回指定 key 剩余的寿命，如果 key 永不过期就返回 NeverDie，如果 key 不存在就返回 false。
+func (ns *Namespace) TTL(key string) (time.Duration, bool) {
+	return ns.segmentOf(key).ttl(key)
+}
+
+// Touch 更新指定 key 的访问时间但不读取数据，滑动过期的数据会因此延长寿命，如果 key 不存在就返回 false。
+func (ns *Namespace) Touch(key string) bool {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	return ns.segmentOf(key).update(key, func(v *value) {
+		v.touch()
+	})
+}
+
+// InvalidateTag 删除所有带有 tag 这个标签的数据，返回删除的数据个数。
+func (ns *Namespace) InvalidateTag(tag string) int {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+
+	// 先把 key 复制出来再删除，因为删除数据时会加 segment 的锁，而加锁的顺序必须是先 segment 后 tagIndex
+	// 复制出来之后数据可能被覆盖了，所以删除时会在 segment 的锁里面再检查一次标签
+	invalidated := 0
+	for _, key := range ns.tags.keysOf(tag) {
+		if ns.segmentOf(key).deleteTagged(key, tag) {
+			invalidated++
+		}
+	}
+	return invalidated
+}
+
+// Status 返回这个命名空间当前的情况，MaxMemory 是这个命名空间实际能使用的内存上限。
+func (ns *Namespace) Status() Status {
+	result := NewStatus()
+	for _, segment := range ns.segments {
+		status := segment.status()
+		result.Count += status.Count
+		result.KeySize += status.KeySize
+		result.ValueSize += status.ValueSize
+		result.RawValueSize += status.RawValueSize
+		result.CompressedCount += status.CompressedCount
+		result.ExpiredBacklog += status.ExpiredBacklog
+	}
+
+	result.UsedMemory = ns.memory.usage()
+	result.MaxMemory = ns.memory.max()
+	return *result
+}
+
+// Flush 清空这个命名空间中所有的数据，其他命名空间不受影响。
+// 清空的过程中会锁住这个命名空间所有的 segment，所以其他操作要么看到清空前的数据，要么看到清空后的数据。
+func (ns *Namespace) Flush() {
+	// 这边会等待持久化完成
+	ns.cache.waitForDumping()
+	lockSegments(ns.segments)
+	defer unlockSegments(ns.segments)
+	ns.reset()
+}
+
+// reset 清空这个命名空间中所有的数据以及相关的记录，调用前需要锁住所有的 segment。
+func (ns *Namespace) reset() {
+	for _, segment := range ns.segments {
+		segment.reset()
+	}
+	ns.tags.reset()
+	ns.memory.reset()
+}
+
+// evict 按照淘汰策略淘汰一个数据，如果不允许淘汰或者没有数据可以淘汰就返回 false。
+// 这里从随机的 segment 开始找，调用前不能持有任何 segment 的锁，否则两个 segment 互相淘汰时会死锁。
+func (ns *Namespace) evict() bool {
+	if ns.options.EvictionPolicy == NoEviction {
+		return false
+	}
+
+	start := rand.Intn(len(ns.segments))
+	for i := 0; i < len(ns.segments); i++ {
+		segment := ns.segments[(start+i)%len(ns.segments)]
+		if segment.evict(evictionSamples, ns.options.EvictionPolicy == LruEviction) {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespace 返回名为 name 的命名空间，如果命名空间不存在就使用 Options.Namespaces 中的配置创建一个，没有配置的话就使用默认配置。
+// name 为 DefaultNamespace 时返回默认的命名空间。
+// 这个方法只应该在写入数据时使用，只是读取数据的话请使用 LookupNamespace，否则读取不存在的命名空间也会创建出新的命名空间。
+func (c *Cache) Namespace(name string) (*Namespace, error) {
+	if ns, ok := c.LookupNamespace(name); ok {
+		return ns, nil
+	}
+
+	options, ok := c.options.Namespaces[name]
+	if !ok {
+		options = DefaultNamespaceOptions()
+	}
+	return c.CreateNamespace(name, options)
+}
+
+// LookupNamespace 返回名为 name 的命名空间，命名空间不存在的话返回 false，不会创建新的命名空间。
+// name 为 DefaultNamespace 时返回默认的命名空间。
+func (c *Cache) LookupNamespace(name string) (*Namespace, bool) {
+	if name == DefaultNamespace {
+		return c.defaultNamespace, true
+	}
+
+	c.namespacesLock.RLock()
+	defer c.namespacesLock.RUnlock()
+	ns, ok := c.namespaces[name]
+	return ns, ok
+}
+
+// CreateNamespace 使用 options 创建一个名为 name 的命名空间，如果命名空间已经存在就直接返回已有的。
+// 没有预先配置的命名空间个数达到 Options.MaxNamespaces 之后，再创建新的命名空间会返回错误。
+func (c *Cache) CreateNamespace(name string, options NamespaceOptions) (*Namespace, error) {
+	return c.createNamespace(name, options, true)
+}
+
+// createNamespace 使用 options 创建一个名为 name 的命名空间，limited 表示是否检查命名空间的个数上限。
+// 从持久化文件中恢复的命名空间以前就已经存在了，所以不检查个数上限，否则调小上限之后重启会丢失数据。
+func (c *Cache) createNamespace(name string, options NamespaceOptions, limited bool) (*Namespace, error) {
+	if !namespacePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid namespace name %q", name)
+	}
+
+	c.namespacesLock.Lock()
+	defer c.namespacesLock.Unlock()
+	if ns, ok := c.namespaces[name]; ok {
+		return ns, nil
+	}
+
+	if _, configured := c.options.Namespaces[name]; limited && !configured && c.unconfiguredNamespaces() >= c.current().maxNamespaces() {
+		return nil, tooManyNamespacesErr
+	}
+
+	ns, err := newNamespace(c, name, options, c.options.namespaceSegmentOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	c.namespaces[name] = ns
+	return ns, nil
+}
+
+// unconfiguredNamespaces 返回没有预先配置的命名空间的个数，不包括默认的命名空间，调用前需要先加锁。
+func (c *Cache) unconfiguredNamespaces() int {
+	count := 0
+	for name := range c.namespaces {
+		if _, configured := c.options.Namespaces[name]; !configured && name != DefaultNamespace {
+			count++
+		}
+	}
+	return count
+}
+
+// Namespaces 返回所有命名空间的名称，包括默认的命名空间，名称是排好序的。
+func (c *Cache) Namespaces() []string {
+	c.namespacesLock.RLock()
+	defer c.namespacesLock.RUnlock()
+	names := make([]string, 0, len(c.namespaces))
+	for name := range c.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
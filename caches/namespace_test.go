@@ -0,0 +1,150 @@
+package caches
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestNamespaceIsolation$
+func TestNamespaceIsolation(t *testing.T) {
+
+	cache := NewCache()
+	ns, err := cache.Namespace("team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key", []byte("default"))
+	ns.Set("key", []byte("team-a"))
+	if value, _ := cache.Get("key"); string(value) != "default" {
+		t.Fatalf("default namespace got %s", value)
+	}
+
+	if value, _ := ns.Get("key"); string(value) != "team-a" {
+		t.Fatalf("team-a namespace got %s", value)
+	}
+
+	ns.Flush()
+	if _, ok := ns.Get("key"); ok {
+		t.Fatal("key should be flushed from team-a")
+	}
+
+	if _, ok := cache.Get("key"); !ok {
+		t.Fatal("flushing team-a should not affect the default namespace")
+	}
+
+	if status := ns.Status(); status.Count != 0 || status.UsedMemory != 0 {
+		t.Fatalf("status of flushed namespace should be empty but got %+v", status)
+	}
+
+	if _, err := cache.Namespace("bad/name"); err == nil {
+		t.Fatal("namespace with invalid name should not be created")
+	}
+}
+
+// go test -v -run=^TestNamespaceLimit$
+func TestNamespaceLimit(t *testing.T) {
+
+	options := DefaultOptions()
+	options.MaxNamespaces = 2
+	options.Namespaces = map[string]NamespaceOptions{"configured": DefaultNamespaceOptions()}
+	cache := NewCacheWith(options)
+
+	// 读取不存在的命名空间不会创建它，预先配置的命名空间不计入上限
+	tests := []struct {
+		name    string
+		lookup  bool
+		created bool
+	}{
+		{name: DefaultNamespace, lookup: true, created: true},
+		{name: "configured", lookup: true, created: true},
+		{name: "first", lookup: false, created: true},
+		{name: "second", lookup: false, created: true},
+		{name: "third", lookup: false, created: false},
+		{name: "first", lookup: true, created: true},
+	}
+
+	for _, test := range tests {
+		if _, ok := cache.LookupNamespace(test.name); ok != test.lookup {
+			t.Fatalf("lookup of namespace %q should be %v", test.name, test.lookup)
+		}
+
+		if _, err := cache.Namespace(test.name); (err == nil) != test.created {
+			t.Fatalf("creating namespace %q should be %v but got %v", test.name, test.created, err)
+		}
+	}
+
+	if _, ok := cache.LookupNamespace("third"); ok {
+		t.Fatal("namespace over the limit should not exist")
+	}
+}
+
+// go test -v -run=^TestNamespaceDefaultTtl$
+func TestNamespaceDefaultTtl(t *testing.T) {
+
+	options := DefaultOptions()
+	options.Namespaces = map[string]NamespaceOptions{
+		"session": {DefaultTtl: 60, EvictionPolicy: NoEviction, SegmentSize: 4},
+	}
+
+	ns, err := newCache(options).Namespace("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns.Set("key", []byte("value"))
+	if ttl, ok := ns.TTL("key"); !ok || ttl <= 59*time.Second || ttl > 60*time.Second {
+		t.Fatalf("key should use the default ttl but got %v %v", ttl, ok)
+	}
+
+	ns.SetWithDuration("other", []byte("value"), time.Second)
+	if ttl, _ := ns.TTL("other"); ttl > time.Second {
+		t.Fatalf("explicit ttl should win over the default ttl but got %v", ttl)
+	}
+}
+
+// go test -v -run=^TestNamespaceEviction$
+func TestNamespaceEviction(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	limited, err := cache.CreateNamespace("limited", NamespaceOptions{MaxMemory: "4KB", EvictionPolicy: NoEviction, SegmentSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lru, err := cache.CreateNamespace("lru", NamespaceOptions{MaxMemory: "4KB", EvictionPolicy: LruEviction, SegmentSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := make([]byte, 256)
+	var rejected bool
+	for i := 0; i < 64; i++ {
+		if err := limited.Set(strconv.Itoa(i), value); err != nil {
+			rejected = true
+		}
+
+		if err := lru.Set(strconv.Itoa(i), value); err != nil {
+			t.Fatalf("lru namespace should evict instead of failing: %v", err)
+		}
+	}
+
+	if !rejected {
+		t.Fatal("namespace with noeviction policy should reject writes over its quota")
+	}
+
+	for _, ns := range []*Namespace{limited, lru} {
+		if status := ns.Status(); status.UsedMemory > status.MaxMemory || status.MaxMemory != 4096 {
+			t.Fatalf("namespace %s exceeds its quota: %+v", ns.Name(), status)
+		}
+	}
+
+	if _, ok := lru.Get("63"); !ok {
+		t.Fatal("the latest key should not be evicted")
+	}
+
+	if status := cache.Status(); status.UsedMemory != limited.Status().UsedMemory+lru.Status().UsedMemory {
+		t.Fatalf("global memory should be the sum of all namespaces but got %+v", status)
+	}
+}
@@ -1,18 +1,54 @@
 package caches
 
+import (
+	"Rcache/helpers"
+	"fmt"
+	"time"
+)
+
+const (
+	// WheelGc 是使用时间轮清理过期数据的策略，数据到期后就会被清理。
+	WheelGc = "wheel"
+
+	// AdaptiveGc 是类似于 Redis 的自适应清理策略，每次 gc 随机抽样带 ttl 的数据进行清理，
+	// 如果抽样中过期的数据比较多，就继续抽样清理，直到用完时间预算。
+	AdaptiveGc = "adaptive"
+)
+
 // Options 是选项配置结构体。
 type Options struct {
 
+	// MaxMemory 指缓存最多能使用的内存，比如 512MB、4GB，包括每个键值对估算的额外开销。
+	MaxMemory string
+
 	// MaxEntrySize 指键值对最大容量。
+	// 单位是 GB，已经废弃，请使用 MaxMemory，只有 MaxMemory 为空时才会使用这个值。
 	MaxEntrySize int
 
-	// MaxGcCount 指每个 segment 要清理的过期数据个数。
+	// MaxGcCount 指每次 gc 时每个 segment 最多处理的过期数据个数。
 	MaxGcCount int
 
-	// GcDuration 指多久执行一次 Gc 工作。
-	// 单位是分钟。
+	// GcTick 指多久执行一次 Gc 工作，同时也是过期时间轮一个刻度的时长。
+	// 单位是毫秒，时间轮创建之后刻度就不能改变了，所以修改之后需要重启才能生效。
+	GcTick int
+
+	// GcDuration 是以前版本中 Gc 工作的间隔，已经废弃，只是为了兼容以前的配置和持久化文件。
+	// 单位是分钟，不为 0 的话会换算成毫秒代替 GcTick。
 	GcDuration int
 
+	// GcStrategy 指清理过期数据的策略，可以是 WheelGc 或者 AdaptiveGc。
+	GcStrategy string
+
+	// GcSampleSize 指 AdaptiveGc 策略下每一轮在每个 segment 中抽样的数据个数。
+	GcSampleSize int
+
+	// GcExpiredThreshold 指 AdaptiveGc 策略下抽样数据中过期数据的百分比阈值，超过这个值就马上再抽样一轮。
+	GcExpiredThreshold int
+
+	// GcTimeBudget 指 AdaptiveGc 策略下每次 gc 最多能消耗的时间。
+	// 单位是毫秒。
+	GcTimeBudget int
+
 	// DumpFile 指持久化文件的路径。
 	DumpFile string
 
@@ -23,9 +59,32 @@ type Options struct {
 	// MapSizeOfSegment 指 segment 中 map 的初始化大小。
 	MapSizeOfSegment int
 
+	// StorageEngine 指 segment 存储数据使用的引擎，可以是 MapStorage 或者 ArenaStorage。
+	// ArenaStorage 会为每个 segment 预分配 MaxMemory / SegmentSize 大小的缓冲区，写满之后淘汰最旧的数据。
+	StorageEngine string
+
 	// SegmentSize 指缓存中有多少个 segment。
+	// 这个值必须是 2 的幂，不是的话会向上取整到 2 的幂。
 	SegmentSize int
 
+	// HashFunction 指选择 segment 使用的哈希算法，可以是 MaphashHash、FnvHash、XxHash 或者通过 RegisterHasher 注册的算法。
+	HashFunction string
+
+	// Namespaces 指预先配置好的命名空间，key 是命名空间的名称。
+	// 没有配置的命名空间会在第一次写入时以默认配置创建，读取不存在的命名空间不会创建它。
+	Namespaces map[string]NamespaceOptions
+
+	// MaxNamespaces 指最多能有多少个没有预先配置的命名空间，超过之后写入新的命名空间会失败。
+	MaxNamespaces int
+
+	// Compression 指压缩数据使用的算法，可以是 NoCompression、SnappyCompression、ZstdCompression 或者 GzipCompression。
+	// 修改这个配置不会影响已经压缩过的数据，它们还是可以正常读取。
+	Compression string
+
+	// CompressionThreshold 指数据至少要多大才会压缩，太小的数据压缩之后一般不会变小。
+	// 单位是字节。
+	CompressionThreshold int
+
 	// CasSleepTime 指每一次 CAS 自旋需要等待的时间。
 	// 单位是微秒。
 	CasSleepTime int
@@ -34,13 +93,156 @@ type Options struct {
 // DefaultOptions 返回默认的选项配置。
 func DefaultOptions() Options {
 	return Options{
-		MaxEntrySize:     4, // 4 GB
-		MaxGcCount:       10,
-		GcDuration:       60, // 1 hour
-		DumpFile:         "kafo.dump",
-		DumpDuration:     30, // 30 minutes
-		MapSizeOfSegment: 256,
-		SegmentSize:      1024,
-		CasSleepTime:     1000, // 1 ms
+		MaxMemory:            "4GB",
+		MaxGcCount:           100,
+		GcTick:               100, // 100 ms
+		GcStrategy:           WheelGc,
+		GcSampleSize:         20,
+		GcExpiredThreshold:   25, // 25%
+		GcTimeBudget:         25, // 25 ms
+		DumpFile:             "kafo.dump",
+		DumpDuration:         30, // 30 minutes
+		MapSizeOfSegment:     256,
+		StorageEngine:        MapStorage,
+		SegmentSize:          1024,
+		HashFunction:         MaphashHash,
+		MaxNamespaces:        1024,
+		Compression:          NoCompression,
+		CompressionThreshold: 1024, // 1 KB
+		CasSleepTime:         1000, // 1 ms
+	}
+}
+
+// Validate 严格检查选项配置是否合法，比如 SegmentSize 必须是 2 的幂，时间间隔必须是正数。
+// 缓存本身会尽量纠正不合法的配置，但是从配置文件中读取的配置最好先检查一遍，不要让错误的配置悄悄生效。
+func (o *Options) Validate() error {
+	if _, err := o.MaxMemoryBytes(); err != nil {
+		return err
+	}
+
+	positives := []struct {
+		name  string
+		value int
+	}{
+		{"MaxGcCount", o.MaxGcCount},
+		{"GcTick", o.GcTick},
+		{"GcSampleSize", o.GcSampleSize},
+		{"GcTimeBudget", o.GcTimeBudget},
+		{"DumpDuration", o.DumpDuration},
+		{"SegmentSize", o.SegmentSize},
+		{"MaxNamespaces", o.MaxNamespaces},
+	}
+	for _, positive := range positives {
+		if positive.value <= 0 {
+			return fmt.Errorf("%s must be positive but got %d", positive.name, positive.value)
+		}
+	}
+
+	if o.SegmentSize&(o.SegmentSize-1) != 0 {
+		return fmt.Errorf("SegmentSize must be a power of 2 but got %d", o.SegmentSize)
+	}
+
+	if o.GcExpiredThreshold < 0 || o.GcExpiredThreshold > 100 {
+		return fmt.Errorf("GcExpiredThreshold must be between 0 and 100 but got %d", o.GcExpiredThreshold)
+	}
+
+	if o.GcDuration < 0 {
+		return fmt.Errorf("GcDuration can't be negative but got %d", o.GcDuration)
+	}
+
+	if o.MapSizeOfSegment < 0 || o.CompressionThreshold < 0 || o.CasSleepTime < 0 {
+		return fmt.Errorf("MapSizeOfSegment, CompressionThreshold and CasSleepTime can't be negative")
+	}
+
+	switch o.GcStrategy {
+	case WheelGc, AdaptiveGc:
+	default:
+		return fmt.Errorf("unknown gc strategy %s", o.GcStrategy)
+	}
+
+	switch o.StorageEngine {
+	case MapStorage, ArenaStorage:
+	default:
+		return fmt.Errorf("unknown storage engine %s", o.StorageEngine)
+	}
+
+	if _, err := newHasher(o.HashFunction); err != nil {
+		return err
+	}
+
+	if err := o.ValidateCompression(); err != nil {
+		return err
+	}
+
+	for name, options := range o.Namespaces {
+		if !namespacePattern.MatchString(name) {
+			return fmt.Errorf("invalid namespace name %q", name)
+		}
+
+		if err := options.Validate(); err != nil {
+			return fmt.Errorf("invalid options of namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateCompression 检查压缩算法是否存在。
+func (o *Options) ValidateCompression() error {
+	_, err := compressorOf(o.Compression)
+	return err
+}
+
+// gcTick 返回 gc 的间隔，单位是纳秒，设置了以前版本的 GcDuration 的话就按照分钟换算。
+func (o *Options) gcTick() int64 {
+	if o.GcDuration > 0 {
+		return int64(o.GcDuration) * int64(time.Minute)
+	}
+
+	if o.GcTick <= 0 {
+		return int64(DefaultOptions().GcTick) * int64(time.Millisecond)
+	}
+	return int64(o.GcTick) * int64(time.Millisecond)
+}
+
+// MaxMemoryBytes 返回缓存最多能使用的内存字节数，如果 MaxMemory 的格式不对就会返回错误。
+func (o *Options) MaxMemoryBytes() (int64, error) {
+	if o.MaxMemory == "" {
+		return int64(o.MaxEntrySize) << 30, nil
+	}
+	return helpers.ParseSize(o.MaxMemory)
+}
+
+// maxMemory 返回缓存最多能使用的内存字节数，如果 MaxMemory 的格式不对就使用默认值。
+func (o *Options) maxMemory() int64 {
+	limit, err := o.MaxMemoryBytes()
+	if err != nil {
+		defaultOptions := DefaultOptions()
+		limit, _ = defaultOptions.MaxMemoryBytes()
+	}
+	return limit
+}
+
+// arenaSize 返回 ArenaStorage 引擎下每个 segment 缓冲区的大小。
+func (o *Options) arenaSize() int {
+	return int(o.maxMemory() / int64(o.SegmentSize))
+}
+
+// namespaceSegmentOptions 返回命名空间创建 segment 使用的选项配置。
+// ArenaStorage 引擎会按照内存上限预分配缓冲区，所以没有内存配额的命名空间只能使用 MapStorage 引擎。
+func (o *Options) namespaceSegmentOptions(options NamespaceOptions) *Options {
+	segmentOptions := *o
+	segmentOptions.SegmentSize = roundToPowerOfTwo(options.SegmentSize)
+	segmentOptions.MaxMemory = options.MaxMemory
+	if options.MaxMemory == "" {
+		segmentOptions.StorageEngine = MapStorage
+	}
+	return &segmentOptions
+}
+
+// maxNamespaces 返回没有预先配置的命名空间个数上限，以前版本持久化的配置中没有这个值，所以不是正数的话使用默认值。
+func (o *Options) maxNamespaces() int {
+	if o.MaxNamespaces <= 0 {
+		return DefaultOptions().MaxNamespaces
 	}
+	return o.MaxNamespaces
 }
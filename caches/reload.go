@@ -0,0 +1,77 @@
+package caches
+
+import (
+	"reflect"
+)
+
+// reloadableOptions 是运行时可以修改的配置，其他配置需要重启才能生效。
+// GcTick 和 GcDuration 决定了时间轮的刻度，时间轮在创建 segment 的时候就确定了，所以它们也不能修改。
+var reloadableOptions = map[string]bool{
+	"MaxMemory":            true,
+	"MaxEntrySize":         true,
+	"MaxGcCount":           true,
+	"GcSampleSize":         true,
+	"GcExpiredThreshold":   true,
+	"GcTimeBudget":         true,
+	"DumpDuration":         true,
+	"MaxNamespaces":        true,
+	"CompressionThreshold": true,
+	"CasSleepTime":         true,
+}
+
+// current 返回运行时的配置。
+func (c *Cache) current() *Options {
+	return c.runtime.Load().(*Options)
+}
+
+// reloadedChan 返回下一次 Reload 时会被关闭的管道。
+func (c *Cache) reloadedChan() <-chan struct{} {
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	return c.reloaded
+}
+
+// Reload 使用 options 修改运行时可以修改的配置，包括内存上限、gc 和持久化的参数等。
+// 其他配置修改了也不会生效，它们的名称会通过 ignored 返回，方便调用方提示需要重启。
+// ArenaStorage 引擎的缓冲区在创建时就按照内存上限分配好了，所以这个引擎下内存上限也不能修改。
+func (c *Cache) Reload(options Options) (ignored []string, err error) {
+	if err = options.Validate(); err != nil {
+		return nil, err
+	}
+
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+
+	current := c.current()
+	next := *current
+	nextValue := reflect.ValueOf(&next).Elem()
+	currentValue := reflect.ValueOf(*current)
+	optionsValue := reflect.ValueOf(options)
+	for i := 0; i < optionsValue.NumField(); i++ {
+		name := optionsValue.Type().Field(i).Name
+		if reflect.DeepEqual(optionsValue.Field(i).Interface(), currentValue.Field(i).Interface()) {
+			continue
+		}
+
+		reloadable := reloadableOptions[name]
+		if c.options.StorageEngine == ArenaStorage && (name == "MaxMemory" || name == "MaxEntrySize") {
+			reloadable = false
+		}
+
+		if !reloadable {
+			ignored = append(ignored, name)
+			continue
+		}
+		nextValue.Field(i).Set(optionsValue.Field(i))
+	}
+
+	if c.options.StorageEngine != ArenaStorage {
+		c.memory.setLimit(next.maxMemory())
+	}
+
+	// 替换配置之后再通知后台任务，这样它们读到的就是新的配置
+	c.runtime.Store(&next)
+	close(c.reloaded)
+	c.reloaded = make(chan struct{})
+	return ignored, nil
+}
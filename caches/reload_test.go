@@ -0,0 +1,109 @@
+package caches
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestCacheReload$
+func TestCacheReload(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	reloaded := cache.reloadedChan()
+
+	options := DefaultOptions()
+	options.MaxMemory = "1KB"
+	options.GcTick = 50
+	options.MaxGcCount = 50
+	options.HashFunction = FnvHash
+	ignored, err := cache.Reload(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 时间轮的刻度不能修改，所以 GcTick 也需要重启才能生效
+	if !reflect.DeepEqual(ignored, []string{"GcTick", "HashFunction"}) {
+		t.Fatalf("only GcTick and HashFunction should be ignored but got %v", ignored)
+	}
+
+	select {
+	case <-reloaded:
+	default:
+		t.Fatal("background tasks should be notified after reloading")
+	}
+
+	if current := cache.current(); current.MaxGcCount != 50 || current.GcTick != DefaultOptions().GcTick || current.HashFunction != DefaultOptions().HashFunction {
+		t.Fatalf("wrong options after reloading %+v", current)
+	}
+
+	// 新的内存上限马上生效
+	if cache.memory.max() != 1024 {
+		t.Fatalf("max memory should be 1024 but got %d", cache.memory.max())
+	}
+
+	if err = cache.Set("key", make([]byte, 2048)); err == nil {
+		t.Fatal("setting an entry larger than the new max memory should fail")
+	}
+
+	options.GcTick = 0
+	if _, err = cache.Reload(options); err == nil {
+		t.Fatal("reloading invalid options should fail")
+	}
+
+	if cache.current().MaxGcCount != 50 {
+		t.Fatal("invalid options should not be applied")
+	}
+}
+
+// go test -v -run=^TestOptionsValidate$
+func TestOptionsValidate(t *testing.T) {
+
+	if options := DefaultOptions(); options.Validate() != nil {
+		t.Fatalf("default options should be valid but got %v", options.Validate())
+	}
+
+	invalids := []func(options *Options){
+		func(options *Options) { options.MaxMemory = "1XB" },
+		func(options *Options) { options.SegmentSize = 1000 },
+		func(options *Options) { options.GcExpiredThreshold = 101 },
+		func(options *Options) { options.GcDuration = -1 },
+		func(options *Options) { options.GcStrategy = "unknown" },
+		func(options *Options) { options.StorageEngine = "unknown" },
+		func(options *Options) { options.Compression = "unknown" },
+		func(options *Options) {
+			options.Namespaces = map[string]NamespaceOptions{"team-a": {EvictionPolicy: "unknown"}}
+		},
+	}
+
+	for i, invalid := range invalids {
+		options := DefaultOptions()
+		invalid(&options)
+		if options.Validate() == nil {
+			t.Fatalf("options %d should be invalid", i)
+		}
+	}
+}
+
+// go test -v -run=^TestOptionsGcTick$
+func TestOptionsGcTick(t *testing.T) {
+
+	// 以前版本的 GcDuration 单位是分钟，设置了的话要按照分钟换算
+	tests := []struct {
+		gcTick     int
+		gcDuration int
+		expected   time.Duration
+	}{
+		{gcTick: 100, expected: 100 * time.Millisecond},
+		{gcTick: 0, expected: 100 * time.Millisecond},
+		{gcTick: 100, gcDuration: 60, expected: time.Hour},
+	}
+
+	for _, test := range tests {
+		options := DefaultOptions()
+		options.GcTick, options.GcDuration = test.gcTick, test.gcDuration
+		if tick := time.Duration(options.gcTick()); tick != test.expected {
+			t.Fatalf("gc tick of %+v should be %s but got %s", test, test.expected, tick)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package caches
 
 import (
-	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type segment struct {
 
 	//  存储这个数据块的数据。
-	Data map[string]*value
+	data storage
 
 	//  记录着这个数据块的情况。
 	Status *Status
@@ -17,92 +18,402 @@ type segment struct {
 	options *Options
 
 	lock *sync.RWMutex
+
+	// memory 记录着整个缓存的内存使用情况，所有 segment 共享同一个实例。
+	memory *memory
+
+	// wheel 记录着这个数据块中数据的过期时间，gc 的时候只需要处理到期的数据。
+	// 只有 WheelGc 策略才会使用。
+	wheel *timingWheel
+
+	// volatile 记录着这个数据块中所有带 ttl 的 key，gc 的时候从这里面抽样。
+	// 只有 AdaptiveGc 策略才会使用。
+	volatile map[string]struct{}
+
+	// tags 记录着数据的标签，同一个命名空间的所有 segment 共享同一个实例。
+	tags *tagIndex
+
+	// hash 是存储引擎使用的哈希算法，清空数据时需要用它重新创建存储引擎。
+	hash Hasher
+
+	// versions 是整个缓存最新的数据版本号，所有 segment 共享同一个实例。
+	versions *uint64
+}
+
+func newSegment(options *Options, memory *memory, hash Hasher, tags *tagIndex, versions *uint64) *segment {
+	s := &segment{
+		Status:   NewStatus(),
+		options:  options,
+		lock:     &sync.RWMutex{},
+		memory:   memory,
+		tags:     tags,
+		hash:     hash,
+		versions: versions,
+	}
+	s.data = newStorage(options, hash, s.forget)
+	s.initExpiration()
+	return s
 }
 
-func newSegment(options *Options) *segment {
-	return &segment{
-		// 初始化 map 的时候给出初始大小，可以避免大量扩容带来的性能损耗
-		Data:    make(map[string]*value, options.MapSizeOfSegment),
-		Status:  NewStatus(),
-		options: options,
-		lock:    &sync.RWMutex{},
+// initExpiration 根据 gc 策略初始化记录过期时间的数据结构。
+func (s *segment) initExpiration() {
+	if s.options.GcStrategy == AdaptiveGc {
+		s.volatile = make(map[string]struct{})
+	} else {
+		s.wheel = newTimingWheel(s.options.gcTick(), time.Now().UnixNano())
+	}
+}
+
+// restore 将从持久化文件中恢复的数据添加进 segment，不会检查内存上限，返回数据是否被恢复了。
+// 以前的持久化文件中没有版本号，这样的数据会分配一个新的版本号。
+func (s *segment) restore(key string, value *value) (bool, error) {
+
+	// 以前版本持久化的数据需要先转换格式，持久化之后已经过期的数据就不用恢复了
+	value.upgrade()
+	if !value.alive() {
+		return false, nil
+	}
+
+	value.due = 0
+	if value.Version == 0 {
+		value.Version = nextVersion(s.versions)
+		value.Mtime = value.Ctime
+	} else {
+		observeVersion(s.versions, value.Version)
+	}
+
+	s.schedule(key, value)
+	if err := s.data.set(key, value); err != nil {
+		return false, err
 	}
+
+	s.memory.grow(s.data.cost(key, len(value.Data), len(value.ContentType)))
+	s.Status.addEntry(key, len(value.Data), value.rawSize())
+	return true, nil
 }
 
-// get 返回指定 key 的数据。
+// get 返回指定 key 的数据，返回的数据可能是压缩过的，需要由调用方解压。
 // 这个方法和原来 cache 的方法一样，只是移动到 segment 这里。
-func (s *segment) get(key string) ([]byte, bool) {
+func (s *segment) get(key string) (*value, bool) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	value, ok := s.Data[key]
+	value, ok := s.data.get(key)
 	if !ok {
 		return nil, false
 	}
 
 	if !value.alive() {
 		s.lock.RUnlock()
-		s.delete(key)
+		s.deleteExpired(key, value)
 		s.lock.RLock()
 		return nil, false
 	}
-	return value.visit(), true
+
+	s.data.touch(key, value, time.Now().UnixNano())
+	return value, true
 }
 
-// set 添加一个数据进 segment。
-func (s *segment) set(key string, value []byte, ttl int64) error {
+// set 添加一个数据进 segment，options 中的标签和写入条件会在这里处理。
+func (s *segment) set(key string, newValue *value, options SetOptions) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
+
+	// 覆盖旧数据的话，只需要申请新旧数据之间相差的内存
+	// 会自己淘汰旧数据的存储引擎不需要检查全局的内存上限
+	oldValue, ok := s.data.get(key)
+	if !conditionHolds(oldValue, ok, options) {
+		return PreconditionFailedErr
 	}
 
-	if !s.checkEntrySize(key, value) {
-		if oldValue, ok := s.Data[key]; ok {
-			s.Status.addEntry(key, oldValue.Data)
-		}
-		return errors.New("the entry size will exceed if you set this entry")
+	delta := s.data.cost(key, len(newValue.Data), len(newValue.ContentType))
+	if ok {
+		delta -= s.data.cost(key, len(oldValue.Data), len(oldValue.ContentType))
+	}
+
+	// 版本号在锁里面分配，这样同一个 key 后写入的数据版本号一定更大
+	// 覆盖数据时保留原来的创建时间，覆盖已经过期的数据就相当于重新创建
+	newValue.Version = nextVersion(s.versions)
+	if ok && oldValue.alive() {
+		newValue.Ctime = oldValue.Ctime
+	}
+
+	if s.data.evicts() {
+		s.memory.grow(delta)
+	} else if !s.memory.acquire(delta) {
+		return memoryLimitExceededErr
+	}
+
+	s.schedule(key, newValue)
+	if err := s.data.set(key, newValue); err != nil {
+		s.memory.release(delta)
+		return err
 	}
 
-	s.Status.addEntry(key, value)
-	s.Data[key] = newValue(value, ttl)
+	if ok {
+		s.Status.subEntry(key, len(oldValue.Data), oldValue.rawSize())
+	}
+	s.Status.addEntry(key, len(newValue.Data), newValue.rawSize())
+
+	// 覆盖数据时旧数据的标签也要一起替换掉
+	if ok || len(options.Tags) > 0 {
+		s.tags.replace(key, options.Tags)
+	}
+	return nil
+}
+
+// setExpired 处理过期时间已经过去的写入，这样的写入相当于删除数据，但是也要先检查写入条件，条件不满足的话数据要保持不变。
+func (s *segment) setExpired(key string, options SetOptions) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok := s.data.get(key)
+	if !conditionHolds(oldValue, ok, options) {
+		return PreconditionFailedErr
+	}
+
+	if ok {
+		s.remove(key, oldValue)
+	}
 	return nil
 }
 
-// delete 从 segment 中删除指定 key 的数据。
-func (s *segment) delete(key string) {
+// conditionHolds 返回写入条件是否满足，oldValue 和 ok 是当前的数据，已经过期的数据当作不存在。
+func conditionHolds(oldValue *value, ok bool, options SetOptions) bool {
+	exists := ok && oldValue.alive()
+	if options.IfAbsent && exists {
+		return false
+	}
+	return options.IfVersion == 0 || (exists && oldValue.Version == options.IfVersion)
+}
+
+// update 使用 fn 更新指定 key 的数据，如果数据不存在或者已经过期就返回 false。
+func (s *segment) update(key string, fn func(v *value)) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.data.get(key)
+	if !ok {
+		return false
+	}
+
+	if !current.alive() {
+		s.remove(key, current)
+		return false
+	}
+
+	return s.data.update(key, func(v *value) {
+		fn(v)
+		s.schedule(key, v)
+	})
+}
+
+// ttl 返回指定 key 的数据剩余的寿命，如果数据不存在或者已经过期就返回 false。
+func (s *segment) ttl(key string) (time.Duration, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.data.get(key)
+	if !ok || !value.alive() {
+		return 0, false
+	}
+	return value.ttl(), true
+}
+
+// delete 从 segment 中删除指定 key 的数据，如果数据不存在就返回 false。
+func (s *segment) delete(key string) bool {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
-		delete(s.Data, key)
+	oldValue, ok := s.data.get(key)
+	if ok {
+		s.remove(key, oldValue)
 	}
+	return ok
+}
+
+// deleteExpired 删除 key 已经过期的数据 expired。
+// 发现数据过期是在读锁里面，换成写锁的这段时间里数据可能已经被重新写入了，所以要确认现在存储的还是那个过期的数据才删除。
+// 有些存储引擎返回的是数据的副本，所以这里比较的是版本号，而不是指针。
+func (s *segment) deleteExpired(key string, expired *value) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.data.get(key)
+	if ok && current.Version == expired.Version && !current.alive() {
+		s.remove(key, current)
+	}
+}
+
+// deleteTagged 在 key 现在还带有 tag 这个标签时删除它的数据，如果数据被删除了就返回 true。
+// 按标签删除时 key 是先从标签索引中复制出来的，这期间数据可能已经被覆盖成不带这个标签的了，所以要在 segment 的锁里面再检查一次。
+func (s *segment) deleteTagged(key string, tag string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok := s.data.get(key)
+	if !ok || !s.tags.has(key, tag) {
+		return false
+	}
+	s.remove(key, oldValue)
+	return true
+}
+
+// remove 删除指定的数据，调用前需要先加锁。
+func (s *segment) remove(key string, value *value) {
+	s.data.delete(key)
+	s.forget(key, len(value.Data), value.rawSize(), len(value.ContentType))
+}
+
+// forget 在数据被删除或者被存储引擎淘汰之后，把这个数据相关的记录也删除掉，调用前需要先加锁。
+// size 是数据占用的大小，rawSize 是数据压缩之前的大小，contentTypeSize 是 ContentType 的长度。
+func (s *segment) forget(key string, size int, rawSize int, contentTypeSize int) {
+	s.memory.release(s.data.cost(key, size, contentTypeSize))
+	s.Status.subEntry(key, size, rawSize)
+	s.tags.forget(key)
+	if s.volatile != nil {
+		delete(s.volatile, key)
+	}
+}
+
+// reset 清空这个 segment 中所有的数据，调用前需要先加锁。
+// 这里直接重新创建存储引擎，而不是一个个删除数据，这样 map 占用的空间也能释放掉。
+// 内存和标签的记录是整个命名空间共享的，需要由调用方一起清空。
+func (s *segment) reset() {
+	s.data = newStorage(s.options, s.hash, s.forget)
+	s.Status = NewStatus()
+	s.initExpiration()
+}
+
+// lockSegments 按顺序锁住所有的 segment。
+// 需要同时锁住多个 segment 的地方都要按照同样的顺序加锁，否则会死锁。
+func lockSegments(segments []*segment) {
+	for _, segment := range segments {
+		segment.lock.Lock()
+	}
+}
+
+// unlockSegments 解锁所有的 segment。
+func unlockSegments(segments []*segment) {
+	for _, segment := range segments {
+		segment.lock.Unlock()
+	}
+}
+
+// evict 淘汰这个 segment 中的一个数据，如果 segment 中没有数据就返回 false。
+// lru 为 true 时会抽样 samples 个数据，淘汰掉其中最久没有访问的，否则直接淘汰遍历到的第一个数据。
+// 这里同样利用了 Go 中 map 遍历的起点是随机的这个特性来抽样。
+func (s *segment) evict(samples int, lru bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var victimKey string
+	var victim *value
+	sampled := 0
+	s.data.each(func(key string, v *value) bool {
+		if victim == nil || atomic.LoadInt64(&v.Atime) < atomic.LoadInt64(&victim.Atime) {
+			victimKey, victim = key, v
+		}
+		sampled++
+		return lru && sampled < samples
+	})
+
+	if victim == nil {
+		return false
+	}
+	s.remove(victimKey, victim)
+	return true
 }
 
 // Status 返回这个 segment 的情况。
 func (s *segment) status() Status {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	return *s.Status
+	status := *s.Status
+	if s.wheel != nil {
+		status.ExpiredBacklog = s.wheel.backlog()
+	}
+	return status
 }
 
-// checkEntrySize 会判断数据容量是否已经达到了设定的上限。
-// 因为这个配置是针对整个缓存的，而这边判断大小是针对单个 segment 的，所以需要算出单个 segment 的上限来判断。
-func (s *segment) checkEntrySize(newKey string, newValue []byte) bool {
-	return s.Status.entrySize()+int64(len(newKey))+int64(len(newValue)) <= int64((s.options.MaxEntrySize*1024*1024)/s.options.SegmentSize)
+// schedule 将数据的过期时间记录下来，调用前需要先加锁。
+// 对于时间轮，只有过期时间提前了才需要重新记录，过期时间推后的话，等旧的事件触发时再重新记录就好了，这样滑动过期的数据就不用每次访问都更新时间轮。
+func (s *segment) schedule(key string, v *value) {
+	deadline := v.deadline()
+	if deadline == NeverDie {
+		return
+	}
+
+	if s.volatile != nil {
+		s.volatile[key] = struct{}{}
+		return
+	}
+
+	due := s.wheel.tickOf(deadline)
+	if v.due == 0 || due < v.due {
+		v.due = due
+		s.wheel.add(key, due)
+	}
 }
 
-func (s *segment) gc() {
+// gc 会清理时间轮中已经到期的数据，每次最多处理 maxCount 个事件，剩下的留到下一次 gc 处理。
+// 返回值是清理掉的数据个数。
+func (s *segment) gc(maxCount int) int {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	count := 0
-	for key, value := range s.Data {
+	s.wheel.advance(time.Now().UnixNano())
+
+	expired := 0
+	for _, entry := range s.wheel.pop(maxCount) {
+		// 数据已经被删除或者重新记录过了，说明这个事件已经过时了
+		current, ok := s.data.get(entry.key)
+		if !ok || current.due != entry.due {
+			continue
+		}
+
+		if !current.alive() {
+			s.remove(entry.key, current)
+			expired++
+			continue
+		}
+
+		// 数据还活着，说明访问过后过期时间推后了，或者已经设置为永不过期，需要重新记录
+		s.data.update(entry.key, func(v *value) {
+			v.due = 0
+			s.schedule(entry.key, v)
+		})
+	}
+	return expired
+}
+
+// sample 从带 ttl 的数据中抽样 n 个，并清理掉其中过期的数据。
+// 这里利用了 Go 中 map 遍历的起点是随机的这个特性来抽样。
+// 返回值是抽样的数据个数和清理掉的数据个数。
+func (s *segment) sample(n int) (sampled int, expired int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key := range s.volatile {
+		if sampled >= n {
+			break
+		}
+		sampled++
+
+		// 数据已经被删除或者不再带有 ttl，就不需要再记录了
+		value, ok := s.data.get(key)
+		if !ok || value.deadline() == NeverDie {
+			delete(s.volatile, key)
+			continue
+		}
+
 		if !value.alive() {
-			s.Status.subEntry(key, value.Data)
-			delete(s.Data, key)
-			count++
-			if count >= s.options.MaxGcCount {
-				break
-			}
+			s.remove(key, value)
+			expired++
 		}
 	}
+	return sampled, expired
+}
+
+// dump 返回这个 segment 需要持久化的数据。
+func (s *segment) dump() *segmentDump {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data := make(map[string]*value)
+	s.data.each(func(key string, v *value) bool {
+		data[key] = v
+		return true
+	})
+	return &segmentDump{Data: data}
 }
@@ -8,8 +8,50 @@ type Status struct {
 	// KeySize 记录着 key 占用的空间大小。
 	KeySize int64 `json:"keySize"`
 
-	// ValueSize 记录着 value 占用的空间大小。
+	// ValueSize 记录着 value 占用的空间大小，压缩过的数据按照压缩之后的大小计算。
 	ValueSize int64 `json:"valueSize"`
+
+	// RawValueSize 记录着 value 压缩之前的大小，和 ValueSize 的差就是压缩节省的空间。
+	RawValueSize int64 `json:"rawValueSize"`
+
+	// CompressedCount 记录着压缩过的数据个数。
+	CompressedCount int `json:"compressedCount"`
+
+	// UsedMemory 记录着估算的内存占用，除了 key 和 value 之外还包括每个键值对的额外开销。
+	UsedMemory int64 `json:"usedMemory"`
+
+	// MaxMemory 记录着内存占用的上限。
+	MaxMemory int64 `json:"maxMemory"`
+
+	// ExpiredBacklog 记录着已经到期但是还没被 gc 清理的数据个数。
+	// 只有 WheelGc 策略才能统计到这个值。
+	ExpiredBacklog int `json:"expiredBacklog"`
+
+	// Gc 记录着 gc 的运行情况。
+	Gc GcStatus `json:"gc"`
+}
+
+// GcStatus 记录着 gc 的运行情况。
+type GcStatus struct {
+
+	// Strategy 是 gc 使用的策略。
+	Strategy string `json:"strategy"`
+
+	// Cycles 记录着 gc 执行的次数。
+	Cycles int64 `json:"cycles"`
+
+	// Sampled 记录着 AdaptiveGc 策略下一共抽样的数据个数。
+	Sampled int64 `json:"sampled"`
+
+	// Expired 记录着 gc 一共清理的过期数据个数。
+	Expired int64 `json:"expired"`
+
+	// BudgetExceeded 记录着 AdaptiveGc 策略下用完时间预算而提前结束的次数。
+	BudgetExceeded int64 `json:"budgetExceeded"`
+
+	// LastCycleTime 记录着最近一次 gc 消耗的时间。
+	// 单位是纳秒。
+	LastCycleTime int64 `json:"lastCycleTime"`
 }
 
 // newStatus 返回一个缓存信息对象指针。
@@ -21,23 +63,26 @@ func NewStatus() *Status {
 	}
 }
 
-// addEntry 可以将 key 和 value 的信息记录起来。
-func (s *Status) addEntry(key string, value []byte) {
+// addEntry 可以将 key 和 value 的信息记录起来，size 是 value 的大小，rawSize 是 value 压缩之前的大小。
+func (s *Status) addEntry(key string, size int, rawSize int) {
 
 	s.Count++
 	s.KeySize += int64(len(key))
-	s.ValueSize += int64(len(value))
+	s.ValueSize += int64(size)
+	s.RawValueSize += int64(rawSize)
+	if rawSize > size {
+		s.CompressedCount++
+	}
 }
 
-// subEntry 可以将 key 和 value 的信息从 Status 中减去。
-func (s *Status) subEntry(key string, value []byte) {
+// subEntry 可以将 key 和 value 的信息从 Status 中减去，size 是 value 的大小，rawSize 是 value 压缩之前的大小。
+func (s *Status) subEntry(key string, size int, rawSize int) {
 	// 每减少一个键值对，count 就需要减 1，key 和 value 占用的空间也需要减去相应的大小。
 	s.Count--
 	s.KeySize -= int64(len(key))
-	s.ValueSize -= int64(len(value))
-}
-
-// entrySize 返回键值对占用的总大小。
-func (s *Status) entrySize() int64 {
-	return s.KeySize + s.ValueSize
+	s.ValueSize -= int64(size)
+	s.RawValueSize -= int64(rawSize)
+	if rawSize > size {
+		s.CompressedCount--
+	}
 }
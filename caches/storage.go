@@ -0,0 +1,107 @@
+package caches
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// MapStorage 是使用 map 存储数据的引擎，每个数据都是单独分配的对象。
+	MapStorage = "map"
+
+	// ArenaStorage 是使用预分配的环形字节缓冲区存储数据的引擎，数据再多也不会给 Go 的 GC 带来压力。
+	// 缓冲区写满之后会淘汰掉最旧的数据。
+	ArenaStorage = "arena"
+)
+
+// storage 是 segment 内部真正存储数据的引擎。
+// 引擎本身不是并发安全的，需要由 segment 的锁来保护，除了 get 和 touch 之外的方法都需要在写锁下调用。
+type storage interface {
+
+	// get 返回 key 对应的数据。
+	// 返回的数据只能读取，需要修改的话要使用 update 或者 touch，因为有些引擎返回的只是数据的副本。
+	get(key string) (*value, bool)
+
+	// set 保存 key 对应的数据，如果数据太大存不下就返回错误。
+	set(key string, v *value) error
+
+	// update 使用 fn 修改 key 对应的数据，fn 中不能修改 Data 字段。
+	// get 之前返回的数据可能正在锁外面被读取，所以 update 不能修改它们。
+	update(key string, fn func(v *value)) bool
+
+	// touch 将 key 对应的数据的访问时间更新为 atime，v 是 get 返回的数据，这个方法可以在读锁下调用。
+	touch(key string, v *value, atime int64)
+
+	// delete 删除 key 对应的数据。
+	delete(key string)
+
+	// each 遍历所有的数据，fn 返回 false 时停止遍历。
+	each(fn func(key string, v *value) bool)
+
+	// cost 返回一个键值对在这个引擎中估算的内存占用，size 是数据的大小，contentTypeSize 是 ContentType 的长度。
+	cost(key string, size int, contentTypeSize int) int64
+
+	// evicts 返回这个引擎在写满时是否会自己淘汰旧数据，这样的引擎不需要检查全局的内存上限。
+	evicts() bool
+}
+
+// newStorage 根据 options 创建存储引擎，hash 是引擎需要时使用的哈希算法，onEvict 会在引擎淘汰数据时被调用，参数是 key、数据的大小、数据压缩之前的大小和 ContentType 的长度。
+func newStorage(options *Options, hash Hasher, onEvict func(key string, size int, rawSize int, contentTypeSize int)) storage {
+	if options.StorageEngine == ArenaStorage {
+		return newArenaStorage(options.arenaSize(), hash, onEvict)
+	}
+	return newMapStorage(options.MapSizeOfSegment)
+}
+
+// mapStorage 是使用 map 存储数据的引擎。
+type mapStorage map[string]*value
+
+// newMapStorage 返回一个初始大小为 size 的 map 存储引擎。
+func newMapStorage(size int) mapStorage {
+	// 初始化 map 的时候给出初始大小，可以避免大量扩容带来的性能损耗
+	return make(mapStorage, size)
+}
+
+func (ms mapStorage) get(key string) (*value, bool) {
+	v, ok := ms[key]
+	return v, ok
+}
+
+func (ms mapStorage) set(key string, v *value) error {
+	ms[key] = v
+	return nil
+}
+
+// update 修改的是数据的副本，然后用副本替换掉原来的数据，这样持久化等在锁外面读取数据的地方就不会有数据竞争。
+func (ms mapStorage) update(key string, fn func(v *value)) bool {
+	v, ok := ms[key]
+	if ok {
+		updated := *v
+		fn(&updated)
+		ms[key] = &updated
+	}
+	return ok
+}
+
+func (ms mapStorage) touch(key string, v *value, atime int64) {
+	atomic.StoreInt64(&v.Atime, atime)
+}
+
+func (ms mapStorage) delete(key string) {
+	delete(ms, key)
+}
+
+func (ms mapStorage) each(fn func(key string, v *value) bool) {
+	for key, v := range ms {
+		if !fn(key, v) {
+			return
+		}
+	}
+}
+
+func (ms mapStorage) cost(key string, size int, contentTypeSize int) int64 {
+	return int64(len(key)) + int64(size) + int64(contentTypeSize) + entryOverhead
+}
+
+func (ms mapStorage) evicts() bool {
+	return false
+}
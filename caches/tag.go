@@ -0,0 +1,104 @@
+package caches
+
+import (
+	"sync"
+)
+
+// tagIndex 记录着标签和 key 之间的对应关系，一个命名空间中所有的 segment 共享同一个实例。
+// 为了在数据被删除、过期或者淘汰时能找到它的标签，这里同时记录了两个方向的映射。
+// 加锁的顺序永远是先 segment 后 tagIndex，所以在持有 tagIndex 的锁时不能再去操作 segment。
+type tagIndex struct {
+
+	// keys 是标签到 key 集合的映射。
+	keys map[string]map[string]struct{}
+
+	// tags 是 key 到标签列表的映射。
+	tags map[string][]string
+
+	lock *sync.Mutex
+}
+
+// newTagIndex 返回一个空的标签索引。
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		keys: make(map[string]map[string]struct{}),
+		tags: make(map[string][]string),
+		lock: &sync.Mutex{},
+	}
+}
+
+// replace 使用 tags 替换掉 key 原来的标签，tags 为空表示删除 key 所有的标签。
+func (ti *tagIndex) replace(key string, tags []string) {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	ti.remove(key)
+	if len(tags) == 0 {
+		return
+	}
+
+	for _, tag := range tags {
+		keys, ok := ti.keys[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			ti.keys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	ti.tags[key] = append([]string(nil), tags...)
+}
+
+// forget 删除 key 所有的标签。
+func (ti *tagIndex) forget(key string) {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	ti.remove(key)
+}
+
+// remove 删除 key 所有的标签，调用前需要先加锁。
+func (ti *tagIndex) remove(key string) {
+	for _, tag := range ti.tags[key] {
+		delete(ti.keys[tag], key)
+		if len(ti.keys[tag]) == 0 {
+			delete(ti.keys, tag)
+		}
+	}
+	delete(ti.tags, key)
+}
+
+// has 返回 key 现在是不是带有 tag 这个标签。
+func (ti *tagIndex) has(key string, tag string) bool {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	_, ok := ti.keys[tag][key]
+	return ok
+}
+
+// keysOf 返回带有 tag 这个标签的所有 key。
+func (ti *tagIndex) keysOf(tag string) []string {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	keys := make([]string, 0, len(ti.keys[tag]))
+	for key := range ti.keys[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// snapshot 返回 key 到标签列表的映射的副本，用于持久化。
+func (ti *tagIndex) snapshot() map[string][]string {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	tags := make(map[string][]string, len(ti.tags))
+	for key, keyTags := range ti.tags {
+		tags[key] = keyTags
+	}
+	return tags
+}
+
+// reset 删除所有的标签。
+func (ti *tagIndex) reset() {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	ti.keys = make(map[string]map[string]struct{})
+	ti.tags = make(map[string][]string)
+}
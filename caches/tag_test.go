@@ -0,0 +1,76 @@
+package caches
+
+import (
+	"testing"
+)
+
+// go test -v -run=^TestCacheInvalidateTag$
+func TestCacheInvalidateTag(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	cache.SetWith("user:1", []byte("user"), SetOptions{Tags: []string{"user:1"}})
+	cache.SetWith("user:1:orders", []byte("orders"), SetOptions{Tags: []string{"user:1", "orders"}})
+	cache.SetWith("user:2", []byte("user"), SetOptions{Tags: []string{"user:2"}})
+
+	// 覆盖数据时没有带标签，旧的标签就不再生效了
+	cache.SetWith("user:1:profile", []byte("profile"), SetOptions{Tags: []string{"user:1"}})
+	cache.Set("user:1:profile", []byte("profile"))
+
+	if invalidated := cache.InvalidateTag("user:1"); invalidated != 2 {
+		t.Fatalf("invalidated %d entries, want 2", invalidated)
+	}
+
+	for key, want := range map[string]bool{"user:1": false, "user:1:orders": false, "user:1:profile": true, "user:2": true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Fatalf("key %s exists %v, want %v", key, ok, want)
+		}
+	}
+
+	if invalidated := cache.InvalidateTag("orders"); invalidated != 0 {
+		t.Fatalf("tags of deleted entries should be forgotten but %d entries are invalidated", invalidated)
+	}
+
+	if len(cache.defaultNamespace.tags.keys) != 1 || len(cache.defaultNamespace.tags.tags) != 1 {
+		t.Fatalf("tag index should only contain user:2 but got %+v", cache.defaultNamespace.tags.tags)
+	}
+}
+
+// go test -v -run=^TestSegmentDeleteTagged$
+func TestSegmentDeleteTagged(t *testing.T) {
+
+	cache := newCache(DefaultOptions())
+	ns := cache.defaultNamespace
+
+	// 模拟按标签删除时 key 已经复制出来，但是在删除之前数据被覆盖成了不带标签的
+	tests := []struct {
+		key     string
+		tags    []string
+		deleted bool
+	}{
+		{key: "tagged", tags: []string{"tag"}, deleted: true},
+		{key: "retagged", tags: []string{"other"}, deleted: false},
+		{key: "untagged", tags: nil, deleted: false},
+	}
+
+	for _, test := range tests {
+		cache.SetWith(test.key, []byte("value"), SetOptions{Tags: []string{"tag"}})
+	}
+
+	if keys := ns.tags.keysOf("tag"); len(keys) != len(tests) {
+		t.Fatalf("all keys should carry the tag before overwriting but got %v", keys)
+	}
+
+	for _, test := range tests {
+		cache.SetWith(test.key, []byte("value"), SetOptions{Tags: test.tags})
+	}
+
+	for _, test := range tests {
+		if deleted := ns.segmentOf(test.key).deleteTagged(test.key, "tag"); deleted != test.deleted {
+			t.Fatalf("key %s should be deleted %v but got %v", test.key, test.deleted, deleted)
+		}
+
+		if _, ok := cache.Get(test.key); ok == test.deleted {
+			t.Fatalf("key %s should exist %v after deleting", test.key, !test.deleted)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package caches
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// loadPanickedErr 是加载数据时发生 panic 的错误，等待同一个 key 加载结果的调用会收到包装了它的错误。
+	loadPanickedErr = errors.New("loading the entry panicked")
+)
+
+// TypedCache 是带类型的缓存，它会使用 Codec 将数据序列化之后存储到 Cache 中，省去了每次使用都要自己序列化的麻烦。
+type TypedCache[K any, V any] struct {
+
+	// cache 是内部真正存储数据的缓存。
+	cache *Cache
+
+	// codec 是数据的编解码器。
+	codec Codec
+
+	// keyOf 将 K 类型的 key 转换成缓存中使用的字符串。
+	keyOf func(key K) string
+
+	// loads 记录着正在加载中的 key，同一个 key 同时只会加载一次，避免缓存击穿。
+	loads *loadGroup[V]
+}
+
+// NewTypedCache 返回一个使用 codec 序列化数据的带类型缓存，keyOf 为 nil 的话会使用 fmt.Sprint 转换 key。
+func NewTypedCache[K any, V any](cache *Cache, codec Codec, keyOf func(key K) string) *TypedCache[K, V] {
+	if keyOf == nil {
+		keyOf = func(key K) string {
+			return fmt.Sprint(key)
+		}
+	}
+
+	return &TypedCache[K, V]{
+		cache: cache,
+		codec: codec,
+		keyOf: keyOf,
+		loads: newLoadGroup[V](),
+	}
+}
+
+// Get 返回指定 key 的数据，如果数据不存在就返回 false。
+func (tc *TypedCache[K, V]) Get(key K) (V, bool, error) {
+	var v V
+	data, ok := tc.cache.Get(tc.keyOf(key))
+	if !ok {
+		return v, false, nil
+	}
+
+	err := tc.codec.Unmarshal(data, &v)
+	return v, err == nil, err
+}
+
+// Set 添加指定的数据到缓存中。
+func (tc *TypedCache[K, V]) Set(key K, value V) error {
+	return tc.SetWith(key, value, SetOptions{})
+}
+
+// SetWith 使用 options 添加指定的数据到缓存中。
+func (tc *TypedCache[K, V]) SetWith(key K, value V, options SetOptions) error {
+	data, err := tc.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return tc.cache.SetWith(tc.keyOf(key), data, options)
+}
+
+// Delete 从缓存中删除指定 key 的数据。
+func (tc *TypedCache[K, V]) Delete(key K) error {
+	return tc.cache.Delete(tc.keyOf(key))
+}
+
+// GetOrLoad 返回指定 key 的数据，如果数据不存在就使用 load 加载，并使用 options 添加到缓存中。
+// 同一个 key 同时只会调用一次 load，其他调用会等待并共享这次加载的结果。
+func (tc *TypedCache[K, V]) GetOrLoad(key K, options SetOptions, load func(key K) (V, error)) (V, error) {
+	if v, ok, err := tc.Get(key); ok || err != nil {
+		return v, err
+	}
+
+	return tc.loads.do(tc.keyOf(key), func() (V, error) {
+		// 等待锁的时候可能已经有人加载好了
+		if v, ok, err := tc.Get(key); ok || err != nil {
+			return v, err
+		}
+
+		v, err := load(key)
+		if err != nil {
+			return v, err
+		}
+		return v, tc.SetWith(key, v, options)
+	})
+}
+
+// loadCall 是一次正在进行中的加载。
+type loadCall[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// loadGroup 保证同一个 key 同时只有一次加载在进行。
+type loadGroup[V any] struct {
+	lock  sync.Mutex
+	calls map[string]*loadCall[V]
+}
+
+// newLoadGroup 返回一个新的 loadGroup 实例。
+func newLoadGroup[V any]() *loadGroup[V] {
+	return &loadGroup[V]{
+		calls: make(map[string]*loadCall[V]),
+	}
+}
+
+// do 执行 key 对应的加载，如果已经有加载在进行中，就等待它的结果。
+// load 发生 panic 的话，等待的调用会收到 loadPanickedErr，panic 会继续交给执行加载的调用。
+func (lg *loadGroup[V]) do(key string, load func() (V, error)) (V, error) {
+	lg.lock.Lock()
+	if call, ok := lg.calls[key]; ok {
+		lg.lock.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+
+	call := &loadCall[V]{}
+	call.wg.Add(1)
+	lg.calls[key] = call
+	lg.lock.Unlock()
+
+	// 放在 defer 中执行，这样 load 发生 panic 时等待的调用也能被唤醒，之后的调用也不会等待这次加载
+	defer func() {
+		r := recover()
+		if r != nil {
+			call.err = fmt.Errorf("%w: %v", loadPanickedErr, r)
+		}
+
+		lg.lock.Lock()
+		delete(lg.calls, key)
+		lg.lock.Unlock()
+		call.wg.Done()
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	call.value, call.err = load()
+	return call.value, call.err
+}
@@ -0,0 +1,113 @@
+package caches
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+// go test -v -cover -count=1 -test.cpu=1 -run=^TestTypedCache$
+func TestTypedCache(t *testing.T) {
+	codecs := map[string]Codec{"json": JSONCodec{}, "gob": GobCodec{}, "msgpack": MsgpackCodec{}}
+	for name, codec := range codecs {
+		cache := NewTypedCache[int, typedUser](NewCache(), codec, nil)
+		if _, ok, err := cache.Get(1); ok || err != nil {
+			t.Fatalf("%s: get of missing key returns %v %v", name, ok, err)
+		}
+
+		user := typedUser{Name: "kafo", Age: 18}
+		if err := cache.Set(1, user); err != nil {
+			t.Fatalf("%s: set failed: %v", name, err)
+		}
+
+		got, ok, err := cache.Get(1)
+		if !ok || err != nil || got != user {
+			t.Fatalf("%s: got %+v %v %v, want %+v", name, got, ok, err, user)
+		}
+	}
+}
+
+// go test -v -cover -count=1 -test.cpu=1 -run=^TestTypedCacheGetOrLoad$
+func TestTypedCacheGetOrLoad(t *testing.T) {
+	cache := NewTypedCache[string, int](NewCache(), JSONCodec{}, nil)
+	loads := int32(0)
+	load := func(key string) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		return len(key), nil
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := cache.GetOrLoad("key", SetOptions{}, load); err != nil || v != 3 {
+				t.Errorf("got %d %v, want 3", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Fatalf("loaded %d times, want 1", loads)
+	}
+
+	loadErr := errors.New("load failed")
+	_, err := cache.GetOrLoad("other", SetOptions{}, func(key string) (int, error) {
+		return 0, loadErr
+	})
+	if err != loadErr {
+		t.Fatalf("got error %v, want %v", err, loadErr)
+	}
+}
+
+// go test -v -run=^TestTypedCacheGetOrLoadPanic$
+func TestTypedCacheGetOrLoadPanic(t *testing.T) {
+	cache := NewTypedCache[string, int](NewCache(), JSONCodec{}, nil)
+	loading := make(chan struct{})
+	waiting := make(chan error, 1)
+
+	// 加载时发生 panic 的话，等待的调用要收到错误，而不是一直阻塞
+	go func() {
+		<-loading
+		_, err := cache.GetOrLoad("key", SetOptions{}, func(key string) (int, error) {
+			return len(key), nil
+		})
+		waiting <- err
+	}()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("panic in load should be passed on to the loader")
+			}
+		}()
+
+		cache.GetOrLoad("key", SetOptions{}, func(key string) (int, error) {
+			close(loading)
+			time.Sleep(50 * time.Millisecond)
+			panic("load failed")
+		})
+	}()
+
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, loadPanickedErr) {
+			t.Fatalf("waiter should get loadPanickedErr but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter should not block after load panicked")
+	}
+
+	// 之后的调用可以重新加载
+	if v, err := cache.GetOrLoad("key", SetOptions{}, func(key string) (int, error) { return 7, nil }); err != nil || v != 7 {
+		t.Fatalf("key should be loaded again but got %d, %v", v, err)
+	}
+}
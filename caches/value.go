@@ -9,8 +9,58 @@ import (
 const (
 	// NeverDie 是一个常量，我们设计的时候规定如果 ttl 为 0，那就是永不过期，相当于灵丹妙药。
 	NeverDie = 0
+
+	// legacyTimeLimit 用于区分以前版本持久化的秒级别的时间戳，小于它的就是秒级别的。
+	legacyTimeLimit = int64(1e12)
+)
+
+// ExpireMode 是数据的过期模式。
+type ExpireMode byte
+
+const (
+	// ExpireAbsolute 表示从写入的时候开始计算寿命，访问数据并不会延长寿命。
+	ExpireAbsolute ExpireMode = iota
+
+	// ExpireSliding 表示从最后一次访问的时候开始计算寿命，也就是说只要一直有访问，数据就不会过期。
+	ExpireSliding
+
+	// ExpireSlidingWithMax 和 ExpireSliding 一样会在访问时延长寿命，但是数据存活的时间不会超过最大寿命。
+	ExpireSlidingWithMax
 )
 
+// SetOptions 是添加数据时的选项配置。
+type SetOptions struct {
+
+	// Ttl 是数据的寿命，为 NeverDie 时表示永不过期。
+	Ttl time.Duration
+
+	// ExpireAt 是数据的过期时间点，不是零值的话会作为数据寿命的上限。
+	ExpireAt time.Time
+
+	// ExpireMode 是数据的过期模式。
+	ExpireMode ExpireMode
+
+	// MaxLifetime 是 ExpireSlidingWithMax 模式下数据的最大寿命。
+	MaxLifetime time.Duration
+
+	// Tags 是数据的标签，可以使用 InvalidateTag 一次删除带有同一个标签的所有数据。
+	// 覆盖数据时，旧数据的标签会被新数据的标签替换掉。
+	Tags []string
+
+	// Flags 是客户端自定义的标记，缓存只负责保存，类似于 memcached 中的 flags。
+	Flags uint32
+
+	// ContentType 是数据的内容类型，比如 application/json，最多 255 个字节。
+	ContentType string
+
+	// IfVersion 不为 0 的话，只有数据存在并且版本号等于它时才会写入，否则返回 PreconditionFailedErr。
+	// 配合 Meta 返回的版本号就可以实现乐观锁。
+	IfVersion uint64
+
+	// IfAbsent 为 true 的话，只有数据不存在时才会写入，否则返回 PreconditionFailedErr。
+	IfAbsent bool
+}
+
 // value 是一个包装了数据的结构体。
 type value struct {
 
@@ -18,28 +68,164 @@ type value struct {
 	Data []byte
 
 	// ttl 代表这个数据的寿命。
-	// 这个值的单位是秒。
+	// 这个值的单位是纳秒，这样才能支持毫秒甚至更小精度的过期时间。
 	Ttl int64
 
-	// ctime 代表这个数据的创建时间。
+	// Mode 代表这个数据的过期模式。
+	Mode ExpireMode
+
+	// Expire 代表这个数据绝对的过期时间点，为 0 表示没有绝对的过期时间点。
+	// 这个值是纳秒级别的 Unix 时间戳。
+	Expire int64
+
+	// ctime 代表这个数据的创建时间，覆盖数据时保持不变。
+	// 这个值是纳秒级别的 Unix 时间戳。
 	Ctime int64
+
+	// Mtime 代表这个数据最后一次写入的时间。
+	// 这个值是纳秒级别的 Unix 时间戳。
+	Mtime int64
+
+	// Version 代表这个数据的版本号，每次写入都会分配一个更大的版本号。
+	Version uint64
+
+	// ClientFlags 是客户端自定义的标记。
+	ClientFlags uint32
+
+	// ContentType 是数据的内容类型。
+	ContentType string
+
+	// Atime 代表这个数据最后一次被访问的时间，滑动过期就是基于这个时间计算的。
+	// 这个值是纳秒级别的 Unix 时间戳。
+	Atime int64
+
+	// Flags 是这个数据内部使用的标记，低 4 位记录着数据使用的压缩算法，0 表示数据没有压缩。
+	Flags byte
+
+	// RawSize 是数据压缩之前的大小，为 0 表示和 Data 的大小一样。
+	RawSize int
+
+	// due 代表这个数据在时间轮中预计过期的刻度，为 0 表示不在时间轮中。
+	// 这个值不需要持久化，恢复的时候会重新计算。
+	due int64
 }
 
-func newValue(data []byte, ttl int64) *value {
-	return &value{
+func newValue(data []byte, options SetOptions) *value {
+	now := time.Now().UnixNano()
+	v := &value{
 		// 注意修改字段为大写开头
-		Data:  helpers.Copy(data),
-		Ttl:   ttl,
-		Ctime: time.Now().Unix(),
+		Data:        helpers.Copy(data),
+		Ttl:         int64(options.Ttl),
+		Mode:        options.ExpireMode,
+		Ctime:       now,
+		Mtime:       now,
+		Atime:       now,
+		ClientFlags: options.Flags,
+		ContentType: options.ContentType,
 	}
+
+	// 绝对过期模式下，过期时间点在写入的时候就确定了；而滑动过期模式下，只有最大寿命是确定的
+	switch {
+	case !options.ExpireAt.IsZero():
+		v.Expire = options.ExpireAt.UnixNano()
+	case v.Mode == ExpireAbsolute && v.Ttl != NeverDie:
+		v.Expire = now + v.Ttl
+	case v.Mode == ExpireSlidingWithMax && options.MaxLifetime > 0:
+		v.Expire = now + int64(options.MaxLifetime)
+	}
+	return v
+}
+
+// upgrade 把以前版本持久化的数据转换成现在的格式。
+// 以前的版本中 Ttl 和 Ctime 的单位是秒，并且 Ctime 在每次访问时都会更新，所以这里按照持久化时剩余的寿命计算出绝对的过期时间点。
+// 纳秒级别的时间戳一定远大于 legacyTimeLimit，秒级别的时间戳在很长时间内都会小于它，所以可以用它来区分两种格式。
+func (v *value) upgrade() {
+	if v.Ctime >= legacyTimeLimit {
+		return
+	}
+
+	v.Ttl *= int64(time.Second)
+	v.Ctime *= int64(time.Second)
+	v.Mtime, v.Atime = v.Ctime, v.Ctime
+	v.Mode = ExpireAbsolute
+	if v.Ttl != NeverDie {
+		v.Expire = v.Ctime + v.Ttl
+	}
+}
+
+// rawSize 返回数据压缩之前的大小。
+func (v *value) rawSize() int {
+	if v.RawSize == 0 {
+		return len(v.Data)
+	}
+	return v.RawSize
+}
+
+// sliding 返回这个数据是否会在访问时延长寿命。
+func (v *value) sliding() bool {
+	return v.Mode != ExpireAbsolute && v.Ttl != NeverDie
 }
 
 func (v *value) alive() bool {
-	return v.Ttl == NeverDie || time.Now().Unix()-v.Ctime < v.Ttl
+	now := time.Now().UnixNano()
+	if v.Expire != 0 && now >= v.Expire {
+		return false
+	}
+	return !v.sliding() || now-atomic.LoadInt64(&v.Atime) < v.Ttl
+}
+
+// deadline 返回这个数据当前的过期时间点，如果数据永不过期就返回 NeverDie。
+// 这个值是纳秒级别的 Unix 时间戳，滑动过期的数据每次访问后这个值都会变大。
+func (v *value) deadline() int64 {
+	deadline := v.Expire
+	if v.sliding() {
+		slidingDeadline := atomic.LoadInt64(&v.Atime) + v.Ttl
+		if deadline == NeverDie || slidingDeadline < deadline {
+			deadline = slidingDeadline
+		}
+	}
+	return deadline
+}
+
+// ttl 返回这个数据剩余的寿命，如果数据永不过期就返回 NeverDie。
+func (v *value) ttl() time.Duration {
+	deadline := v.deadline()
+	if deadline == NeverDie {
+		return NeverDie
+	}
+
+	// 数据还存活着，所以剩余寿命至少是 1 纳秒，避免和 NeverDie 混淆
+	remaining := deadline - time.Now().UnixNano()
+	if remaining < 1 {
+		remaining = 1
+	}
+	return time.Duration(remaining)
+}
+
+// expire 重新设置这个数据的寿命，寿命从现在开始计算。
+// 滑动过期的数据只会改变滑动的时间窗口，而最大寿命保持不变。
+func (v *value) expire(ttl time.Duration) {
+	now := time.Now().UnixNano()
+	v.Ttl = int64(ttl)
+	atomic.StoreInt64(&v.Atime, now)
+	if v.Mode == ExpireAbsolute {
+		v.Expire = now + v.Ttl
+	}
+}
+
+// persist 移除这个数据的寿命，让它永不过期。
+func (v *value) persist() {
+	v.Ttl = NeverDie
+	v.Expire = 0
+}
+
+// touch 更新这个数据的访问时间，滑动过期的数据会因此延长寿命。
+func (v *value) touch() {
+	atomic.StoreInt64(&v.Atime, time.Now().UnixNano())
 }
 
 func (v *value) visit() []byte {
 	// 注意修改字段为大写开头
-	atomic.SwapInt64(&v.Ctime, time.Now().Unix())
+	atomic.StoreInt64(&v.Atime, time.Now().UnixNano())
 	return v.Data
-}
\ No newline at end of file
+}
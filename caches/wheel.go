@@ -0,0 +1,141 @@
+package caches
+
+const (
+	// wheelBits 是时间轮每一层格子数的位数，也就是每一层有 64 个格子。
+	wheelBits = 6
+
+	// wheelSlots 是时间轮每一层的格子数。
+	wheelSlots = 1 << wheelBits
+
+	// wheelMask 是用于计算格子下标的掩码。
+	wheelMask = wheelSlots - 1
+
+	// wheelLevels 是时间轮的层数，一共能覆盖 64^4 个刻度，按 100 毫秒一个刻度算大概是 19 天。
+	wheelLevels = 4
+)
+
+// wheelEntry 是时间轮中记录的一个过期事件。
+type wheelEntry struct {
+
+	// key 是会过期的数据的 key。
+	key string
+
+	// due 是这个数据预计过期的刻度。
+	due int64
+}
+
+// timingWheel 是分层时间轮，用于记录数据在什么时候过期。
+// 第 0 层每个格子代表一个刻度，第 1 层每个格子代表 64 个刻度，以此类推，越远的过期事件放在越高的层。
+// 时间每走到一个高层格子的起点，就会把这个格子里的事件重新放到低层去，最终在第 0 层触发。
+// 时间轮本身不是并发安全的，需要由 segment 的锁来保护。
+type timingWheel struct {
+
+	// tick 是一个刻度的时长，单位是纳秒。
+	tick int64
+
+	// current 是时间轮当前走到的刻度。
+	current int64
+
+	// slots 存储着每一层每个格子里的过期事件。
+	slots [wheelLevels][wheelSlots][]wheelEntry
+
+	// ready 存储着已经触发但是还没处理的过期事件。
+	ready []wheelEntry
+}
+
+// newTimingWheel 返回一个从 now 开始走的时间轮，now 是纳秒级别的 Unix 时间戳。
+func newTimingWheel(tick int64, now int64) *timingWheel {
+	return &timingWheel{
+		tick:    tick,
+		current: now / tick,
+	}
+}
+
+// tickOf 返回 deadline 所在的刻度，deadline 是纳秒级别的 Unix 时间戳。
+// 这里向上取整，保证事件触发的时候数据已经过期了。
+func (w *timingWheel) tickOf(deadline int64) int64 {
+	return (deadline + w.tick - 1) / w.tick
+}
+
+// add 添加一个在 due 这个刻度触发的过期事件。
+func (w *timingWheel) add(key string, due int64) {
+	entry := wheelEntry{key: key, due: due}
+	diff := due - w.current
+	if diff <= 0 {
+		w.ready = append(w.ready, entry)
+		return
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		if diff < 1<<(wheelBits*(level+1)) {
+			slot := (due >> (wheelBits * level)) & wheelMask
+			w.slots[level][slot] = append(w.slots[level][slot], entry)
+			return
+		}
+	}
+
+	// 超出时间轮范围的事件先放在最高层最远的格子里，到时候重新计算位置
+	top := wheelLevels - 1
+	slot := ((w.current >> (wheelBits * top)) - 1) & wheelMask
+	w.slots[top][slot] = append(w.slots[top][slot], entry)
+}
+
+// advance 让时间轮走到 now 所在的刻度，并把触发的事件放到 ready 中。
+func (w *timingWheel) advance(now int64) {
+	target := now / w.tick
+	if target-w.current >= 1<<(wheelBits*wheelLevels) {
+		// 落后太多的话一格格走没有意义，直接把所有事件拿出来重新放
+		w.current = target
+		for level := range w.slots {
+			for slot := range w.slots[level] {
+				entries := w.slots[level][slot]
+				w.slots[level][slot] = nil
+				for _, entry := range entries {
+					w.add(entry.key, entry.due)
+				}
+			}
+		}
+		return
+	}
+
+	for w.current < target {
+		w.current++
+
+		// 先从高层开始，把走到起点的格子里的事件放回低层
+		for level := wheelLevels - 1; level > 0; level-- {
+			if w.current&(1<<(wheelBits*level)-1) != 0 {
+				continue
+			}
+
+			slot := (w.current >> (wheelBits * level)) & wheelMask
+			entries := w.slots[level][slot]
+			w.slots[level][slot] = nil
+			for _, entry := range entries {
+				w.add(entry.key, entry.due)
+			}
+		}
+
+		slot := w.current & wheelMask
+		w.ready = append(w.ready, w.slots[0][slot]...)
+		w.slots[0][slot] = nil
+	}
+}
+
+// pop 取出最多 n 个已经触发的过期事件。
+func (w *timingWheel) pop(n int) []wheelEntry {
+	if n > len(w.ready) {
+		n = len(w.ready)
+	}
+
+	entries := w.ready[:n]
+	w.ready = w.ready[n:]
+	if len(w.ready) == 0 {
+		w.ready = nil
+	}
+	return entries
+}
+
+// backlog 返回已经触发但是还没处理的过期事件个数。
+func (w *timingWheel) backlog() int {
+	return len(w.ready)
+}
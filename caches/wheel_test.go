@@ -0,0 +1,34 @@
+package caches
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// go test -v -run=^TestTimingWheel$
+func TestTimingWheel(t *testing.T) {
+
+	wheel := newTimingWheel(1, 0)
+	dues := make(map[string]int64)
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(i)
+		dues[key] = 1 + rand.Int63n(1<<(wheelBits*wheelLevels)+1000)
+		wheel.add(key, dues[key])
+	}
+
+	// 一格格地走，每个事件都必须刚好在到期的刻度触发
+	for now := int64(1); len(dues) > 0; now++ {
+		wheel.advance(now)
+		for _, entry := range wheel.pop(wheel.backlog()) {
+			if entry.due != now {
+				t.Fatalf("entry %s is due at %d but fired at %d", entry.key, entry.due, now)
+			}
+			delete(dues, entry.key)
+		}
+
+		if now > 1<<(wheelBits*wheelLevels)+2000 {
+			t.Fatalf("%d entries never fired", len(dues))
+		}
+	}
+}
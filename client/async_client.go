@@ -1,8 +1,11 @@
 package client
 
 import (
+	"Rcache/helpers"
 	"Rcache/vex"
+	"crypto/tls"
 	"encoding/binary"
+	"time"
 )
 
 const (
@@ -17,6 +20,80 @@ const (
 
 	// statusCommand 是 status 的命令。
 	statusCommand = byte(4)
+
+	// expireCommand 是 expire 的命令。
+	expireCommand = byte(6)
+
+	// persistCommand 是 persist 的命令。
+	persistCommand = byte(7)
+
+	// ttlCommand 是 ttl 的命令。
+	ttlCommand = byte(8)
+
+	// touchCommand 是 touch 的命令。
+	touchCommand = byte(9)
+
+	// flushNamespaceCommand 是清空一个命名空间的命令。
+	flushNamespaceCommand = byte(10)
+
+	// invalidateTagCommand 是删除带有指定标签的所有数据的命令。
+	invalidateTagCommand = byte(11)
+
+	// flushCommand 是清空整个缓存的命令。
+	flushCommand = byte(12)
+
+	// metaCommand 是返回数据元信息的命令。
+	metaCommand = byte(13)
+
+	// reloadCommand 是重新加载配置的命令。
+	reloadCommand = byte(14)
+
+	// metricsCommand 是返回服务器指标的命令。
+	metricsCommand = byte(15)
+
+	// keysCommand 是管理 gossip 密钥的命令。
+	keysCommand = byte(16)
+
+	// authCommand 是认证的命令。
+	authCommand = byte(17)
+)
+
+const (
+	// pxOption 是 set 命令以毫秒为单位设置 ttl 的可选项。
+	pxOption = "PX"
+
+	// pxatOption 是 set 命令以毫秒级 Unix 时间戳设置过期时间的可选项。
+	pxatOption = "PXAT"
+
+	// modeOption 是 set 命令设置过期模式的可选项。
+	modeOption = "MODE"
+
+	// maxpxOption 是 set 命令以毫秒为单位设置最大寿命的可选项。
+	maxpxOption = "MAXPX"
+
+	// nsOption 是指定命名空间的可选项，跟在命令固定的参数后面。
+	nsOption = "NS"
+
+	// tagOption 是 set 命令设置数据标签的可选项，可以出现多次。
+	tagOption = "TAG"
+
+	// flagsOption 是 set 命令设置客户端自定义标记的可选项。
+	flagsOption = "FLAGS"
+
+	// ctypeOption 是 set 命令设置内容类型的可选项。
+	ctypeOption = "CTYPE"
+
+	// ifverOption 是 set 命令设置写入时要求的版本号的可选项。
+	ifverOption = "IFVER"
+
+	// nxOption 是 set 命令设置只有数据不存在时才写入的可选项。
+	nxOption = "NX"
+
+	// scopeOption 是指定命令执行范围的可选项，选项值是 localScope 时只在所连接的节点执行。
+	scopeOption = "SCOPE"
+
+	// localScope 表示命令只在所连接的节点执行。
+	localScope = "local"
 )
 
 // AsyncClient 是异步客户端。
@@ -27,6 +104,9 @@ type AsyncClient struct {
 
 	// requestChan 用于接收请求。
 	requestChan chan *request
+
+	// namespace 是这个客户端操作的命名空间，为空表示默认的命名空间。
+	namespace string
 }
 
 // NewAsyncClient 会创建一个异步客户端并返回。
@@ -36,13 +116,26 @@ func NewAsyncClient(address string) (*AsyncClient, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newAsyncClient(client), nil
+}
 
+// NewAsyncClientWithTLS 会创建一个使用 TLS 连接 address 的异步客户端并返回。
+func NewAsyncClientWithTLS(address string, config *tls.Config) (*AsyncClient, error) {
+	client, err := vex.NewTLSClient("tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	return newAsyncClient(client), nil
+}
+
+// newAsyncClient 使用 client 创建异步客户端，并开始处理请求。
+func newAsyncClient(client *vex.Client) *AsyncClient {
 	c := &AsyncClient{
 		client:      client,
 		requestChan: make(chan *request, 163840),
 	}
 	c.handleRequests()
-	return c, nil
+	return c
 }
 
 // handleRequests 会开启一个 goroutine 去处理请求。
@@ -72,28 +165,193 @@ func (ac *AsyncClient) do(command byte, args [][]byte) <-chan *Response {
 	return resultChan
 }
 
+// EnableCompression 开启传输压缩，比较大的请求和响应都会压缩之后再传输，需要在执行命令之前调用。
+func (ac *AsyncClient) EnableCompression() {
+	ac.client.EnableCompression()
+}
+
+// EnableThrottledReplies 开启限流答复码，被限流时返回的错误可以使用 errors.Is(err, vex.ThrottledErr) 判断，需要在执行命令之前调用。
+func (ac *AsyncClient) EnableThrottledReplies() {
+	ac.client.EnableThrottledReplies()
+}
+
+// Namespace 返回一个操作名为 name 的命名空间的客户端，它和当前客户端共用同一个连接和请求队列，所以只需要关闭其中一个。
+func (ac *AsyncClient) Namespace(name string) *AsyncClient {
+	return &AsyncClient{
+		client:      ac.client,
+		requestChan: ac.requestChan,
+		namespace:   name,
+	}
+}
+
+// withNamespace 在命令的固定参数后面加上命名空间的可选项，默认的命名空间不需要加。
+func (ac *AsyncClient) withNamespace(args [][]byte) [][]byte {
+	if ac.namespace == "" {
+		return args
+	}
+	return append(args, []byte(nsOption), []byte(ac.namespace))
+}
+
 // Get 用于执行 get 命令。
 func (ac *AsyncClient) Get(key string) <-chan *Response {
-	return ac.do(getCommand, [][]byte{[]byte(key)})
+	return ac.do(getCommand, ac.withNamespace([][]byte{[]byte(key)}))
 }
 
 // Set 用于执行 set 命令。
 func (ac *AsyncClient) Set(key string, value []byte, ttl int64) <-chan *Response {
 	ttlBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
-	return ac.do(setCommand, [][]byte{
+	return ac.do(setCommand, ac.withNamespace([][]byte{
 		ttlBytes, []byte(key), value,
-	})
+	}))
+}
+
+// SetWithDuration 用于执行带毫秒级 ttl 的 set 命令。
+func (ac *AsyncClient) SetWithDuration(key string, value []byte, ttl time.Duration) <-chan *Response {
+	return ac.SetWith(key, value, SetOptions{Ttl: ttl})
+}
+
+// SetWithExpireAt 用于执行在 expireAt 这个时间点过期的 set 命令。
+func (ac *AsyncClient) SetWithExpireAt(key string, value []byte, expireAt time.Time) <-chan *Response {
+	return ac.SetWith(key, value, SetOptions{ExpireAt: expireAt})
+}
+
+// SetWith 用于执行带选项配置的 set 命令。
+func (ac *AsyncClient) SetWith(key string, value []byte, options SetOptions) <-chan *Response {
+	args := [][]byte{helpers.Uint64ToBytes(0), []byte(key), value}
+	if options.Ttl != 0 {
+		args = append(args, []byte(pxOption), helpers.Uint64ToBytes(uint64(options.Ttl.Milliseconds())))
+	}
+
+	if !options.ExpireAt.IsZero() {
+		args = append(args, []byte(pxatOption), helpers.Uint64ToBytes(uint64(options.ExpireAt.UnixMilli())))
+	}
+
+	if options.ExpireMode != ExpireAbsolute {
+		args = append(args, []byte(modeOption), helpers.Uint64ToBytes(uint64(options.ExpireMode)))
+	}
+
+	if options.MaxLifetime > 0 {
+		args = append(args, []byte(maxpxOption), helpers.Uint64ToBytes(uint64(options.MaxLifetime.Milliseconds())))
+	}
+
+	for _, tag := range options.Tags {
+		args = append(args, []byte(tagOption), []byte(tag))
+	}
+
+	if options.Flags != 0 {
+		args = append(args, []byte(flagsOption), helpers.Uint64ToBytes(uint64(options.Flags)))
+	}
+
+	if options.ContentType != "" {
+		args = append(args, []byte(ctypeOption), []byte(options.ContentType))
+	}
+
+	if options.IfVersion != 0 {
+		args = append(args, []byte(ifverOption), helpers.Uint64ToBytes(options.IfVersion))
+	}
+
+	if options.IfAbsent {
+		args = append(args, []byte(nxOption), helpers.Uint64ToBytes(1))
+	}
+	return ac.do(setCommand, ac.withNamespace(args))
+}
+
+// Meta 用于执行 meta 命令。
+func (ac *AsyncClient) Meta(key string) <-chan *Response {
+	return ac.do(metaCommand, ac.withNamespace([][]byte{[]byte(key)}))
 }
 
 // Delete 用于执行 delete 命令。
 func (ac *AsyncClient) Delete(key string) <-chan *Response {
-	return ac.do(deleteCommand, [][]byte{[]byte(key)})
+	return ac.do(deleteCommand, ac.withNamespace([][]byte{[]byte(key)}))
+}
+
+// Expire 用于执行 expire 命令，ttl 会以毫秒的精度发送给服务端。
+func (ac *AsyncClient) Expire(key string, ttl time.Duration) <-chan *Response {
+	return ac.do(expireCommand, ac.withNamespace([][]byte{
+		[]byte(key), helpers.Uint64ToBytes(uint64(ttl.Milliseconds())),
+	}))
+}
+
+// Persist 用于执行 persist 命令。
+func (ac *AsyncClient) Persist(key string) <-chan *Response {
+	return ac.do(persistCommand, ac.withNamespace([][]byte{[]byte(key)}))
+}
+
+// TTL 用于执行 ttl 命令，可以使用 Response.ToTTL 解析结果。
+func (ac *AsyncClient) TTL(key string) <-chan *Response {
+	return ac.do(ttlCommand, ac.withNamespace([][]byte{[]byte(key)}))
+}
+
+// Touch 用于执行 touch 命令。
+func (ac *AsyncClient) Touch(key string) <-chan *Response {
+	return ac.do(touchCommand, ac.withNamespace([][]byte{[]byte(key)}))
 }
 
 // Status 用于执行 status 命令。
 func (ac *AsyncClient) Status() <-chan *Response {
-	return ac.do(statusCommand, nil)
+	return ac.do(statusCommand, ac.withNamespace(nil))
+}
+
+// InvalidateTag 用于执行删除整个集群中带有 tag 这个标签的所有数据的命令，可以使用 Response.ToCount 获取删除的数据个数。
+func (ac *AsyncClient) InvalidateTag(tag string) <-chan *Response {
+	return ac.do(invalidateTagCommand, ac.withNamespace([][]byte{[]byte(tag)}))
+}
+
+// Flush 用于执行清空整个集群中所有数据的命令。
+func (ac *AsyncClient) Flush() <-chan *Response {
+	return ac.do(flushCommand, nil)
+}
+
+// FlushNode 用于执行清空所连接的节点中所有数据的命令，其他节点不受影响。
+func (ac *AsyncClient) FlushNode() <-chan *Response {
+	return ac.do(flushCommand, [][]byte{[]byte(scopeOption), []byte(localScope)})
+}
+
+// FlushNamespace 用于执行清空这个客户端的命名空间的命令，只会清空所连接的节点中的数据。
+func (ac *AsyncClient) FlushNamespace() <-chan *Response {
+	return ac.do(flushNamespaceCommand, [][]byte{[]byte(ac.namespace)})
+}
+
+// Reload 用于执行让所连接的节点重新加载配置的命令，可以使用 Response.ToStrings 获取需要重启才能生效的配置名称。
+func (ac *AsyncClient) Reload() <-chan *Response {
+	return ac.do(reloadCommand, nil)
+}
+
+// Auth 用于执行使用用户名和密码认证的命令，认证通过之后这个客户端的请求都以这个用户的身份执行。
+func (ac *AsyncClient) Auth(name string, password string) <-chan *Response {
+	return ac.do(authCommand, [][]byte{[]byte(name), []byte(password)})
+}
+
+// AuthToken 用于执行使用令牌认证的命令，认证通过之后这个客户端的请求都以令牌所属用户的身份执行。
+func (ac *AsyncClient) AuthToken(token string) <-chan *Response {
+	return ac.do(authCommand, [][]byte{[]byte(token)})
+}
+
+// Metrics 用于执行返回所连接的节点的指标的命令，响应体是 json 格式的。
+func (ac *AsyncClient) Metrics() <-chan *Response {
+	return ac.do(metricsCommand, nil)
+}
+
+// ListKeys 用于执行返回所连接的节点的 gossip 密钥的指纹的命令，响应体是 json 格式的。
+func (ac *AsyncClient) ListKeys() <-chan *Response {
+	return ac.do(keysCommand, [][]byte{[]byte("list")})
+}
+
+// InstallKey 用于执行在集群中所有节点上添加 base64 编码的 gossip 密钥的命令。
+func (ac *AsyncClient) InstallKey(key string) <-chan *Response {
+	return ac.do(keysCommand, [][]byte{[]byte("install"), []byte(key)})
+}
+
+// UseKey 用于执行把集群中所有节点加密使用的 gossip 密钥设置为 key 的命令。
+func (ac *AsyncClient) UseKey(key string) <-chan *Response {
+	return ac.do(keysCommand, [][]byte{[]byte("use"), []byte(key)})
+}
+
+// RemoveKey 用于执行在集群中所有节点上删除 gossip 密钥 key 的命令。
+func (ac *AsyncClient) RemoveKey(key string) <-chan *Response {
+	return ac.do(keysCommand, [][]byte{[]byte("remove"), []byte(key)})
 }
 
 // Close 关闭客户端并释放资源。
@@ -1,6 +1,25 @@
 package client
 
-import "encoding/json"
+import (
+	"Rcache/helpers"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ExpireMode 是数据的过期模式，和服务端的 caches.ExpireMode 一一对应。
+type ExpireMode byte
+
+const (
+	// ExpireAbsolute 表示从写入的时候开始计算寿命。
+	ExpireAbsolute ExpireMode = iota
+
+	// ExpireSliding 表示从最后一次访问的时候开始计算寿命。
+	ExpireSliding
+
+	// ExpireSlidingWithMax 表示滑动过期，但是存活时间不超过最大寿命。
+	ExpireSlidingWithMax
+)
 
 // Status 是缓存状态结构体。
 type Status struct {
@@ -11,8 +30,101 @@ type Status struct {
 	// KeySize 是 key 占用的大小
 	KeySize int64 `json:"keySize"`
 
-	// ValueSize 是 value 占用的大小。
+	// ValueSize 是 value 占用的大小，压缩过的数据按照压缩之后的大小计算。
 	ValueSize int64 `json:"valueSize"`
+
+	// RawValueSize 是 value 压缩之前的大小。
+	RawValueSize int64 `json:"rawValueSize"`
+
+	// CompressedCount 是压缩过的数据个数。
+	CompressedCount int `json:"compressedCount"`
+
+	// UsedMemory 是估算的内存占用。
+	UsedMemory int64 `json:"usedMemory"`
+
+	// MaxMemory 是内存占用的上限。
+	MaxMemory int64 `json:"maxMemory"`
+
+	// ExpiredBacklog 是已经到期但是还没被清理的数据个数。
+	ExpiredBacklog int `json:"expiredBacklog"`
+
+	// Gc 是 gc 的运行情况。
+	Gc GcStatus `json:"gc"`
+}
+
+// GcStatus 是 gc 运行情况的结构体。
+type GcStatus struct {
+
+	// Strategy 是 gc 使用的策略。
+	Strategy string `json:"strategy"`
+
+	// Cycles 是 gc 执行的次数。
+	Cycles int64 `json:"cycles"`
+
+	// Sampled 是抽样的数据个数。
+	Sampled int64 `json:"sampled"`
+
+	// Expired 是清理的过期数据个数。
+	Expired int64 `json:"expired"`
+
+	// BudgetExceeded 是用完时间预算的次数。
+	BudgetExceeded int64 `json:"budgetExceeded"`
+
+	// LastCycleTime 是最近一次 gc 消耗的时间，单位是纳秒。
+	LastCycleTime int64 `json:"lastCycleTime"`
+}
+
+// SetOptions 是 set 命令的选项配置。
+type SetOptions struct {
+
+	// Ttl 是数据的寿命，为 0 时表示永不过期。
+	Ttl time.Duration
+
+	// ExpireAt 是数据的过期时间点。
+	ExpireAt time.Time
+
+	// ExpireMode 是数据的过期模式。
+	ExpireMode ExpireMode
+
+	// MaxLifetime 是 ExpireSlidingWithMax 模式下数据的最大寿命。
+	MaxLifetime time.Duration
+
+	// Tags 是数据的标签。
+	Tags []string
+
+	// Flags 是客户端自定义的标记。
+	Flags uint32
+
+	// ContentType 是数据的内容类型。
+	ContentType string
+
+	// IfVersion 不为 0 的话，只有数据当前的版本号等于它时才会写入。
+	IfVersion uint64
+
+	// IfAbsent 为 true 的话，只有数据不存在时才会写入。
+	IfAbsent bool
+}
+
+// Meta 是数据的元信息结构体。
+type Meta struct {
+
+	// Version 是数据的版本号，每次写入都会变大。
+	Version uint64 `json:"version"`
+
+	// CreatedAt 是数据第一次写入的时间。
+	CreatedAt time.Time `json:"createdAt"`
+
+	// UpdatedAt 是数据最后一次写入的时间。
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Flags 是客户端自定义的标记。
+	Flags uint32 `json:"flags"`
+
+	// ContentType 是数据的内容类型。
+	ContentType string `json:"contentType"`
+
+	// Size 是数据的大小。
+	Size int `json:"size"`
 }
 
 // request 是请求结构体。
@@ -46,3 +158,48 @@ func (r *Response) ToStatus() (*Status, error) {
 	status := &Status{}
 	return status, json.Unmarshal(r.Body, status)
 }
+
+// ToMeta 会返回 meta 命令的元信息和错误。
+func (r *Response) ToMeta() (*Meta, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	meta := &Meta{}
+	return meta, json.Unmarshal(r.Body, meta)
+}
+
+// ToStrings 会返回 reload 这类命令响应的字符串列表和错误。
+func (r *Response) ToStrings() ([]string, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	var strs []string
+	return strs, json.Unmarshal(r.Body, &strs)
+}
+
+// ToTTL 会返回 ttl 命令的剩余寿命和错误，0 表示永不过期。
+func (r *Response) ToTTL() (time.Duration, error) {
+	if r.Err != nil {
+		return 0, r.Err
+	}
+
+	milliseconds, ok := helpers.BytesToUint64(r.Body)
+	if !ok {
+		return 0, errors.New("invalid ttl response")
+	}
+	return time.Duration(milliseconds) * time.Millisecond, nil
+}
+
+// ToCount 会返回 invalidateTag 这类命令响应的个数和错误。
+func (r *Response) ToCount() (int, error) {
+	if r.Err != nil {
+		return 0, r.Err
+	}
+
+	count, ok := helpers.BytesToUint64(r.Body)
+	if !ok {
+		return 0, errors.New("invalid count response")
+	}
+	return int(count), nil
+}
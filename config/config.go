@@ -0,0 +1,256 @@
+package config
+
+import (
+	"Rcache/caches"
+	"Rcache/helpers"
+	"Rcache/servers"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix 是覆盖配置的环境变量的前缀。
+	// 环境变量的名称是前缀加上大写的字段路径，比如 RCACHE_LOGLEVEL、RCACHE_SERVER_PORT、RCACHE_CACHE_MAXMEMORY。
+	envPrefix = "RCACHE"
+)
+
+// Config 是整个程序的配置，可以从配置文件中读取。
+type Config struct {
+
+	// LogLevel 是日志级别，可以是 debug、info、warn 或者 error。
+	LogLevel string
+
+	// ShutdownTimeout 是关闭服务器时等待正在处理的请求完成的最长时间。
+	// 单位是秒。
+	ShutdownTimeout int
+
+	// Server 是服务器的选项配置。
+	Server servers.Options
+
+	// Cache 是缓存的选项配置。
+	Cache caches.Options
+}
+
+// Default 返回默认的配置。
+func Default() Config {
+	return Config{
+		LogLevel:        helpers.InfoLevel,
+		ShutdownTimeout: 30,
+		Server:          servers.DefaultOptions(),
+		Cache:           caches.DefaultOptions(),
+	}
+}
+
+// Validate 严格检查配置是否合法。
+func (c *Config) Validate() error {
+	if _, err := helpers.ParseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("ShutdownTimeout must be positive but got %d", c.ShutdownTimeout)
+	}
+
+	if err := c.Server.Validate(); err != nil {
+		return fmt.Errorf("invalid server options: %w", err)
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		return fmt.Errorf("invalid cache options: %w", err)
+	}
+	return nil
+}
+
+// LoadFile 从配置文件中读取配置，覆盖掉 config 中对应的字段，文件中没有的字段保持不变。
+// 根据文件的扩展名选择格式，支持 .json、.yaml、.yml 和 .toml，字段名不区分大小写，但是不能出现不认识的字段。
+func LoadFile(path string, config *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// yaml 和 toml 先转换成 json，这样三种格式的字段匹配规则和检查都是一样的
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		content, err = yamlToJSON(content)
+	case ".toml":
+		content, err = tomlToJSON(content)
+	default:
+		return fmt.Errorf("unknown format of config file %s", path)
+	}
+
+	if err != nil {
+		return err
+	}
+	return decodeJSON(content, config)
+}
+
+// yamlToJSON 将 yaml 格式的内容转换成 json。
+func yamlToJSON(content []byte) ([]byte, error) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// tomlToJSON 将 toml 格式的内容转换成 json。
+func tomlToJSON(content []byte) ([]byte, error) {
+	var values map[string]interface{}
+	if err := toml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// decodeJSON 将 json 格式的内容解析到 config 中，出现不认识的字段会返回错误。
+// 命名空间的配置中没有出现的字段使用命名空间的默认配置。
+func decodeJSON(content []byte, config *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Cache struct {
+			Namespaces map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return err
+	}
+
+	namespaces, err := namespacesOf(raw.Cache.Namespaces)
+	if err != nil {
+		return err
+	}
+
+	if namespaces != nil {
+		config.Cache.Namespaces = namespaces
+	}
+	return nil
+}
+
+// ParseNamespaces 解析 json 格式的命名空间配置，没有出现的字段使用默认值，比如 {"team-a":{"MaxMemory":"512MB"}}。
+func ParseNamespaces(namespaces string) (map[string]caches.NamespaceOptions, error) {
+	if namespaces == "" {
+		return nil, nil
+	}
+
+	raws := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(namespaces), &raws); err != nil {
+		return nil, err
+	}
+	return namespacesOf(raws)
+}
+
+// namespacesOf 使用默认值解析每个命名空间的配置。
+func namespacesOf(raws map[string]json.RawMessage) (map[string]caches.NamespaceOptions, error) {
+	if raws == nil {
+		return nil, nil
+	}
+
+	result := make(map[string]caches.NamespaceOptions, len(raws))
+	for name, raw := range raws {
+		options := caches.DefaultNamespaceOptions()
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&options); err != nil {
+			return nil, fmt.Errorf("invalid options of namespace %s: %w", name, err)
+		}
+		result[name] = options
+	}
+	return result, nil
+}
+
+// ApplyEnv 使用环境变量覆盖 config 中的字段，lookup 一般是 os.LookupEnv。
+// 字符串、数字和布尔类型的字段直接使用环境变量的值，Cluster 和 Listeners 这样的列表使用 "," 分隔，Namespaces 使用 json 格式。
+func ApplyEnv(config *Config, lookup func(name string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(config).Elem(), envPrefix, lookup)
+}
+
+// applyEnv 递归地使用环境变量覆盖 value 中的字段，prefix 是这一层字段对应的环境变量前缀。
+func applyEnv(value reflect.Value, prefix string, lookup func(name string) (string, bool)) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := prefix + "_" + strings.ToUpper(value.Type().Field(i).Name)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(field, env); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setField 将字符串 s 解析成 field 的类型并设置进去。
+func setField(field reflect.Value, s string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+
+			// 除了字符串之外，列表中的元素需要支持从文本中解析，比如 servers.Listener
+			elem := reflect.New(field.Type().Elem())
+			if unmarshaler, ok := elem.Interface().(encoding.TextUnmarshaler); ok {
+				if err := unmarshaler.UnmarshalText([]byte(item)); err != nil {
+					return err
+				}
+			} else if elem.Elem().Kind() == reflect.String {
+				elem.Elem().SetString(item)
+			} else {
+				return fmt.Errorf("unsupported type %s", field.Type())
+			}
+			items = reflect.Append(items, elem.Elem())
+		}
+		field.Set(items)
+	case reflect.Map:
+		namespaces, err := ParseNamespaces(s)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(namespaces))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
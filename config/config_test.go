@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// go test -v -run=^TestLoadFile$
+func TestLoadFile(t *testing.T) {
+
+	files := map[string]string{
+		"rcache.json": `{"logLevel":"debug","server":{"port":6000},"cache":{"maxMemory":"512MB","namespaces":{"team-a":{"MaxMemory":"64MB"}}}}`,
+		"rcache.yaml": "logLevel: debug\nserver:\n  port: 6000\ncache:\n  maxMemory: 512MB\n  namespaces:\n    team-a:\n      MaxMemory: 64MB\n",
+		"rcache.toml": "logLevel = \"debug\"\n[server]\nport = 6000\n[cache]\nmaxMemory = \"512MB\"\n[cache.namespaces.team-a]\nMaxMemory = \"64MB\"\n",
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config := Default()
+		if err := LoadFile(path, &config); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if config.LogLevel != "debug" || config.Server.Port != 6000 || config.Cache.MaxMemory != "512MB" {
+			t.Fatalf("%s: wrong config %+v", name, config)
+		}
+
+		// 没有出现的字段保持默认值
+		if config.Server.ServerType != Default().Server.ServerType || config.Cache.GcTick != Default().Cache.GcTick {
+			t.Fatalf("%s: fields not in file should keep default values but got %+v", name, config)
+		}
+
+		namespace := config.Cache.Namespaces["team-a"]
+		if namespace.MaxMemory != "64MB" || namespace.EvictionPolicy == "" {
+			t.Fatalf("%s: wrong namespace options %+v", name, namespace)
+		}
+
+		if err := config.Validate(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	// 不认识的字段会返回错误，避免拼写错误的配置被悄悄忽略
+	path := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(path, []byte("cache:\n  maxMemroy: 512MB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Default()
+	if err := LoadFile(path, &config); err == nil {
+		t.Fatal("unknown fields should be rejected")
+	}
+}
+
+// go test -v -run=^TestApplyEnv$
+func TestApplyEnv(t *testing.T) {
+
+	envs := map[string]string{
+		"RCACHE_LOGLEVEL":         "warn",
+		"RCACHE_SERVER_PORT":      "6001",
+		"RCACHE_SERVER_CLUSTER":   "127.0.0.1:7000, 127.0.0.1:7001",
+		"RCACHE_SERVER_LISTENERS": "http://127.0.0.1:6002",
+		"RCACHE_CACHE_MAXMEMORY":  "1GB",
+		"RCACHE_CACHE_NAMESPACES": `{"team-a":{"DefaultTtl":60}}`,
+	}
+
+	lookup := func(name string) (string, bool) {
+		value, ok := envs[name]
+		return value, ok
+	}
+
+	config := Default()
+	if err := ApplyEnv(&config, lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.LogLevel != "warn" || config.Server.Port != 6001 || config.Cache.MaxMemory != "1GB" {
+		t.Fatalf("wrong config %+v", config)
+	}
+
+	if len(config.Server.Cluster) != 2 || config.Server.Cluster[1] != "127.0.0.1:7001" {
+		t.Fatalf("wrong cluster %v", config.Server.Cluster)
+	}
+
+	if len(config.Server.Listeners) != 1 || config.Server.Listeners[0].String() != "http://127.0.0.1:6002" {
+		t.Fatalf("wrong listeners %v", config.Server.Listeners)
+	}
+
+	if config.Cache.Namespaces["team-a"].DefaultTtl != 60 {
+		t.Fatalf("wrong namespaces %+v", config.Cache.Namespaces)
+	}
+
+	envs["RCACHE_SERVER_PORT"] = "port"
+	if err := ApplyEnv(&config, lookup); err == nil {
+		t.Fatal("invalid environment variables should be rejected")
+	}
+}
@@ -1,13 +1,19 @@
 module Rcache
 
-go 1.17
+go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/golang/snappy v0.0.4
 	github.com/gomodule/redigo v1.8.8
+	github.com/hashicorp/go-msgpack v0.5.3
 	github.com/hashicorp/memberlist v0.3.1
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/klauspost/compress v1.15.15
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392
+	gopkg.in/yaml.v3 v3.0.1
 	stathat.com/c/consistent v1.0.0
-
 )
 
 require (
@@ -15,13 +21,11 @@ require (
 	github.com/google/btree v0.0.0-20180813153112-4030bb1f1f0c // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
-	github.com/hashicorp/go-msgpack v0.5.3 // indirect
 	github.com/hashicorp/go-multierror v1.0.0 // indirect
 	github.com/hashicorp/go-sockaddr v1.0.0 // indirect
 	github.com/hashicorp/golang-lru v0.5.0 // indirect
 	github.com/miekg/dns v1.1.26 // indirect
 	github.com/sean-/seed v0.0.0-20170313163322-e2103e2c3529 // indirect
-	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392 // indirect
 	golang.org/x/net v0.0.0-20190923162816-aa69164e4478 // indirect
 	golang.org/x/sys v0.0.0-20190924154521-2837fb4f24fe // indirect
 )
@@ -1,6 +1,11 @@
 package helpers
 
-import "strconv"
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 func Copy(src []byte) []byte {
 	dst := make([]byte, len(src))
@@ -11,3 +16,54 @@ func Copy(src []byte) []byte {
 func JoinAddressAndPort(address string, port int) string {
 	return address + ":" + strconv.Itoa(port)
 }
+
+// Uint64ToBytes 将 n 以大端的形式转换成 8 个字节。
+func Uint64ToBytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// BytesToUint64 将大端形式的 8 个字节转换成数字，如果字节数不对会返回 false。
+func BytesToUint64(b []byte) (uint64, bool) {
+	if len(b) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(b), true
+}
+
+// sizeUnits 是容量单位和字节数的对应关系，按 1024 进制计算。
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseSize 将 512MB、4GB 这样的容量解析成字节数，没有单位的话按字节处理。
+func ParseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	i := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(size)
+	}
+
+	unit, ok := sizeUnits[strings.TrimSpace(size[i:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit of size %s", size)
+	}
+
+	number, err := strconv.ParseFloat(size[:i], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return int64(number * float64(unit)), nil
+}
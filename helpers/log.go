@@ -0,0 +1,81 @@
+package helpers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// DebugLevel 会输出所有的日志。
+	DebugLevel = "debug"
+
+	// InfoLevel 会输出除了调试日志之外的日志。
+	InfoLevel = "info"
+
+	// WarnLevel 只会输出警告和错误日志。
+	WarnLevel = "warn"
+
+	// ErrorLevel 只会输出错误日志。
+	ErrorLevel = "error"
+)
+
+var (
+	// logLevels 是日志级别的名称和数值的对应关系，数值越大级别越高。
+	logLevels = map[string]int32{
+		DebugLevel: 0,
+		InfoLevel:  1,
+		WarnLevel:  2,
+		ErrorLevel: 3,
+	}
+
+	// logLevel 是当前的日志级别，低于这个级别的日志不会输出，可以在运行时修改，需要使用 atomic 包进行读写。
+	logLevel = logLevels[InfoLevel]
+)
+
+// ParseLogLevel 检查日志级别是否存在，大小写不敏感，返回的是小写的名称。
+func ParseLogLevel(level string) (string, error) {
+	level = strings.ToLower(level)
+	if _, ok := logLevels[level]; !ok {
+		return "", fmt.Errorf("unknown log level %s", level)
+	}
+	return level, nil
+}
+
+// SetLogLevel 修改日志级别，级别不存在的话就返回错误。
+func SetLogLevel(level string) error {
+	level, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&logLevel, logLevels[level])
+	return nil
+}
+
+// logf 在 level 不低于当前日志级别时输出日志。
+func logf(level string, format string, args ...interface{}) {
+	if logLevels[level] >= atomic.LoadInt32(&logLevel) {
+		log.Printf("["+strings.ToUpper(level)+"] "+format, args...)
+	}
+}
+
+// Debugf 输出调试日志。
+func Debugf(format string, args ...interface{}) {
+	logf(DebugLevel, format, args...)
+}
+
+// Infof 输出普通日志。
+func Infof(format string, args ...interface{}) {
+	logf(InfoLevel, format, args...)
+}
+
+// Warnf 输出警告日志。
+func Warnf(format string, args ...interface{}) {
+	logf(WarnLevel, format, args...)
+}
+
+// Errorf 输出错误日志。
+func Errorf(format string, args ...interface{}) {
+	logf(ErrorLevel, format, args...)
+}
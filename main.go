@@ -2,56 +2,251 @@ package main
 
 import (
 	"Rcache/caches"
+	"Rcache/config"
+	"Rcache/helpers"
 	"Rcache/servers"
+	"context"
 	"flag"
-	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
-func main() {
+// extraFlags 是不能直接绑定到配置字段上的 flag。
+type extraFlags struct {
 
-	// 准备服务器的选项配置
-	serverOptions := servers.DefaultOptions()
-	flag.StringVar(&serverOptions.Address, "address", serverOptions.Address, "The address used to listen, such as 127.0.0.1.")
-	flag.IntVar(&serverOptions.Port, "port", serverOptions.Port, "The port used to listen, such as 5837.")
-	flag.StringVar(&serverOptions.ServerType, "serverType", serverOptions.ServerType, "The type of server (http, tcp).")
-	flag.IntVar(&serverOptions.VirtualNodeCount, "virtualNodeCount", serverOptions.VirtualNodeCount, "The number of virtual nodes in consistent hash.")
-	flag.IntVar(&serverOptions.UpdateCircleDuration, "updateCircleDuration", serverOptions.UpdateCircleDuration, "The duration between two circle updating operations. The unit is second.")
-	cluster := flag.String("cluster", "", "The cluster of servers. One node in cluster will be ok.")
+	// cluster 是使用 "," 分隔的集群节点。
+	cluster *string
 
-	// 准备缓存的选项配置
-	cacheOptions := caches.DefaultOptions()
-	flag.IntVar(&cacheOptions.MaxEntrySize, "maxEntrySize", cacheOptions.MaxEntrySize, "The max memory size that entries can use. The unit is GB.")
-	flag.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of entries that gc will clean.")
-	flag.IntVar(&cacheOptions.GcDuration, "gcDuration", cacheOptions.GcDuration, "The duration between two gc tasks. The unit is Minute.")
-	flag.StringVar(&cacheOptions.DumpFile, "dumpFile", cacheOptions.DumpFile, "The file used to dump the cache.")
-	flag.IntVar(&cacheOptions.DumpDuration, "dumpDuration", cacheOptions.DumpDuration, "The duration between two dump tasks. The unit is Minute.")
-	flag.IntVar(&cacheOptions.MapSizeOfSegment, "mapSizeOfSegment", cacheOptions.MapSizeOfSegment, "The map size of segment.")
-	flag.IntVar(&cacheOptions.SegmentSize, "segmentSize", cacheOptions.SegmentSize, "The number of segment in a cache. This value should be the pow of 2 for precision.")
-	flag.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "The time of sleep in one cas step. The unit is Microsecond.")
+	// namespaces 是 json 格式的命名空间配置。
+	namespaces *string
+
+	// listeners 是使用 "," 分隔的额外监听器。
+	listeners *string
+}
+
+func main() {
+
+	// 命令行中只解析出配置文件的路径和设置过的 flag，真正的配置由 loadConfig 按照优先级合并出来
+	configFile := flag.String("config", "", "The config file in json, yaml or toml. Environment variables like RCACHE_SERVER_PORT override it and flags override both.")
+	defaults := config.Default()
+	bindFlags(flag.CommandLine, &defaults)
 	flag.Parse()
 
-	// 从 flag 中解析出集群信息
-	serverOptions.Cluster = nodesInCluster(*cluster)
+	setFlags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			setFlags[f.Name] = f.Value.String()
+		}
+	})
+
+	cfg, err := loadConfig(*configFile, setFlags)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = helpers.SetLogLevel(cfg.LogLevel); err != nil {
+		panic(err)
+	}
 
 	// 使用选项配置初始化缓存
-	cache := caches.NewCacheWith(cacheOptions)
+	cache := caches.NewCacheWith(cfg.Cache)
 	cache.AutoGc()
 	cache.AutoDump()
 
 	// 使用选项配置初始化服务器
-	server, err := servers.NewServer(cache, serverOptions)
+	server, err := servers.NewServer(cache, cfg.Server)
 	if err != nil {
 		panic(err)
 	}
 
-	log.Printf("Using server options %+v\n", serverOptions)
-	log.Printf("Using cache options %+v\n", cacheOptions)
-	log.Printf("Rcache is running on %s at %s:%d.", serverOptions.ServerType, serverOptions.Address, serverOptions.Port)
-	err = server.Run()
-	if err != nil {
-		panic(err)
+	// 重新加载配置时只有缓存中运行时可以修改的配置和日志级别会生效
+	reload := func() ([]string, error) {
+		next, err := loadConfig(*configFile, setFlags)
+		if err != nil {
+			return nil, err
+		}
+
+		ignored, err := cache.Reload(next.Cache)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = helpers.SetLogLevel(next.LogLevel); err != nil {
+			return nil, err
+		}
+
+		if len(ignored) > 0 {
+			helpers.Warnf("Options %v are changed but need restarting to take effect.", ignored)
+		}
+		helpers.Infof("Reloaded cache options %+v", next.Cache)
+		return ignored, nil
+	}
+	server.SetReloadHandler(reload)
+
+	helpers.Infof("Using server options %+v", cfg.Server)
+	helpers.Infof("Using cache options %+v", cfg.Cache)
+	helpers.Infof("Rcache is running on %s at %s:%d.", cfg.Server.ServerType, cfg.Server.Address, cfg.Server.Port)
+	for _, listener := range cfg.Server.Listeners {
+		helpers.Infof("Rcache is also running on %s.", listener)
+	}
+
+	// 服务器在另一个 goroutine 中运行，这里等待退出信号，SIGHUP 信号会重新加载配置
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- server.Run()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case err = <-runErr:
+			if err != nil {
+				panic(err)
+			}
+			running = false
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if _, err = reload(); err != nil {
+					helpers.Errorf("Failed to reload config: %v", err)
+				}
+				continue
+			}
+
+			helpers.Infof("Received signal %s, shutting down...", sig)
+			running = false
+		}
+	}
+
+	// 先停止服务并等待正在处理的请求完成，然后离开集群，最后关闭缓存，做最后一次持久化
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		helpers.Errorf("Failed to shutdown server: %v", err)
 	}
+
+	if err = cache.Close(); err != nil {
+		helpers.Errorf("Failed to dump cache: %v", err)
+	}
+	helpers.Infof("Rcache is stopped.")
+}
+
+// bindFlags 把 flag 绑定到 cfg 的字段上，flag 的默认值就是 cfg 中字段当前的值。
+func bindFlags(fs *flag.FlagSet, cfg *config.Config) extraFlags {
+
+	// 准备服务器的选项配置
+	serverOptions := &cfg.Server
+	fs.StringVar(&serverOptions.Address, "address", serverOptions.Address, "The address used to listen, such as 127.0.0.1.")
+	fs.IntVar(&serverOptions.Port, "port", serverOptions.Port, "The port used to listen, such as 5837.")
+	fs.StringVar(&serverOptions.ServerType, "serverType", serverOptions.ServerType, "The type of server (http, tcp).")
+	fs.IntVar(&serverOptions.VirtualNodeCount, "virtualNodeCount", serverOptions.VirtualNodeCount, "The number of virtual nodes in consistent hash.")
+	fs.IntVar(&serverOptions.UpdateCircleDuration, "updateCircleDuration", serverOptions.UpdateCircleDuration, "The duration between two circle updating operations. The unit is second.")
+	cluster := fs.String("cluster", "", "The gossip addresses of nodes in cluster, such as 127.0.0.1:7946. One node in cluster will be ok.")
+	listeners := fs.String("listeners", "", "The extra listeners sharing the cache with the main one, such as http://127.0.0.1:5838,tcp://0.0.0.0:5839.")
+	fs.StringVar(&serverOptions.Zone, "zone", serverOptions.Zone, "The zone of this node, such as the data center or availability zone.")
+	fs.IntVar(&serverOptions.Weight, "weight", serverOptions.Weight, "The weight of this node in consistent hash. A node with weight 2 gets about twice the entries of a node with weight 1.")
+	fs.StringVar(&serverOptions.GossipAddress, "gossipAddress", serverOptions.GossipAddress, "The address that gossip binds to. Use address if it is empty.")
+	fs.IntVar(&serverOptions.GossipPort, "gossipPort", serverOptions.GossipPort, "The port that gossip binds to. Use a random port if it is 0.")
+	fs.StringVar(&serverOptions.AdvertiseAddress, "advertiseAddress", serverOptions.AdvertiseAddress, "The gossip address advertised to other nodes, useful behind NAT or in containers.")
+	fs.IntVar(&serverOptions.AdvertisePort, "advertisePort", serverOptions.AdvertisePort, "The gossip port advertised to other nodes. Use gossipPort if it is 0.")
+	fs.StringVar(&serverOptions.GossipProfile, "gossipProfile", serverOptions.GossipProfile, "The profile of gossip (lan, wan, local).")
+	fs.IntVar(&serverOptions.JoinRetries, "joinRetries", serverOptions.JoinRetries, "The times of retrying when joining the cluster fails.")
+	fs.IntVar(&serverOptions.JoinRetryInterval, "joinRetryInterval", serverOptions.JoinRetryInterval, "The interval before the first retry of joining, doubled after each retry. The unit is Millisecond.")
+	fs.StringVar(&serverOptions.TLSCertFile, "tlsCertFile", serverOptions.TLSCertFile, "The PEM certificate file. All listeners and requests between nodes use TLS if it is set.")
+	fs.StringVar(&serverOptions.TLSKeyFile, "tlsKeyFile", serverOptions.TLSKeyFile, "The PEM private key file of the certificate.")
+	fs.StringVar(&serverOptions.TLSCAFile, "tlsCAFile", serverOptions.TLSCAFile, "The PEM CA file to verify certificates of clients and other nodes. Use the system roots if it is empty.")
+	fs.BoolVar(&serverOptions.TLSClientAuth, "tlsClientAuth", serverOptions.TLSClientAuth, "Require certificates of clients signed by the CA (mutual TLS).")
+	fs.StringVar(&serverOptions.TLSMinVersion, "tlsMinVersion", serverOptions.TLSMinVersion, "The minimum version of TLS (1.2, 1.3).")
+	fs.StringVar(&serverOptions.ACLFile, "aclFile", serverOptions.ACLFile, "The ACL file in json. All requests need authentication if it is set.")
+	fs.IntVar(&serverOptions.MaxConnections, "maxConnections", serverOptions.MaxConnections, "The max concurrent connections of each tcp listener. 0 means no limit.")
+	fs.IntVar(&serverOptions.CommandRate, "commandRate", serverOptions.CommandRate, "The max commands per second of each tcp connection or http client. 0 means no limit.")
+	fs.IntVar(&serverOptions.ByteRate, "byteRate", serverOptions.ByteRate, "The max request bytes per second of each tcp connection or http client. 0 means no limit.")
+	fs.IntVar(&serverOptions.UserCommandRate, "userCommandRate", serverOptions.UserCommandRate, "The max commands per second of each user when authentication is enabled. 0 means no limit.")
+	fs.IntVar(&serverOptions.UserByteRate, "userByteRate", serverOptions.UserByteRate, "The max request bytes per second of each user when authentication is enabled. 0 means no limit.")
+	fs.IntVar(&serverOptions.MaxBodySize, "maxBodySize", serverOptions.MaxBodySize, "The max bytes of each http request body. 0 means no limit.")
+	fs.IntVar(&cfg.ShutdownTimeout, "shutdownTimeout", cfg.ShutdownTimeout, "The max time to wait for in-flight requests when shutting down. The unit is second.")
+	fs.StringVar(&cfg.LogLevel, "logLevel", cfg.LogLevel, "The level of logs (debug, info, warn, error).")
+
+	// 准备缓存的选项配置
+	cacheOptions := &cfg.Cache
+	fs.StringVar(&cacheOptions.MaxMemory, "maxMemory", cacheOptions.MaxMemory, "The max memory size that entries can use, such as 512MB, 4GB.")
+	fs.IntVar(&cacheOptions.MaxEntrySize, "maxEntrySize", cacheOptions.MaxEntrySize, "Deprecated: use maxMemory instead. The max memory size that entries can use. The unit is GB.")
+	fs.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of expired entries that gc will handle in one segment each time.")
+	fs.IntVar(&cacheOptions.GcTick, "gcTickMs", cacheOptions.GcTick, "The duration between two gc tasks. The unit is Millisecond.")
+	fs.IntVar(&cacheOptions.GcDuration, "gcDuration", cacheOptions.GcDuration, "Deprecated: the duration between two gc tasks in Minute. It overrides gcTickMs if it is set.")
+	fs.StringVar(&cacheOptions.GcStrategy, "gcStrategy", cacheOptions.GcStrategy, "The strategy of gc (wheel, adaptive).")
+	fs.IntVar(&cacheOptions.GcSampleSize, "gcSampleSize", cacheOptions.GcSampleSize, "The count of entries that adaptive gc samples in one segment each round.")
+	fs.IntVar(&cacheOptions.GcExpiredThreshold, "gcExpiredThreshold", cacheOptions.GcExpiredThreshold, "The percentage of expired entries in samples that makes adaptive gc sample again.")
+	fs.IntVar(&cacheOptions.GcTimeBudget, "gcTimeBudget", cacheOptions.GcTimeBudget, "The max time that adaptive gc can use each time. The unit is Millisecond.")
+	fs.StringVar(&cacheOptions.DumpFile, "dumpFile", cacheOptions.DumpFile, "The file used to dump the cache.")
+	fs.IntVar(&cacheOptions.DumpDuration, "dumpDuration", cacheOptions.DumpDuration, "The duration between two dump tasks. The unit is Minute.")
+	fs.IntVar(&cacheOptions.MapSizeOfSegment, "mapSizeOfSegment", cacheOptions.MapSizeOfSegment, "The map size of segment.")
+	fs.StringVar(&cacheOptions.StorageEngine, "storageEngine", cacheOptions.StorageEngine, "The storage engine of segment (map, arena). Arena preallocates maxMemory and evicts the oldest entries when it is full.")
+	fs.IntVar(&cacheOptions.SegmentSize, "segmentSize", cacheOptions.SegmentSize, "The number of segment in a cache. This value must be a power of 2.")
+	fs.StringVar(&cacheOptions.HashFunction, "hashFunction", cacheOptions.HashFunction, "The hash function used to select segment (maphash, fnv, xxhash). fnv is not seeded and can not resist hash flooding.")
+	fs.StringVar(&cacheOptions.Compression, "compression", cacheOptions.Compression, "The compression of entries (none, snappy, zstd, gzip).")
+	fs.IntVar(&cacheOptions.CompressionThreshold, "compressionThreshold", cacheOptions.CompressionThreshold, "The min size of entries that will be compressed. The unit is byte.")
+	fs.IntVar(&cacheOptions.MaxNamespaces, "maxNamespaces", cacheOptions.MaxNamespaces, "The max count of namespaces that are not configured. They are created when entries are set into them.")
+	namespaces := fs.String("namespaces", "", `The options of namespaces in json, such as {"team-a":{"MaxMemory":"512MB","DefaultTtl":60,"EvictionPolicy":"lru"}}. The unit of DefaultTtl is second.`)
+	fs.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "The time of sleep in one cas step. The unit is Microsecond.")
+	return extraFlags{cluster: cluster, namespaces: namespaces, listeners: listeners}
+}
+
+// loadConfig 按照默认配置、配置文件、环境变量、命令行的顺序合并出配置，后面的优先级更高，最后严格检查配置是否合法。
+// setFlags 是命令行中设置过的 flag，只有设置过的 flag 才会覆盖前面的配置。
+func loadConfig(configFile string, setFlags map[string]string) (config.Config, error) {
+	cfg := config.Default()
+	if configFile != "" {
+		if err := config.LoadFile(configFile, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := config.ApplyEnv(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	fs := flag.NewFlagSet("Rcache", flag.ContinueOnError)
+	extra := bindFlags(fs, &cfg)
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return cfg, err
+		}
+	}
+
+	// 从 flag 中解析出集群信息
+	if _, ok := setFlags["cluster"]; ok {
+		cfg.Server.Cluster = nodesInCluster(*extra.cluster)
+	}
+
+	// 从 flag 中解析出额外的监听器
+	if _, ok := setFlags["listeners"]; ok {
+		listeners, err := listenersOf(*extra.listeners)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Server.Listeners = listeners
+	}
+
+	// 从 flag 中解析出命名空间的配置
+	if _, ok := setFlags["namespaces"]; ok {
+		namespaces, err := config.ParseNamespaces(*extra.namespaces)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Cache.Namespaces = namespaces
+	}
+
+	// 为了兼容以前的用法，只设置了 maxEntrySize 的话就使用它作为内存上限
+	_, maxEntrySizeSet := setFlags["maxEntrySize"]
+	_, maxMemorySet := setFlags["maxMemory"]
+	if maxEntrySizeSet && !maxMemorySet {
+		cfg.Cache.MaxMemory = ""
+	}
+	return cfg, cfg.Validate()
 }
 
 // nodesInCluster 使用 "," 分割 cluster 并解析出集群信息。
@@ -61,3 +256,20 @@ func nodesInCluster(cluster string) []string {
 	}
 	return strings.Split(cluster, ",")
 }
+
+// listenersOf 使用 "," 分割 listeners 并解析出每个监听器。
+func listenersOf(listeners string) ([]servers.Listener, error) {
+	var result []servers.Listener
+	for _, listener := range strings.Split(listeners, ",") {
+		if listener = strings.TrimSpace(listener); listener == "" {
+			continue
+		}
+
+		l, err := servers.ParseListener(listener)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
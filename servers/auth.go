@@ -0,0 +1,340 @@
+package servers
+
+import (
+	"Rcache/caches"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// ReadPermission 是读取数据和服务器信息的权限，比如 get、ttl、status 和 nodes。
+	ReadPermission = "read"
+
+	// WritePermission 是写入和删除数据的权限，比如 set、delete、expire 和按标签删除。
+	WritePermission = "write"
+
+	// AdminPermission 是管理服务器的权限，比如清空缓存、重新加载配置和管理 gossip 密钥。
+	AdminPermission = "admin"
+
+	// anyPattern 是 key 模式的通配符，只能出现在模式的结尾，比如 user:* 可以匹配所有 user: 开头的 key。
+	anyPattern = "*"
+
+	// peerAuth 是节点之间转发用户时 auth 命令的第一个参数，后面是用户名和节点的签名。
+	// 只有 PEER 一个参数的话表示申请签名时使用的一次性随机数。
+	peerAuth = "PEER"
+
+	// peerNonceSize 是转发用户时使用的一次性随机数的字节数。
+	peerNonceSize = 16
+)
+
+var (
+	// authRequiredErr 是请求没有认证的错误。
+	authRequiredErr = errors.New("authentication required")
+
+	// invalidCredentialsErr 是用户名、密码或者令牌不正确的错误。
+	invalidCredentialsErr = errors.New("invalid credentials")
+
+	// permissionDeniedErr 是用户没有权限执行请求的错误。
+	permissionDeniedErr = errors.New("permission denied")
+
+	// authNotEnabledErr 是没有开启认证时执行认证命令的错误。
+	authNotEnabledErr = errors.New("authentication is not enabled")
+
+	// peerAuthNeedsKeysErr 是没有开启 gossip 加密时在节点之间转发用户的错误，节点需要使用 gossip 密钥证明用户已经认证过了。
+	peerAuthNeedsKeysErr = errors.New("gossip encryption is required to forward users between nodes")
+
+	// adminNeedsAuthErr 是没有开启认证时执行管理命令的错误。
+	adminNeedsAuthErr = errors.New("admin commands need authentication to be enabled")
+
+	// peerNonceErr 是转发用户时没有使用这个连接申请的一次性随机数的错误，随机数使用一次之后就会失效，避免截获的认证被重放。
+	peerNonceErr = errors.New("peer authentication needs a fresh nonce of this connection")
+)
+
+// User 是 ACL 文件中的一个用户。
+type User struct {
+
+	// Name 是用户名，使用 basic auth 或者 auth 命令认证时需要它。
+	Name string `json:"name"`
+
+	// PasswordHash 是用户密码的 bcrypt 哈希值，ACL 文件中不保存明文的密码，为空的话这个用户只能使用令牌认证。
+	// 可以使用 htpasswd -nbBC 10 "" password | tr -d ':\n' 生成。
+	PasswordHash string `json:"passwordHash"`
+
+	// Tokens 是用户的令牌，使用 bearer token 或者只带一个参数的 auth 命令认证，方便轮换时同时使用多个。
+	Tokens []string `json:"tokens"`
+
+	// Permissions 是用户拥有的权限，可以是 read、write 和 admin，只读的用户只需要 read。
+	Permissions []string `json:"permissions"`
+
+	// Keys 是用户可以访问的 key 的模式，结尾可以是 *，为空表示可以访问所有的 key。
+	// 限制了 key 的用户只能读取不针对某个 key 的信息，比如 status，不能按标签删除或者清空缓存。
+	Keys []string `json:"keys"`
+
+	// Namespaces 是用户可以访问的命名空间，为空表示可以访问所有的命名空间。
+	// 限制了命名空间的用户只能读取不针对某个命名空间的信息，比如 nodes，不能清空整个缓存。
+	Namespaces []string `json:"namespaces"`
+}
+
+// ACL 是 ACL 文件的内容，里面是所有的用户。
+type ACL struct {
+
+	// Users 是所有的用户。
+	Users []User `json:"users"`
+}
+
+// access 是一个请求需要的权限和访问的数据。
+type access struct {
+
+	// permission 是请求需要的权限。
+	permission string
+
+	// namespace 是请求访问的命名空间，只有 scoped 为 true 时才有意义。
+	namespace string
+
+	// scoped 表示请求是不是只访问一个命名空间。
+	scoped bool
+
+	// key 是请求访问的 key，只有 keyed 为 true 时才有意义。
+	key string
+
+	// keyed 表示请求是不是只访问一个 key。
+	keyed bool
+}
+
+// loadACL 读取 json 格式的 ACL 文件，没有配置 ACL 文件的话返回 nil，表示不开启认证。
+func loadACL(file string) (*ACL, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := &ACL{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(acl); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL file %s: %w", file, err)
+	}
+
+	if err = acl.validate(); err != nil {
+		return nil, fmt.Errorf("invalid ACL file %s: %w", file, err)
+	}
+	return acl, nil
+}
+
+// validate 检查 ACL 是否合法，比如用户名和令牌不能重复、权限必须是认识的。
+func (acl *ACL) validate() error {
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, user := range acl.Users {
+		if user.Name == "" {
+			return errors.New("name of user can't be empty")
+		}
+
+		if names[user.Name] {
+			return fmt.Errorf("user %s is duplicated", user.Name)
+		}
+		names[user.Name] = true
+
+		if user.PasswordHash == "" && len(user.Tokens) == 0 {
+			return fmt.Errorf("user %s needs a password hash or a token", user.Name)
+		}
+
+		if user.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+				return fmt.Errorf("password hash of user %s is not a bcrypt hash: %w", user.Name, err)
+			}
+		}
+
+		for _, token := range user.Tokens {
+			if token == "" || tokens[token] {
+				return fmt.Errorf("token of user %s is empty or duplicated", user.Name)
+			}
+			tokens[token] = true
+		}
+
+		for _, permission := range user.Permissions {
+			if permission != ReadPermission && permission != WritePermission && permission != AdminPermission {
+				return fmt.Errorf("unknown permission %s of user %s", permission, user.Name)
+			}
+		}
+
+		for _, pattern := range user.Keys {
+			if strings.Contains(strings.TrimSuffix(pattern, anyPattern), anyPattern) {
+				return fmt.Errorf("key pattern %s of user %s can only end with %s", pattern, user.Name, anyPattern)
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate 使用用户名和密码认证，返回认证通过的用户，密码会和 bcrypt 哈希值比较。
+func (acl *ACL) authenticate(name string, password string) (*User, error) {
+	user, err := acl.user(name)
+	if err != nil || user.PasswordHash == "" {
+		return nil, invalidCredentialsErr
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, invalidCredentialsErr
+	}
+	return user, nil
+}
+
+// user 返回名为 name 的用户，不存在的话返回错误。
+func (acl *ACL) user(name string) (*User, error) {
+	for i := range acl.Users {
+		if acl.Users[i].Name == name {
+			return &acl.Users[i], nil
+		}
+	}
+	return nil, invalidCredentialsErr
+}
+
+// authenticateToken 使用令牌认证，返回认证通过的用户。
+func (acl *ACL) authenticateToken(token string) (*User, error) {
+	for i := range acl.Users {
+		user := &acl.Users[i]
+		for _, userToken := range user.Tokens {
+			if equals(userToken, token) {
+				return user, nil
+			}
+		}
+	}
+	return nil, invalidCredentialsErr
+}
+
+// authenticateRequest 使用 http 请求的 Authorization 请求头认证，支持 bearer token 和 basic auth。
+func (acl *ACL) authenticateRequest(request *http.Request) (*User, error) {
+	if name, password, ok := request.BasicAuth(); ok {
+		return acl.authenticate(name, password)
+	}
+
+	authorization := request.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, authRequiredErr
+	}
+
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return acl.authenticateToken(authorization[len("Bearer "):])
+	}
+	return nil, invalidCredentialsErr
+}
+
+// authenticatePeer 认证其他节点转发过来的名为 name 的用户，message 是节点签名的内容，signature 是节点的签名。
+// 只有集群中的节点才有 gossip 密钥，签名正确说明用户已经在其他节点上认证过了，这样用户的密码和令牌就不需要发送给其他节点。
+func (n *node) authenticatePeer(message []byte, name string, signature []byte) (*User, error) {
+	if n.keyring == nil {
+		return nil, peerAuthNeedsKeysErr
+	}
+
+	if err := n.verify(message, signature); err != nil {
+		return nil, err
+	}
+	return n.acl.user(name)
+}
+
+// newPeerNonce 返回转发用户时使用的一次性随机数。
+func newPeerNonce() ([]byte, error) {
+	nonce := make([]byte, peerNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// equals 使用固定的时间比较两个字符串，避免通过比较的时间猜出密码。
+func equals(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authorizeAnonymous 检查没有开启认证时能不能执行需要 a 的请求。
+// 清空缓存、重新加载配置和管理 gossip 密钥这些管理操作影响的是整个节点甚至整个集群，不知道是谁发来的话一律拒绝。
+func authorizeAnonymous(a access) error {
+	if a.permission == AdminPermission {
+		return adminNeedsAuthErr
+	}
+	return nil
+}
+
+// authorize 检查用户有没有权限执行需要 a 的请求。
+func (u *User) authorize(a access) error {
+	if !u.hasPermission(a.permission) {
+		return permissionDeniedErr
+	}
+
+	// 限制了命名空间或者 key 的用户只能读取不针对它们的信息，否则就可以通过清空缓存等方式影响别人的数据了
+	if len(u.Namespaces) > 0 {
+		if !a.scoped && a.permission != ReadPermission {
+			return permissionDeniedErr
+		}
+
+		if a.scoped && !u.canAccessNamespace(a.namespace) {
+			return permissionDeniedErr
+		}
+	}
+
+	if len(u.Keys) > 0 {
+		if !a.keyed && a.permission != ReadPermission {
+			return permissionDeniedErr
+		}
+
+		if a.keyed && !u.canAccessKey(a.key) {
+			return permissionDeniedErr
+		}
+	}
+	return nil
+}
+
+// hasPermission 返回用户是否拥有 permission 权限。
+func (u *User) hasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessNamespace 返回用户是否可以访问名为 name 的命名空间，空的名称就是默认的命名空间。
+func (u *User) canAccessNamespace(name string) bool {
+	if name == "" {
+		name = caches.DefaultNamespace
+	}
+
+	for _, namespace := range u.Namespaces {
+		if namespace == name {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessKey 返回用户是否可以访问 key。
+func (u *User) canAccessKey(key string) bool {
+	for _, pattern := range u.Keys {
+		if strings.HasSuffix(pattern, anyPattern) {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, anyPattern)) {
+				return true
+			}
+			continue
+		}
+
+		if pattern == key {
+			return true
+		}
+	}
+	return false
+}
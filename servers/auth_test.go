@@ -0,0 +1,300 @@
+package servers
+
+import (
+	"Rcache/vex"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestACL 返回测试使用的 ACL，所有用户的密码都是 secret。
+func newTestACL(t *testing.T) *ACL {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl := &ACL{Users: []User{
+		{Name: "admin", PasswordHash: string(hash), Tokens: []string{"admin-token"}, Permissions: []string{ReadPermission, WritePermission, AdminPermission}},
+		{Name: "reader", PasswordHash: string(hash), Permissions: []string{ReadPermission}, Keys: []string{"user:*"}, Namespaces: []string{"team-a"}},
+		{Name: "bot", Tokens: []string{"bot-token"}, Permissions: []string{WritePermission}},
+	}}
+	if err = acl.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return acl
+}
+
+// go test -v -run=^TestACLAuthenticate$
+func TestACLAuthenticate(t *testing.T) {
+
+	acl := newTestACL(t)
+	tests := []struct {
+		name     string
+		password string
+		token    string
+		user     string
+	}{
+		{name: "admin", password: "secret", user: "admin"},
+		{name: "admin", password: "wrong", user: ""},
+		{name: "nobody", password: "secret", user: ""},
+		{name: "bot", password: "", user: ""},
+		{token: "admin-token", user: "admin"},
+		{token: "bot-token", user: "bot"},
+		{token: "wrong-token", user: ""},
+	}
+
+	for _, test := range tests {
+		var user *User
+		var err error
+		if test.token != "" {
+			user, err = acl.authenticateToken(test.token)
+		} else {
+			user, err = acl.authenticate(test.name, test.password)
+		}
+
+		if test.user == "" && err == nil {
+			t.Fatalf("%+v should not be authenticated", test)
+		}
+
+		if test.user != "" && (err != nil || user.Name != test.user) {
+			t.Fatalf("%+v should be authenticated as %s but got %v", test, test.user, err)
+		}
+	}
+
+	// ACL 文件中只能保存密码的哈希值
+	plaintext := &ACL{Users: []User{{Name: "admin", PasswordHash: "secret"}}}
+	if plaintext.validate() == nil {
+		t.Fatal("plaintext password should be rejected")
+	}
+}
+
+// go test -v -run=^TestNodeAuthenticatePeer$
+func TestNodeAuthenticatePeer(t *testing.T) {
+
+	keyring, err := memberlist.NewKeyring(nil, bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &node{keyring: keyring, acl: newTestACL(t)}
+	message := func(name string) []byte {
+		return commandMessage(authCommand, [][]byte{[]byte(peerAuth), []byte(name)})
+	}
+
+	tests := []struct {
+		name      string
+		message   []byte
+		signature []byte
+		valid     bool
+	}{
+		{name: "admin", message: message("admin"), signature: n.signature(message("admin")), valid: true},
+		{name: "admin", message: message("admin"), signature: nil, valid: false},
+		{name: "admin", message: message("admin"), signature: n.signature(message("reader")), valid: false},
+		{name: "nobody", message: message("nobody"), signature: n.signature(message("nobody")), valid: false},
+	}
+
+	for _, test := range tests {
+		user, err := n.authenticatePeer(test.message, test.name, test.signature)
+		if (err == nil) != test.valid || (test.valid && user.Name != test.name) {
+			t.Fatalf("peer user %s should be valid %v but got %v", test.name, test.valid, err)
+		}
+	}
+
+	// 没有开启 gossip 加密的话节点之间不能转发用户
+	unencrypted := &node{acl: n.acl}
+	if _, err = unencrypted.authenticatePeer(message("admin"), "admin", nil); err != peerAuthNeedsKeysErr {
+		t.Fatalf("forwarding users without gossip keys should fail but got %v", err)
+	}
+
+	if err = (&TCPClient{}).authPeer("admin"); err != peerAuthNeedsKeysErr {
+		t.Fatalf("client without signer should not forward users but got %v", err)
+	}
+}
+
+// go test -v -run=^TestUserAuthorize$
+func TestUserAuthorize(t *testing.T) {
+
+	acl := newTestACL(t)
+	admin, _ := acl.user("admin")
+	reader, _ := acl.user("reader")
+	bot, _ := acl.user("bot")
+	args := func(args ...string) [][]byte {
+		result := make([][]byte, 0, len(args))
+		for _, arg := range args {
+			result = append(result, []byte(arg))
+		}
+		return result
+	}
+
+	// 限制了命名空间和 key 的用户只能访问自己的数据，不针对数据的命令只能读
+	tests := []struct {
+		name    string
+		user    *User
+		command byte
+		args    [][]byte
+		allowed bool
+	}{
+		{name: "admin flush", user: admin, command: flushCommand, allowed: true},
+		{name: "reader get", user: reader, command: getCommand, args: args("user:1", nsOption, "team-a"), allowed: true},
+		{name: "reader get default namespace", user: reader, command: getCommand, args: args("user:1"), allowed: false},
+		{name: "reader get other key", user: reader, command: getCommand, args: args("order:1", nsOption, "team-a"), allowed: false},
+		{name: "reader set", user: reader, command: setCommand, args: args("0", "user:1", "value", nsOption, "team-a"), allowed: false},
+		{name: "reader status", user: reader, command: statusCommand, allowed: true},
+		{name: "reader status of namespace", user: reader, command: statusCommand, args: args(nsOption, "team-b"), allowed: false},
+		{name: "reader flush namespace", user: reader, command: flushNamespaceCommand, args: args("team-a"), allowed: false},
+		{name: "bot set", user: bot, command: setCommand, args: args("0", "key", "value"), allowed: true},
+		{name: "bot get", user: bot, command: getCommand, args: args("key"), allowed: false},
+		{name: "bot reload", user: bot, command: reloadCommand, allowed: false},
+	}
+
+	for _, test := range tests {
+		if err := test.user.authorize(accessOf(test.command, test.args)); (err == nil) != test.allowed {
+			t.Fatalf("%s should be allowed %v but got %v", test.name, test.allowed, err)
+		}
+	}
+}
+
+// go test -v -run=^TestAdminNeedsAuth$
+func TestAdminNeedsAuth(t *testing.T) {
+
+	keyring, err := memberlist.NewKeyring(nil, bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有开启认证的话，谁都可以发送请求，所以管理命令一律拒绝，其他命令不受影响
+	n := &node{keyring: keyring}
+	ts := &TCPServer{node: n}
+	hs := &HTTPServer{node: n, options: &Options{}}
+	tests := []struct {
+		name       string
+		command    byte
+		permission string
+		allowed    bool
+	}{
+		{name: "get", command: getCommand, permission: ReadPermission, allowed: true},
+		{name: "set", command: setCommand, permission: WritePermission, allowed: true},
+		{name: "flush", command: flushCommand, permission: AdminPermission, allowed: false},
+		{name: "flush namespace", command: flushNamespaceCommand, permission: AdminPermission, allowed: false},
+		{name: "reload", command: reloadCommand, permission: AdminPermission, allowed: false},
+		{name: "keys", command: keysCommand, permission: AdminPermission, allowed: false},
+	}
+
+	for _, test := range tests {
+		if err := ts.authorize(&vex.Session{}, test.command, nil); (err == nil) != test.allowed {
+			t.Fatalf("tcp %s should be allowed %v but got %v", test.name, test.allowed, err)
+		}
+
+		recorder := httptest.NewRecorder()
+		handled := false
+		hs.guard(test.permission, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+			handled = true
+		})(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/keys/install", nil), nil)
+		if handled != test.allowed || (!test.allowed && recorder.Code != http.StatusForbidden) {
+			t.Fatalf("http %s should be allowed %v but got %d", test.name, test.allowed, recorder.Code)
+		}
+	}
+}
+
+// go test -v -run=^TestNodeKeys$
+func TestNodeKeys(t *testing.T) {
+
+	key := bytes.Repeat([]byte{1}, 16)
+	keyring, err := memberlist.NewKeyring(nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只返回密钥的指纹，不能返回密钥本身
+	keys, err := (&node{keyring: keyring}).keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys.Primary != fingerprintOf(key) || len(keys.Keys) != 1 || keys.Keys[0] != keys.Primary {
+		t.Fatalf("keys should only contain fingerprints but got %+v", keys)
+	}
+}
+
+// go test -v -run=^TestTCPServerPeerAuth$
+func TestTCPServerPeerAuth(t *testing.T) {
+
+	keyring, err := memberlist.NewKeyring(nil, bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &TCPServer{node: &node{keyring: keyring, acl: newTestACL(t)}, options: &Options{}}
+	peerAuthOf := func(session *vex.Session, name string) [][]byte {
+		nonce, err := ts.authHandler(session, [][]byte{[]byte(peerAuth)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := [][]byte{[]byte(peerAuth), []byte(name)}
+		return append(args, ts.signature(commandMessage(authCommand, [][]byte{args[0], args[1], nonce})))
+	}
+
+	session := &vex.Session{}
+	args := peerAuthOf(session, "admin")
+	if _, err = ts.authHandler(session, args); err != nil {
+		t.Fatal(err)
+	}
+
+	// 客户端要先申请随机数再发送签名
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := vex.NewServer()
+	server.RegisterSessionHandler(authCommand, ts.authHandler)
+	go server.ListenAndServe("tcp", listener.Addr().String())
+	listener.Close()
+	defer server.Close()
+
+	var client *TCPClient
+	for i := 0; client == nil; i++ {
+		if client, err = NewTCPClient(listener.Addr().String()); err != nil && i >= 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer client.Close()
+
+	client.sign = ts.signature
+	if err = client.authPeer("admin"); err != nil {
+		t.Fatalf("client should forward the user but got %v", err)
+	}
+
+	// 随机数只能使用一次，也只属于申请它的连接，截获的转发认证不能重放
+	other := &vex.Session{}
+	peerAuthOf(other, "admin")
+	tests := []struct {
+		name    string
+		session *vex.Session
+		args    [][]byte
+	}{
+		{name: "same connection", session: session, args: args},
+		{name: "other connection", session: other, args: args},
+		{name: "without nonce", session: &vex.Session{}, args: args},
+	}
+
+	for _, test := range tests {
+		if _, err = ts.authHandler(test.session, test.args); err == nil {
+			t.Fatalf("replaying peer auth on %s should fail", test.name)
+		}
+
+		if stateOf(test.session, ts.options).user != nil {
+			t.Fatalf("replaying peer auth on %s should not authenticate the connection", test.name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package servers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// ActiveStatus 表示节点正常提供服务。
+	ActiveStatus = "active"
+
+	// DrainingStatus 表示节点正在关闭，等待正在处理的请求完成，一致性哈希不会再把数据分配给它。
+	DrainingStatus = "draining"
+)
+
+// NodeMeta 是节点通过 memberlist 广播给其他节点的元信息。
+type NodeMeta struct {
+
+	// Endpoints 是节点提供服务的地址，key 是协议类型，比如 tcp 和 http。
+	Endpoints map[string]string `json:"endpoints"`
+
+	// Version 是节点运行的 Rcache 版本。
+	Version string `json:"version"`
+
+	// Zone 是节点所在的区域，比如机房或者可用区。
+	Zone string `json:"zone"`
+
+	// Weight 是节点在一致性哈希中的权重，权重越大分配到的数据越多。
+	Weight int `json:"weight"`
+
+	// Status 是节点的状态，可以是 active 或者 draining。
+	Status string `json:"status"`
+}
+
+// NodeInfo 是集群中一个节点的信息。
+type NodeInfo struct {
+
+	// Name 是节点在集群中的名称，也就是主监听器的地址，一致性哈希使用它来选择节点。
+	Name string `json:"name"`
+
+	// Gossip 是节点的 memberlist 使用的地址。
+	Gossip string `json:"gossip"`
+
+	NodeMeta
+}
+
+// nodeDelegate 实现了 memberlist.Delegate，用于在 memberlist 中广播节点的元信息。
+type nodeDelegate struct {
+
+	// meta 是当前节点的元信息。
+	meta NodeMeta
+
+	// data 是序列化好的 meta。
+	data []byte
+
+	// lock 保护 meta 和 data 的并发访问。
+	lock *sync.RWMutex
+}
+
+// newNodeDelegate 返回广播 meta 的 delegate，meta 序列化之后不能超过 memberlist 允许的大小。
+func newNodeDelegate(meta NodeMeta) (*nodeDelegate, error) {
+	nd := &nodeDelegate{lock: &sync.RWMutex{}}
+	if err := nd.setMeta(meta); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// setMeta 修改当前节点的元信息，需要调用 memberlist 的 UpdateNode 才会广播给其他节点。
+func (nd *nodeDelegate) setMeta(meta NodeMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > memberlist.MetaMaxSize {
+		return fmt.Errorf("the meta of node is %d bytes which exceeds the limit %d", len(data), memberlist.MetaMaxSize)
+	}
+
+	nd.lock.Lock()
+	defer nd.lock.Unlock()
+	nd.meta = meta
+	nd.data = data
+	return nil
+}
+
+// currentMeta 返回当前节点的元信息。
+func (nd *nodeDelegate) currentMeta() NodeMeta {
+	nd.lock.RLock()
+	defer nd.lock.RUnlock()
+	return nd.meta
+}
+
+// NodeMeta 返回当前节点的元信息，memberlist 会把它广播给其他节点。
+func (nd *nodeDelegate) NodeMeta(limit int) []byte {
+	nd.lock.RLock()
+	defer nd.lock.RUnlock()
+	return nd.data
+}
+
+// NotifyMsg 处理其他节点发来的用户消息，目前没有用到。
+func (nd *nodeDelegate) NotifyMsg(msg []byte) {}
+
+// GetBroadcasts 返回需要广播的用户消息，目前没有用到。
+func (nd *nodeDelegate) GetBroadcasts(overhead int, limit int) [][]byte {
+	return nil
+}
+
+// LocalState 返回同步给其他节点的本地状态，目前没有用到。
+func (nd *nodeDelegate) LocalState(join bool) []byte {
+	return nil
+}
+
+// MergeRemoteState 合并其他节点同步过来的状态，目前没有用到。
+func (nd *nodeDelegate) MergeRemoteState(buf []byte, join bool) {}
+
+// metaOf 解析其他节点广播的元信息，旧版本的节点没有元信息，这时返回 false。
+// 元信息中没有的权重和状态会使用默认值。
+func metaOf(member *memberlist.Node) (NodeMeta, bool) {
+	meta := NodeMeta{Weight: 1, Status: ActiveStatus}
+	if len(member.Meta) == 0 || json.Unmarshal(member.Meta, &meta) != nil {
+		return NodeMeta{Endpoints: map[string]string{}, Weight: 1, Status: ActiveStatus}, false
+	}
+
+	if meta.Endpoints == nil {
+		meta.Endpoints = map[string]string{}
+	}
+
+	if meta.Weight <= 0 {
+		meta.Weight = 1
+	}
+
+	if meta.Status == "" {
+		meta.Status = ActiveStatus
+	}
+	return meta, true
+}
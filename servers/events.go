@@ -0,0 +1,192 @@
+package servers
+
+import (
+	"Rcache/helpers"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// NodeJoined 是节点加入集群的事件。
+	NodeJoined = "join"
+
+	// NodeLeft 是节点离开集群的事件，包括主动离开和被判定为下线。
+	NodeLeft = "leave"
+
+	// NodeUpdated 是节点的元信息更新的事件，比如节点的状态变成了 draining。
+	NodeUpdated = "update"
+
+	// eventBufferSize 是还没处理的事件最多能有多少个，超过之后事件会被丢弃，然后重新同步一次一致性哈希。
+	eventBufferSize = 1024
+
+	// subscriberBufferSize 是每个订阅者还没接收的事件最多能有多少个，超过之后这个订阅者会丢失事件。
+	subscriberBufferSize = 64
+)
+
+// TopologyEvent 是集群拓扑变化的事件。
+type TopologyEvent struct {
+
+	// Type 是事件的类型，可以是 join、leave 或者 update。
+	Type string `json:"type"`
+
+	// Node 是发生变化的节点的信息。
+	Node NodeInfo `json:"node"`
+
+	// Time 是事件发生的时间。
+	Time time.Time `json:"time"`
+}
+
+// eventDelegate 实现了 memberlist.EventDelegate，把 memberlist 的事件转发给节点处理。
+// memberlist 会在持有锁的时候调用这些方法，所以这里不能阻塞，也不能调用 memberlist 的方法。
+type eventDelegate struct {
+
+	// events 存储着还没处理的事件。
+	events chan TopologyEvent
+
+	// overflowed 在有事件因为 events 满了被丢弃时收到通知，这时需要重新同步一致性哈希。
+	overflowed chan struct{}
+}
+
+// newEventDelegate 返回一个新的 eventDelegate。
+func newEventDelegate() *eventDelegate {
+	return &eventDelegate{
+		events:     make(chan TopologyEvent, eventBufferSize),
+		overflowed: make(chan struct{}, 1),
+	}
+}
+
+// NotifyJoin 在节点加入集群时被调用。
+func (ed *eventDelegate) NotifyJoin(member *memberlist.Node) {
+	ed.notify(NodeJoined, member)
+}
+
+// NotifyLeave 在节点离开集群时被调用。
+func (ed *eventDelegate) NotifyLeave(member *memberlist.Node) {
+	ed.notify(NodeLeft, member)
+}
+
+// NotifyUpdate 在节点的元信息更新时被调用。
+func (ed *eventDelegate) NotifyUpdate(member *memberlist.Node) {
+	ed.notify(NodeUpdated, member)
+}
+
+// notify 把事件放到 events 中，放不下的话就丢弃事件并通知需要重新同步。
+func (ed *eventDelegate) notify(eventType string, member *memberlist.Node) {
+	meta, _ := metaOf(member)
+	event := TopologyEvent{
+		Type: eventType,
+		Node: NodeInfo{Name: member.Name, Gossip: member.Address(), NodeMeta: meta},
+		Time: time.Now(),
+	}
+
+	select {
+	case ed.events <- event:
+	default:
+		select {
+		case ed.overflowed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribers 管理着所有订阅了集群拓扑变化的订阅者。
+type subscribers struct {
+
+	// chans 是每个订阅者接收事件的管道。
+	chans map[chan TopologyEvent]bool
+
+	// lock 保护 chans 的并发访问。
+	lock *sync.Mutex
+}
+
+// newSubscribers 返回一个新的 subscribers。
+func newSubscribers() *subscribers {
+	return &subscribers{
+		chans: make(map[chan TopologyEvent]bool),
+		lock:  &sync.Mutex{},
+	}
+}
+
+// subscribe 添加一个订阅者，返回接收事件的管道和取消订阅的函数。
+func (s *subscribers) subscribe() (<-chan TopologyEvent, func()) {
+	events := make(chan TopologyEvent, subscriberBufferSize)
+	s.lock.Lock()
+	s.chans[events] = true
+	s.lock.Unlock()
+
+	once := &sync.Once{}
+	return events, func() {
+		once.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			if s.chans[events] {
+				delete(s.chans, events)
+				close(events)
+			}
+		})
+	}
+}
+
+// publish 把事件发送给所有的订阅者，订阅者来不及接收的话事件会被丢弃，不会阻塞其他订阅者。
+func (s *subscribers) publish(event TopologyEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for events := range s.chans {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// closeAll 关闭所有订阅者的管道，节点离开集群之后不会再有事件了。
+func (s *subscribers) closeAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for events := range s.chans {
+		delete(s.chans, events)
+		close(events)
+	}
+}
+
+// Subscribe 订阅集群拓扑的变化，返回接收事件的管道和取消订阅的函数。
+// 来不及接收的事件会被丢弃，节点离开集群之后管道会被关闭。
+func (n *node) Subscribe() (<-chan TopologyEvent, func()) {
+	return n.subscribers.subscribe()
+}
+
+// handleEvents 处理 memberlist 的事件：马上更新一致性哈希、记录日志和指标，然后通知订阅者。
+// 这个方法会一直运行到节点离开集群。
+func (n *node) handleEvents(ed *eventDelegate) {
+	defer n.subscribers.closeAll()
+	for {
+		select {
+		case event := <-ed.events:
+			n.updateCircle()
+			n.recordEvent(event)
+			n.subscribers.publish(event)
+		case <-ed.overflowed:
+			helpers.Warnf("Too many membership events, some of them are dropped.")
+			n.updateCircle()
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// recordEvent 记录事件的日志和指标。
+func (n *node) recordEvent(event TopologyEvent) {
+	switch event.Type {
+	case NodeJoined:
+		metrics.Add(joinsMetric, 1)
+		helpers.Infof("Node %s joined the cluster.", event.Node.Name)
+	case NodeLeft:
+		metrics.Add(leavesMetric, 1)
+		helpers.Infof("Node %s left the cluster.", event.Node.Name)
+	case NodeUpdated:
+		metrics.Add(updatesMetric, 1)
+		helpers.Infof("Node %s is updated, status is %s.", event.Node.Name, event.Node.Status)
+	}
+}
@@ -2,13 +2,42 @@ package servers
 
 import (
 	"Rcache/caches"
-	"Rcache/helpers"
+	"bytes"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// signatureHeader 是节点之间广播的内部请求的签名请求头，值是十六进制编码的使用 gossip 密钥计算的签名。
+	signatureHeader = "Rcache-Signature"
+
+	// userHeader 是节点之间广播的内部请求的用户请求头，值是已经在发起广播的节点上认证过的用户名，签名中包括了它。
+	userHeader = "Rcache-User"
+)
+
+// userContextKey 是认证通过的用户在请求的 context 中的 key，广播请求时需要知道是哪个用户。
+type userContextKey struct{}
+
+var (
+	// expireModes 是 Expire-Mode 请求头的取值和过期模式的对应关系。
+	expireModes = map[string]caches.ExpireMode{
+		"absolute":         caches.ExpireAbsolute,
+		"sliding":          caches.ExpireSliding,
+		"sliding-with-max": caches.ExpireSlidingWithMax,
+	}
 )
 
 // HTTPServer 是提供 http 服务的服务器。
@@ -19,9 +48,15 @@ type HTTPServer struct {
 	*node
 	// options 存储着这个服务器的选项配置。
 	options *Options
+
+	// server 是内部真正用于服务的 http 服务器。
+	server *http.Server
+
+	// reloader 是重新加载配置的处理器，没有设置的话重新加载的请求会返回错误。
+	reloader ReloadHandler
 }
 
-//返回一个HTTP实例
+// 返回一个HTTP实例
 func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
 
 	// 创建 node 实例
@@ -29,20 +64,215 @@ func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newHTTPServer(cache, options, n, options.primary()), nil
+}
 
-	return &HTTPServer{
-		node: n,
+// newHTTPServer 返回使用 listener 监听的 http 服务器，同一个节点的多个监听器共享 cache 和 n。
+func newHTTPServer(cache *caches.Cache, options *Options, n *node, listener Listener) *HTTPServer {
+	hs := &HTTPServer{
+		node:    n,
 		cache:   cache,
 		options: options,
-	}, nil
+	}
+	hs.server = &http.Server{
+		Addr:    listener.endpoint(),
+		Handler: hs.routerHandler(),
+	}
+	if n.certificates != nil {
+		hs.server.TLSConfig = n.certificates.serverConfig()
+	}
+	return hs
 }
 
 // Run 启动这个 http 服务器。
 func (hs *HTTPServer) Run() error {
-	return http.ListenAndServe(helpers.JoinAddressAndPort(hs.options.Address, hs.options.Port), hs.routerHandler())
+	var err error
+	if hs.certificates != nil {
+		err = hs.server.ListenAndServeTLS("", "")
+	} else {
+		err = hs.server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 优雅地关闭服务器，先把节点标记为 draining，等待正在处理的请求完成之后离开集群。
+func (hs *HTTPServer) Shutdown(ctx context.Context) error {
+
+	// 先广播正在关闭的状态，让其他节点不再把请求重定向过来，广播失败也没关系，离开集群时其他节点还是会知道
+	hs.drain(leaveTimeout(ctx))
+	err := hs.shutdown(ctx)
+	if leaveErr := hs.leave(leaveTimeout(ctx)); err == nil {
+		err = leaveErr
+	}
+	return err
+}
+
+// shutdown 停止接收新的连接并等待正在处理的请求完成，但是不会离开集群。
+func (hs *HTTPServer) shutdown(ctx context.Context) error {
+	return hs.server.Shutdown(ctx)
 }
 
+// SetReloadHandler 设置重新加载配置的处理器，需要在 Run 之前调用。
+func (hs *HTTPServer) SetReloadHandler(handler ReloadHandler) {
+	hs.reloader = handler
+}
+
+// redirectIfNeeded 使用一致性哈希选择出这个 key 所属的物理节点。
+// 如果这个节点不是当前节点，就响应重定向信息给客户端，告知正确的节点地址，并返回 true。
+func (hs *HTTPServer) redirectIfNeeded(writer http.ResponseWriter, request *http.Request, key string) bool {
+	node, err := hs.selectNode(key)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	if !hs.isCurrentNode(node) {
+		endpoint, err := hs.endpointOf(node, HTTPServerType)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadGateway)
+			writer.Write([]byte("Error: " + err.Error()))
+			return true
+		}
+		writer.Header().Set("Location", endpoint+request.RequestURI)
+		writer.WriteHeader(http.StatusTemporaryRedirect)
+		return true
+	}
+	return false
+}
+
+// namespaceOf 返回请求路径中指定的命名空间，没有指定的话就是默认的命名空间，命名空间不存在的话会创建它，所以只在写入数据时使用。
+// 如果命名空间的名称不合法或者命名空间的个数达到上限，就响应 400 错误码并返回 false。
+func (hs *HTTPServer) namespaceOf(writer http.ResponseWriter, params httprouter.Params) (*caches.Namespace, bool) {
+	namespace, err := hs.cache.Namespace(params.ByName("ns"))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return nil, false
+	}
+	return namespace, true
+}
+
+// lookupNamespace 返回请求路径中指定的已经存在的命名空间，没有指定的话就是默认的命名空间。
+// 这里不会创建命名空间，否则读取不存在的命名空间就能不停地创建出新的命名空间。
+func (hs *HTTPServer) lookupNamespace(params httprouter.Params) (*caches.Namespace, bool) {
+	return hs.cache.LookupNamespace(params.ByName("ns"))
+}
+
+// guard 返回先限流和检查权限再执行 handle 的处理器。
+// 先按照客户端的地址限流，然后检查权限，最后按照用户限流，被限流会响应 429。
+// 请求体超过 MaxBodySize 会响应 413，没有认证或者认证失败会响应 401，没有 permission 权限或者不能访问路径中的命名空间和 key 会响应 403，没有开启认证的话管理请求也会响应 403。
+func (hs *HTTPServer) guard(permission string, handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		size, err := hs.limitBody(writer, request)
+		if err != nil {
+			writer.WriteHeader(http.StatusRequestEntityTooLarge)
+			writer.Write([]byte("Error: " + err.Error()))
+			return
+		}
+
+		if err := hs.clientLimits.allow(clientOf(request), size); err != nil {
+			throttle(writer, err)
+			return
+		}
+
+		if hs.acl == nil {
+			if err := authorizeAnonymous(access{permission: permission}); err != nil {
+				metrics.Add(permissionDeniedMetric, 1)
+				writer.WriteHeader(http.StatusForbidden)
+				writer.Write([]byte("Error: " + err.Error()))
+				return
+			}
+			handle(writer, request, params)
+			return
+		}
 
+		user, err := hs.authenticate(request)
+		if err != nil {
+			metrics.Add(authFailuresMetric, 1)
+			writer.Header().Set("WWW-Authenticate", `Basic realm="Rcache"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			writer.Write([]byte("Error: " + err.Error()))
+			return
+		}
+
+		// 和 TCP 一样，路径中有 key 或者标签的请求都只访问一个命名空间，没有指定的话就是默认的命名空间
+		key := params.ByName("key")
+		a := access{
+			permission: permission,
+			namespace:  params.ByName("ns"),
+			scoped:     key != "" || params.ByName("ns") != "" || params.ByName("tag") != "",
+			key:        key,
+			keyed:      key != "",
+		}
+		if err = user.authorize(a); err != nil {
+			metrics.Add(permissionDeniedMetric, 1)
+			writer.WriteHeader(http.StatusForbidden)
+			writer.Write([]byte("Error: " + err.Error()))
+			return
+		}
+
+		if err = hs.userLimits.allow(user.Name, size); err != nil {
+			throttle(writer, err)
+			return
+		}
+		handle(writer, request.WithContext(context.WithValue(request.Context(), userContextKey{}, user)), params)
+	}
+}
+
+// limitBody 限制请求体最多 MaxBodySize 个字节，并返回请求体的字节数，超过的话返回错误。
+// 分块传输的请求体没有 Content-Length，所以要先读出来再放回去，按照实际读到的字节数限流，否则这些请求就能绕过字节数的限流。
+func (hs *HTTPServer) limitBody(writer http.ResponseWriter, request *http.Request) (int, error) {
+	if max := int64(hs.options.MaxBodySize); max > 0 {
+		if request.ContentLength > max {
+			return 0, fmt.Errorf("request body can't be larger than %d bytes", max)
+		}
+		request.Body = http.MaxBytesReader(writer, request.Body, max)
+	}
+
+	if request.ContentLength >= 0 {
+		return int(request.ContentLength), nil
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return 0, err
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return len(body), nil
+}
+
+// authenticate 认证 http 请求，其他节点广播过来的请求使用节点签名的用户名认证，其他请求使用 Authorization 请求头认证。
+func (hs *HTTPServer) authenticate(request *http.Request) (*User, error) {
+	name := request.Header.Get(userHeader)
+	if name == "" {
+		return hs.acl.authenticateRequest(request)
+	}
+
+	message, signature, err := signedMessage(request)
+	if err != nil {
+		return nil, err
+	}
+	return hs.authenticatePeer(message, name, signature)
+}
+
+// clientOf 返回发送请求的客户端的地址，不带端口，同一个客户端的多个连接共享限流器。
+func clientOf(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// throttle 响应 429 错误码，告诉客户端一秒之后再重试。
+func throttle(writer http.ResponseWriter, err error) {
+	writer.Header().Set("Retry-After", "1")
+	writer.WriteHeader(http.StatusTooManyRequests)
+	writer.Write([]byte("Error: " + err.Error()))
+}
 
 // wrapUriWithVersion 会用 API 版本去包装 uri，比如 "v1" 版本的 API 包装 "/cache" 就会变成 "/v1/cache"。
 func wrapUriWithVersion(uri string) string {
@@ -52,57 +282,169 @@ func wrapUriWithVersion(uri string) string {
 // routerHandler 返回注册的路由处理器。
 func (hs *HTTPServer) routerHandler() http.Handler {
 	router := httprouter.New()
-	router.GET(wrapUriWithVersion("/cache/:key"), hs.getHandler)
-	router.PUT(wrapUriWithVersion("/cache/:key"), hs.setHandler)
-	router.DELETE(wrapUriWithVersion("/cache/:key"), hs.deleteHandler)
-	router.GET(wrapUriWithVersion("/status"), hs.statusHandler)
-	router.GET(wrapUriWithVersion("/nodes"), hs.nodesHandler)
+	router.GET(wrapUriWithVersion("/cache/:key"), hs.guard(ReadPermission, hs.getHandler))
+	router.HEAD(wrapUriWithVersion("/cache/:key"), hs.guard(ReadPermission, hs.getHandler))
+	router.PUT(wrapUriWithVersion("/cache/:key"), hs.guard(WritePermission, hs.setHandler))
+	router.DELETE(wrapUriWithVersion("/cache/:key"), hs.guard(WritePermission, hs.deleteHandler))
+	router.GET(wrapUriWithVersion("/cache/:key/ttl"), hs.guard(ReadPermission, hs.ttlHandler))
+	router.PATCH(wrapUriWithVersion("/cache/:key/ttl"), hs.guard(WritePermission, hs.expireHandler))
+	router.POST(wrapUriWithVersion("/cache/:key/touch"), hs.guard(WritePermission, hs.touchHandler))
+	router.GET(wrapUriWithVersion("/status"), hs.guard(ReadPermission, hs.statusHandler))
+	router.GET(wrapUriWithVersion("/nodes"), hs.guard(ReadPermission, hs.nodesHandler))
+	router.GET(wrapUriWithVersion("/metrics"), hs.guard(ReadPermission, hs.metricsHandler))
+	router.DELETE(wrapUriWithVersion("/tags/:tag"), hs.guard(WritePermission, hs.invalidateTagHandler))
+	router.POST(wrapUriWithVersion("/admin/flush"), hs.guard(AdminPermission, hs.flushHandler))
+	router.POST(wrapUriWithVersion("/admin/reload"), hs.guard(AdminPermission, hs.reloadHandler))
+	router.GET(wrapUriWithVersion("/admin/keys"), hs.guard(AdminPermission, hs.listKeysHandler))
+	router.POST(wrapUriWithVersion("/admin/keys/:action"), hs.guard(AdminPermission, hs.changeKeyHandler))
+
+	// 带命名空间的路由，比如 /v1/ns/team-a/cache/key，处理器和上面的是同一套
+	router.GET(wrapUriWithVersion("/ns/:ns/cache/:key"), hs.guard(ReadPermission, hs.getHandler))
+	router.HEAD(wrapUriWithVersion("/ns/:ns/cache/:key"), hs.guard(ReadPermission, hs.getHandler))
+	router.PUT(wrapUriWithVersion("/ns/:ns/cache/:key"), hs.guard(WritePermission, hs.setHandler))
+	router.DELETE(wrapUriWithVersion("/ns/:ns/cache/:key"), hs.guard(WritePermission, hs.deleteHandler))
+	router.GET(wrapUriWithVersion("/ns/:ns/cache/:key/ttl"), hs.guard(ReadPermission, hs.ttlHandler))
+	router.PATCH(wrapUriWithVersion("/ns/:ns/cache/:key/ttl"), hs.guard(WritePermission, hs.expireHandler))
+	router.POST(wrapUriWithVersion("/ns/:ns/cache/:key/touch"), hs.guard(WritePermission, hs.touchHandler))
+	router.GET(wrapUriWithVersion("/ns/:ns/status"), hs.guard(ReadPermission, hs.statusHandler))
+	router.DELETE(wrapUriWithVersion("/ns/:ns"), hs.guard(AdminPermission, hs.flushNamespaceHandler))
+	router.DELETE(wrapUriWithVersion("/ns/:ns/tags/:tag"), hs.guard(WritePermission, hs.invalidateTagHandler))
 	return router
 }
 
-
-// getHandler 获取缓存中的数据并返回。
+// getHandler 获取缓存中的数据并返回，HEAD 请求只返回响应头，可以用来判断数据是否存在。
+// 支持 If-Match、If-None-Match 和 If-Modified-Since 这几个条件请求头。
 func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
 	key := params.ByName("key")
-	node, err := hs.selectNode(key)
-	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+	if hs.redirectIfNeeded(writer, request, routingKey(params.ByName("ns"), key)) {
 		return
 	}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node + request.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+	// 当前节点处理，命名空间不存在的话就和 key 不存在一样，HEAD 请求不需要数据，所以也不需要解压
+	var value []byte
+	var meta caches.Meta
+	namespace, ok := hs.lookupNamespace(params)
+	if ok && request.Method == http.MethodHead {
+		meta, ok = namespace.Meta(key)
+	} else if ok {
+		value, meta, ok = namespace.GetWithMeta(key)
+	}
+
+	if status := checkPreconditions(request, meta, ok); status != http.StatusOK {
+		if status == http.StatusNotModified {
+			writer.Header().Set("ETag", etagOf(meta.Version))
+			writer.Header().Set("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+		}
+		writer.WriteHeader(status)
 		return
 	}
 
-	// 当前节点处理
-	value, ok := hs.cache.Get(key)
 	if !ok {
 		writer.WriteHeader(http.StatusNotFound)
 		return
 	}
+
+	writeMetaHeaders(writer, meta)
+	if request.Method == http.MethodHead {
+		writer.Header().Set("Content-Length", strconv.Itoa(meta.Size))
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
 	writer.Write(value)
 }
 
+// writeMetaHeaders 将数据的元信息写到响应头中。
+// ETag 是数据的版本号，Last-Modified 是数据最后一次写入的时间，Content-Type 和 Flags 是写入数据时设置的内容类型和标记。
+func writeMetaHeaders(writer http.ResponseWriter, meta caches.Meta) {
+	header := writer.Header()
+	header.Set("ETag", etagOf(meta.Version))
+	header.Set("Last-Modified", meta.UpdatedAt.UTC().Format(http.TimeFormat))
+	if meta.ContentType != "" {
+		header.Set("Content-Type", meta.ContentType)
+	}
+
+	if meta.Flags != 0 {
+		header.Set("Flags", strconv.FormatUint(uint64(meta.Flags), 10))
+	}
+}
+
+// etagOf 返回版本号对应的 ETag，版本号每次写入都会变化，所以可以作为强校验的 ETag。
+func etagOf(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// etagMatches 返回 header 中的 ETag 列表是否包含 version 对应的 ETag，* 可以匹配任何版本。
+// weak 为 true 时使用弱比较，W/ 开头的 ETag 也能匹配上，否则使用强比较。
+func etagMatches(header string, version uint64, weak bool) bool {
+	etag := etagOf(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPreconditions 返回请求是否带有写入时需要检查的条件请求头。
+func hasPreconditions(request *http.Request) bool {
+	return request.Header.Get("If-Match") != "" || request.Header.Get("If-None-Match") != ""
+}
+
+// checkPreconditions 按照 RFC 7232 的顺序检查请求的条件请求头，meta 和 exists 是当前的数据。
+// 条件满足就返回 200，否则返回 304 或者 412，If-Modified-Since 只对 GET 和 HEAD 请求生效。
+func checkPreconditions(request *http.Request, meta caches.Meta, exists bool) int {
+	reading := request.Method == http.MethodGet || request.Method == http.MethodHead
+	if ifMatch := strings.Join(request.Header.Values("If-Match"), ","); ifMatch != "" {
+		if !exists || !etagMatches(ifMatch, meta.Version, false) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := strings.Join(request.Header.Values("If-None-Match"), ","); ifNoneMatch != "" {
+		if exists && etagMatches(ifNoneMatch, meta.Version, true) {
+			if reading {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+		return http.StatusOK
+	}
+
+	// Last-Modified 只精确到秒，所以比较之前要把更新时间也截断到秒
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); reading && exists && ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !meta.UpdatedAt.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+	return http.StatusOK
+}
+
 // setHandler 添加数据到缓存中。
 func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
-	key := params.ByName("key")
-	node, err := hs.selectNode(key)
-	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+	namespace, ok := hs.namespaceOf(writer, params)
+	if !ok {
 		return
 	}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node+request.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, routingKey(namespace.Name(), key)) {
 		return
 	}
 
@@ -113,15 +455,36 @@ func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	// 从请求中获取 ttl
-	ttl, err := ttlOf(request)
+	// 从请求头中获取过期相关的选项配置
+	options, err := setOptionsOf(request)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 带有条件请求头的话，先使用当前的数据检查条件，然后把检查时的版本作为写入条件，
+	// 这样检查之后数据如果被其他请求修改了，写入就会失败，不会覆盖掉别人的修改
+	if hasPreconditions(request) {
+		meta, exists := namespace.Meta(key)
+		if status := checkPreconditions(request, meta, exists); status != http.StatusOK {
+			writer.WriteHeader(status)
+			return
+		}
+
+		if exists {
+			options.IfVersion = meta.Version
+		} else {
+			options.IfAbsent = true
+		}
+	}
+
+	// 添加数据，并设置为指定的 ttl 和过期模式
+	meta, err := namespace.SetWithMeta(key, value, options)
+	if err == caches.PreconditionFailedErr {
+		writer.WriteHeader(http.StatusPreconditionFailed)
 		return
 	}
 
-	// 添加数据，并设置为指定的 ttl
-	err = hs.cache.SetWithTTL(key, value, ttl)
 	if err != nil {
 		// 如果返回了错误，说明触发了写满保护机制，返回 413 错误码，这个错误码表示请求体中的数据太大了
 		// 同时返回错误信息，加上一个 "Error: " 的前缀，方便识别为错误码
@@ -129,63 +492,494 @@ func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Reque
 		writer.Write([]byte("Error: " + err.Error()))
 		return
 	}
+	if meta.Version != 0 {
+		writer.Header().Set("ETag", etagOf(meta.Version))
+	}
+
 	// 成功添加就返回 201 的状态码，其实 200 的状态码也可以，不过 201 的语义更符合，所以就选了这个状态码
 	writer.WriteHeader(http.StatusCreated)
 }
 
 // ttlOf 从请求中解析 ttl 并返回，如果 error 不为空，说明 ttl 解析出错。
-func ttlOf(request *http.Request) (int64, error) {
+func ttlOf(request *http.Request) (time.Duration, error) {
 
 	// 从请求头中获取 ttl 头部，如果没有设置或者 ttl 为空均按不设置 ttl 处理，也就是不会过期
 	ttls, ok := request.Header["Ttl"]
 	if !ok || len(ttls) < 1 {
 		return caches.NeverDie, nil
 	}
-	return strconv.ParseInt(ttls[0], 10, 64)
+	return parseDuration(ttls[0])
+}
+
+// parseDuration 解析请求头中的时间长度。
+// 为了兼容以前的用法，纯数字按秒处理，另外也支持 Go 的时间格式，比如 250ms、1m30s。
+func parseDuration(duration string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(duration, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(duration)
+}
+
+// expireAtOf 从请求中解析过期的时间点，这个值是毫秒级的 Unix 时间戳，类似于 Redis 中的 PXAT。
+// 如果请求中没有设置这个值，就会返回零值。
+func expireAtOf(request *http.Request) (time.Time, error) {
+	expireAt := request.Header.Get("Expire-At")
+	if expireAt == "" {
+		return time.Time{}, nil
+	}
+
+	milliseconds, err := strconv.ParseInt(expireAt, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(milliseconds), nil
+}
+
+// setOptionsOf 从请求头中解析出添加数据时的选项配置。
+// Expire-Mode 请求头可以是 absolute、sliding 或者 sliding-with-max，Max-Lifetime 请求头和 Ttl 的格式一样，Tags 请求头是数据的标签。
+// Content-Type 请求头会作为数据的内容类型保存下来，Flags 请求头是客户端自定义的 32 位标记。
+func setOptionsOf(request *http.Request) (options caches.SetOptions, err error) {
+	options.Ttl, err = ttlOf(request)
+	if err != nil {
+		return options, err
+	}
+
+	options.ExpireAt, err = expireAtOf(request)
+	if err != nil {
+		return options, err
+	}
+
+	if mode := request.Header.Get("Expire-Mode"); mode != "" {
+		var ok bool
+		options.ExpireMode, ok = expireModes[strings.ToLower(mode)]
+		if !ok {
+			return options, fmt.Errorf("unknown expire mode %s", mode)
+		}
+	}
+
+	if maxLifetime := request.Header.Get("Max-Lifetime"); maxLifetime != "" {
+		options.MaxLifetime, err = parseDuration(maxLifetime)
+	}
+
+	if err != nil {
+		return options, err
+	}
+
+	if flags := request.Header.Get("Flags"); flags != "" {
+		number, err := strconv.ParseUint(flags, 10, 32)
+		if err != nil {
+			return options, err
+		}
+		options.Flags = uint32(number)
+	}
+
+	options.ContentType = request.Header.Get("Content-Type")
+	options.Tags = tagsOf(request)
+	return options, nil
+}
+
+// tagsOf 从 Tags 请求头中解析出数据的标签，多个标签使用 "," 分隔，也可以设置多个 Tags 请求头。
+func tagsOf(request *http.Request) []string {
+	var tags []string
+	for _, header := range request.Header.Values("Tags") {
+		for _, tag := range strings.Split(header, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
 }
 
 // deleteHandler 从缓存中删除指定数据。
 func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
 	key := params.ByName("key")
-	node, err := hs.selectNode(key)
+	if hs.redirectIfNeeded(writer, request, routingKey(params.ByName("ns"), key)) {
+		return
+	}
+
+	// 当前节点处理，命名空间不存在的话里面的数据也不存在，和删除不存在的 key 一样
+	namespace, ok := hs.lookupNamespace(params)
+	if !ok {
+		return
+	}
+
+	err := namespace.Delete(key)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node+request.RequestURI)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+// ttlHandler 返回指定数据剩余的寿命，单位是毫秒，0 表示永不过期。
+func (hs *HTTPServer) ttlHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, routingKey(params.ByName("ns"), key)) {
 		return
 	}
 
-	// 当前节点处理
-	err = hs.cache.Delete(key)
+	namespace, ok := hs.lookupNamespace(params)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ttl, found := namespace.TTL(key)
+	if !found {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(map[string]int64{"ttl": ttlMilliseconds(ttl)})
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	writer.Write(body)
+}
+
+// expireHandler 使用 Ttl 请求头重新设置指定数据的寿命，如果没有设置 Ttl 或者 Ttl 为 0，数据就会永不过期。
+func (hs *HTTPServer) expireHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, routingKey(params.ByName("ns"), key)) {
+		return
+	}
+
+	namespace, ok := hs.lookupNamespace(params)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var found bool
+	if ttl == caches.NeverDie {
+		found = namespace.Persist(key)
+	} else {
+		found = namespace.Expire(key, ttl)
+	}
+
+	if !found {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// touchHandler 更新指定数据的访问时间，滑动过期的数据会因此延长寿命。
+func (hs *HTTPServer) touchHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, routingKey(params.ByName("ns"), key)) {
+		return
+	}
+
+	namespace, ok := hs.lookupNamespace(params)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !namespace.Touch(key) {
+		writer.WriteHeader(http.StatusNotFound)
+	}
 }
 
-// statusHandler 返回缓存信息。
+// statusHandler 返回缓存信息，如果路径中指定了命名空间，就只返回这个命名空间的信息。
 func (hs *HTTPServer) statusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	status, err := json.Marshal(hs.cache.Status())
+	status := hs.cache.Status()
+	if params.ByName("ns") != "" {
+		namespace, ok := hs.lookupNamespace(params)
+		if !ok {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		status = namespace.Status()
+	}
+
+	body, err := json.Marshal(status)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	writer.Write(status)
+	writer.Write(body)
+}
+
+// flushNamespaceHandler 清空当前节点中指定命名空间的数据。
+func (hs *HTTPServer) flushNamespaceHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	// 命名空间不存在的话就没有需要清空的数据
+	if namespace, ok := hs.lookupNamespace(params); ok {
+		namespace.Flush()
+	}
 }
 
 // nodesHandler is handler for fetching the nodes of cluster.
 func (hs *HTTPServer) nodesHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	nodes, err := json.Marshal(hs.nodes())
+	nodes, err := json.Marshal(hs.nodeInfos())
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	writer.Write(nodes)
-}
\ No newline at end of file
+}
+
+// invalidateTagHandler 删除带有指定标签的所有数据，返回删除的数据个数。
+// 除非请求参数中指定了 scope=local，否则请求会广播到集群中其他所有节点。
+func (hs *HTTPServer) invalidateTagHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	local, ok := hs.checkScope(writer, request)
+	if !ok {
+		return
+	}
+
+	// 当前节点没有这个命名空间的话，其他节点上可能有，所以还是要广播
+	invalidated := int64(0)
+	if namespace, ok := hs.lookupNamespace(params); ok {
+		invalidated = int64(namespace.InvalidateTag(params.ByName("tag")))
+	}
+	if !local {
+		err := hs.broadcast(request, nil, func(body []byte) error {
+			var result map[string]int64
+			if err := json.Unmarshal(body, &result); err != nil {
+				return err
+			}
+			atomic.AddInt64(&invalidated, result["invalidated"])
+			return nil
+		})
+
+		if err != nil {
+			writer.WriteHeader(http.StatusBadGateway)
+			writer.Write([]byte("Error: " + err.Error()))
+			return
+		}
+	}
+
+	body, err := json.Marshal(map[string]int64{"invalidated": invalidated})
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Write(body)
+}
+
+// flushHandler 清空整个缓存，除非请求参数中指定了 scope=local，否则请求会广播到集群中其他所有节点。
+func (hs *HTTPServer) flushHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	local, ok := hs.checkScope(writer, request)
+	if !ok {
+		return
+	}
+
+	hs.cache.Flush()
+	if local {
+		return
+	}
+
+	err := hs.broadcast(request, nil, func(body []byte) error {
+		return nil
+	})
+
+	if err != nil {
+		writer.WriteHeader(http.StatusBadGateway)
+		writer.Write([]byte("Error: " + err.Error()))
+	}
+}
+
+// listKeysHandler 返回 json 格式的当前节点的 gossip 密钥的指纹。
+func (hs *HTTPServer) listKeysHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	keys, err := hs.keys()
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	body, err := json.Marshal(keys)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Write(body)
+}
+
+// changeKeyHandler 对 gossip 密钥执行路径中的操作，可以是 install、use 或者 remove，请求体是 base64 编码的密钥。
+// 除非请求参数中指定了 scope=local，否则请求会广播到集群中其他所有节点。
+func (hs *HTTPServer) changeKeyHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	local, ok := hs.checkScope(writer, request)
+	if !ok {
+		return
+	}
+
+	key, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err = hs.changeKey(params.ByName("action"), strings.TrimSpace(string(key))); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	if local {
+		return
+	}
+
+	err = hs.broadcast(request, key, func(body []byte) error {
+		return nil
+	})
+
+	if err != nil {
+		writer.WriteHeader(http.StatusBadGateway)
+		writer.Write([]byte("Error: " + err.Error()))
+	}
+}
+
+// checkScope 返回请求参数中是否指定了 scope=local，指定了的话说明这是其他节点广播过来的内部请求，还会检查请求的签名。
+// 检查不通过的话会响应 403 并返回 false。
+func (hs *HTTPServer) checkScope(writer http.ResponseWriter, request *http.Request) (local bool, ok bool) {
+	if request.URL.Query().Get("scope") != localScope {
+		return false, true
+	}
+
+	message, signature, err := signedMessage(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return true, false
+	}
+
+	if err = hs.verify(message, signature); err != nil {
+		writer.WriteHeader(http.StatusForbidden)
+		writer.Write([]byte("Error: " + err.Error()))
+		return true, false
+	}
+	return true, true
+}
+
+// signedMessage 返回计算 request 的签名时使用的内容和请求中的签名。
+// 签名包括了请求体，所以要先读出来，再放回去给处理器使用。
+func signedMessage(request *http.Request) (message []byte, signature []byte, err error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signature, _ = hex.DecodeString(request.Header.Get(signatureHeader))
+	return requestMessage(request.Method, request.URL.Path, request.URL.Query(), request.Header.Get(userHeader), body), signature, nil
+}
+
+// requestMessage 返回计算内部请求签名时使用的内容，包括请求方法、路径、请求参数、转发的用户名和请求体。
+func requestMessage(method string, path string, query url.Values, user string, body []byte) []byte {
+	message := []byte(method + "\n" + path + "\n" + query.Encode() + "\n" + user + "\n")
+	return append(message, body...)
+}
+
+// metricsHandler 返回 json 格式的服务器指标。
+func (hs *HTTPServer) metricsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	writer.Write(metricsJSON())
+}
+
+// reloadHandler 让当前节点重新加载配置，响应体是 json 格式的需要重启才能生效的配置名称。
+func (hs *HTTPServer) reloadHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if hs.reloader == nil {
+		writer.WriteHeader(http.StatusNotImplemented)
+		writer.Write([]byte("Error: " + reloadNotSupportedErr.Error()))
+		return
+	}
+
+	ignored, err := hs.reloader()
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	body, err := json.Marshal(ignoredOrEmpty(ignored))
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Write(body)
+}
+
+// broadcast 把 request 加上 scope=local 参数之后并发地转发给集群中其他所有节点，并使用 fn 处理每个节点的响应体。
+// 开启了 gossip 加密的话会带上请求的签名，开启了认证的话还会带上签名过的用户名，用户的密码和令牌不会发送给其他节点。只要有一个节点失败就返回错误。
+func (hs *HTTPServer) broadcast(request *http.Request, requestBody []byte, fn func(body []byte) error) error {
+	query := request.URL.Query()
+	query.Set("scope", localScope)
+
+	header := http.Header{}
+	name := ""
+	if user, ok := request.Context().Value(userContextKey{}).(*User); ok {
+		name = user.Name
+		header.Set(userHeader, name)
+	}
+
+	signature := hs.signature(requestMessage(request.Method, request.URL.Path, query, name, requestBody))
+	if signature == nil && name != "" {
+		return peerAuthNeedsKeysErr
+	}
+
+	if signature != nil {
+		header.Set(signatureHeader, hex.EncodeToString(signature))
+	}
+
+	nodes := hs.otherNodes()
+	errs := make([]error, len(nodes))
+	wg := &sync.WaitGroup{}
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			endpoint, err := hs.endpointOf(node, HTTPServerType)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = forward(hs.httpClient, request.Method, hs.scheme()+"://"+endpoint+request.URL.Path+"?"+query.Encode(), header, requestBody, fn)
+		}(i, node)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to broadcast to node %s: %w", nodes[i], err)
+		}
+	}
+	return nil
+}
+
+// forward 使用 client 向 url 发送一个请求头为 header、请求体为 requestBody 的请求，并使用 fn 处理响应体。
+func forward(client *http.Client, method string, url string, header http.Header, requestBody []byte, fn func(body []byte) error) error {
+	request, err := http.NewRequest(method, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return err
+	}
+	request.Header = header.Clone()
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d: %s", response.StatusCode, body)
+	}
+	return fn(body)
+}
@@ -0,0 +1,127 @@
+package servers
+
+import (
+	"Rcache/caches"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// go test -v -run=^TestETagMatches$
+func TestETagMatches(t *testing.T) {
+
+	tests := []struct {
+		header  string
+		weak    bool
+		matches bool
+	}{
+		{header: `"7"`, matches: true},
+		{header: `"8"`, matches: false},
+		{header: `"8", "7"`, matches: true},
+		{header: `*`, matches: true},
+		{header: `W/"7"`, weak: false, matches: false},
+		{header: `W/"7"`, weak: true, matches: true},
+		{header: `7`, matches: false},
+	}
+
+	for _, test := range tests {
+		if matches := etagMatches(test.header, 7, test.weak); matches != test.matches {
+			t.Fatalf("%s with weak %v should match %v", test.header, test.weak, test.matches)
+		}
+	}
+}
+
+// go test -v -run=^TestCheckPreconditions$
+func TestCheckPreconditions(t *testing.T) {
+
+	updatedAt := time.Date(2022, 1, 1, 0, 0, 0, 500, time.UTC)
+	meta := caches.Meta{Version: 7, UpdatedAt: updatedAt}
+
+	// 读请求的 If-None-Match 和 If-Modified-Since 命中时返回 304，写请求的条件不满足时返回 412
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		exists bool
+		status int
+	}{
+		{name: "no preconditions", method: http.MethodGet, exists: true, status: http.StatusOK},
+		{name: "if-match", method: http.MethodPut, header: "If-Match", value: `"7"`, exists: true, status: http.StatusOK},
+		{name: "if-match changed", method: http.MethodPut, header: "If-Match", value: `"6"`, exists: true, status: http.StatusPreconditionFailed},
+		{name: "if-match weak", method: http.MethodPut, header: "If-Match", value: `W/"7"`, exists: true, status: http.StatusPreconditionFailed},
+		{name: "if-match missing", method: http.MethodPut, header: "If-Match", value: `*`, exists: false, status: http.StatusPreconditionFailed},
+		{name: "if-none-match read", method: http.MethodGet, header: "If-None-Match", value: `W/"7"`, exists: true, status: http.StatusNotModified},
+		{name: "if-none-match head", method: http.MethodHead, header: "If-None-Match", value: `"7"`, exists: true, status: http.StatusNotModified},
+		{name: "if-none-match changed", method: http.MethodGet, header: "If-None-Match", value: `"6"`, exists: true, status: http.StatusOK},
+		{name: "if-none-match write", method: http.MethodPut, header: "If-None-Match", value: `*`, exists: true, status: http.StatusPreconditionFailed},
+		{name: "if-none-match create", method: http.MethodPut, header: "If-None-Match", value: `*`, exists: false, status: http.StatusOK},
+		{name: "if-modified-since", method: http.MethodGet, header: "If-Modified-Since", value: updatedAt.Format(http.TimeFormat), exists: true, status: http.StatusNotModified},
+		{name: "if-modified-since modified", method: http.MethodGet, header: "If-Modified-Since", value: updatedAt.Add(-time.Second).Format(http.TimeFormat), exists: true, status: http.StatusOK},
+		{name: "if-modified-since write", method: http.MethodPut, header: "If-Modified-Since", value: updatedAt.Format(http.TimeFormat), exists: true, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/v1/cache/key", nil)
+		if test.header != "" {
+			request.Header.Set(test.header, test.value)
+		}
+
+		if status := checkPreconditions(request, meta, test.exists); status != test.status {
+			t.Fatalf("%s should return %d but got %d", test.name, test.status, status)
+		}
+	}
+}
+
+// go test -v -run=^TestHTTPServerLimitBody$
+func TestHTTPServerLimitBody(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 分块传输的请求体没有 Content-Length，也要按照实际读到的字节数限流
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "small", body: "1", status: http.StatusOK},
+		{name: "too large", body: "1234567890123", status: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", body: "1234567890123", chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked", body: "12345678", chunked: true, status: http.StatusOK},
+		{name: "chunked throttled", body: "12", chunked: true, status: http.StatusTooManyRequests},
+	}
+
+	hs := &HTTPServer{node: &node{clientLimits: newLimitsGroup(ctx, 0, 10)}, options: &Options{MaxBodySize: 12}}
+	handle := hs.guard(ReadPermission, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		if _, err := ioutil.ReadAll(request.Body); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	for _, test := range tests {
+		var body io.Reader = strings.NewReader(test.body)
+		if test.chunked {
+			body = ioutil.NopCloser(body)
+		}
+
+		request := httptest.NewRequest(http.MethodPut, "/v1/cache/key", body)
+		if test.chunked {
+			request.ContentLength = -1
+		}
+
+		recorder := httptest.NewRecorder()
+		handle(recorder, request, nil)
+		if recorder.Code != test.status {
+			t.Fatalf("%s should return %d but got %d", test.name, test.status, recorder.Code)
+		}
+	}
+}
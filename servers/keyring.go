@@ -0,0 +1,175 @@
+package servers
+
+import (
+	"Rcache/helpers"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+const (
+	// listKeysAction 是列出所有 gossip 密钥的指纹的操作。
+	listKeysAction = "list"
+
+	// installKeyAction 是添加一个 gossip 密钥的操作，添加的密钥可以用于解密，但还不会用于加密。
+	installKeyAction = "install"
+
+	// useKeyAction 是把一个已经添加的 gossip 密钥设置为加密使用的密钥的操作。
+	useKeyAction = "use"
+
+	// removeKeyAction 是删除一个 gossip 密钥的操作，不能删除加密使用的密钥。
+	removeKeyAction = "remove"
+
+	// signatureTimeout 是内部请求的签名的有效期，节点之间的时钟误差不能超过这个时间。
+	signatureTimeout = 30 * time.Second
+)
+
+var (
+	// encryptionDisabledErr 是没有开启 gossip 加密的错误。
+	encryptionDisabledErr = errors.New("gossip encryption is not enabled")
+
+	// unknownKeyActionErr 是不认识的密钥操作的错误。
+	unknownKeyActionErr = errors.New("unknown key action")
+
+	// notMemberErr 是只在当前节点执行的内部请求没有签名或者签名不正确的错误，说明请求不是集群中的节点发来的。
+	notMemberErr = errors.New("the request is not from a member of cluster")
+)
+
+// Keys 是节点的 gossip 密钥的指纹，密钥本身不会通过接口返回，指纹可以用来确认轮换密钥的进度。
+type Keys struct {
+
+	// Primary 是加密使用的密钥的指纹。
+	Primary string `json:"primary"`
+
+	// Keys 是所有可以用于解密的密钥的指纹，包括 Primary。
+	Keys []string `json:"keys"`
+}
+
+// fingerprintOf 返回 gossip 密钥的指纹，也就是 SHA-256 摘要前 8 个字节的十六进制编码。
+func fingerprintOf(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// decodeKeys 解码 base64 编码的 gossip 密钥，并检查密钥的长度。
+func decodeKeys(keys []string) ([][]byte, error) {
+	result := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		decoded, err := decodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decoded)
+	}
+	return result, nil
+}
+
+// decodeKey 解码 base64 编码的 gossip 密钥，并检查密钥的长度。
+func decodeKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gossip key: %w", err)
+	}
+
+	if err = memberlist.ValidateKey(decoded); err != nil {
+		return nil, fmt.Errorf("invalid gossip key: %w", err)
+	}
+	return decoded, nil
+}
+
+// newKeyring 使用 keys 创建 memberlist 的密钥环，第一个密钥用于加密，没有密钥的话返回 nil，表示不开启加密。
+func newKeyring(keys []string) (*memberlist.Keyring, error) {
+	decoded, err := decodeKeys(keys)
+	if err != nil || len(decoded) == 0 {
+		return nil, err
+	}
+	return memberlist.NewKeyring(decoded, decoded[0])
+}
+
+// keys 返回当前节点的 gossip 密钥的指纹。
+func (n *node) keys() (Keys, error) {
+	if n.keyring == nil {
+		return Keys{}, encryptionDisabledErr
+	}
+
+	result := Keys{Primary: fingerprintOf(n.keyring.GetPrimaryKey())}
+	for _, key := range n.keyring.GetKeys() {
+		result.Keys = append(result.Keys, fingerprintOf(key))
+	}
+	return result, nil
+}
+
+// changeKey 对当前节点的 gossip 密钥执行 action 操作，可以是 install、use 或者 remove。
+// 轮换密钥时需要先在所有节点上 install 新的密钥，然后再 use 新的密钥，最后 remove 旧的密钥，否则节点之间会无法通信。
+func (n *node) changeKey(action string, key string) error {
+	if n.keyring == nil {
+		return encryptionDisabledErr
+	}
+
+	decoded, err := decodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case installKeyAction:
+		return n.keyring.AddKey(decoded)
+	case useKeyAction:
+		return n.keyring.UseKey(decoded)
+	case removeKeyAction:
+		return n.keyring.RemoveKey(decoded)
+	default:
+		return unknownKeyActionErr
+	}
+}
+
+// sign 使用 key 计算 message 在 timestamp 这个时间的 HMAC-SHA256 签名，timestamp 是毫秒级的 Unix 时间戳。
+func sign(key []byte, timestamp int64, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(helpers.Uint64ToBytes(uint64(timestamp)))
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// signature 返回内部请求 message 的签名，前 8 个字节是大端存储的毫秒级时间戳，后面是使用加密的 gossip 密钥计算的 HMAC-SHA256 签名。
+// 没有开启 gossip 加密的话返回 nil，表示不需要签名。
+func (n *node) signature(message []byte) []byte {
+	if n.keyring == nil {
+		return nil
+	}
+
+	timestamp := time.Now().UnixMilli()
+	return append(helpers.Uint64ToBytes(uint64(timestamp)), sign(n.keyring.GetPrimaryKey(), timestamp, message)...)
+}
+
+// verify 检查内部请求 message 的签名是不是集群中的节点使用 gossip 密钥计算的，只有开启了 gossip 加密才会检查。
+// 只有集群中的节点才有 gossip 密钥，所以只在当前节点执行的内部请求只接受它们发来的，避免集群外的机器冒充节点。
+// 签名可以使用任何一个 gossip 密钥计算，这样轮换密钥的过程中节点之间也能正常通信，超过 signatureTimeout 的签名会被拒绝，避免截获的请求一直可以重放。
+func (n *node) verify(message []byte, signature []byte) error {
+	if n.keyring == nil {
+		return nil
+	}
+
+	if len(signature) != 8+sha256.Size {
+		return notMemberErr
+	}
+
+	timestamp := int64(binary.BigEndian.Uint64(signature))
+	if elapsed := time.Since(time.UnixMilli(timestamp)); elapsed > signatureTimeout || elapsed < -signatureTimeout {
+		return notMemberErr
+	}
+
+	for _, key := range n.keyring.GetKeys() {
+		if hmac.Equal(sign(key, timestamp, message), signature[8:]) {
+			return nil
+		}
+	}
+	return notMemberErr
+}
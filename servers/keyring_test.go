@@ -0,0 +1,91 @@
+package servers
+
+import (
+	"Rcache/helpers"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// go test -v -run=^TestNodeVerify$
+func TestNodeVerify(t *testing.T) {
+
+	primary := bytes.Repeat([]byte{1}, 16)
+	secondary := bytes.Repeat([]byte{2}, 16)
+	outsider := bytes.Repeat([]byte{3}, 16)
+	keyring, err := memberlist.NewKeyring([][]byte{primary, secondary}, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &node{keyring: keyring}
+	message := commandMessage(flushCommand, [][]byte{[]byte(scopeOption), []byte(localScope)})
+	signatureOf := func(key []byte, timestamp time.Time) []byte {
+		return append(helpers.Uint64ToBytes(uint64(timestamp.UnixMilli())), sign(key, timestamp.UnixMilli(), message)...)
+	}
+
+	// 轮换密钥的过程中使用任何一个密钥签名都可以，集群外的密钥、篡改过的内容和过期的签名都不行
+	tests := []struct {
+		name      string
+		message   []byte
+		signature []byte
+		valid     bool
+	}{
+		{name: "signed", message: message, signature: n.signature(message), valid: true},
+		{name: "secondary key", message: message, signature: signatureOf(secondary, time.Now()), valid: true},
+		{name: "outsider key", message: message, signature: signatureOf(outsider, time.Now()), valid: false},
+		{name: "tampered", message: commandMessage(flushCommand, nil), signature: n.signature(message), valid: false},
+		{name: "expired", message: message, signature: signatureOf(primary, time.Now().Add(-time.Minute)), valid: false},
+		{name: "future", message: message, signature: signatureOf(primary, time.Now().Add(time.Minute)), valid: false},
+		{name: "missing", message: message, signature: nil, valid: false},
+		{name: "truncated", message: message, signature: n.signature(message)[:16], valid: false},
+	}
+
+	for _, test := range tests {
+		if err := n.verify(test.message, test.signature); (err == nil) != test.valid {
+			t.Fatalf("signature %s should be valid %v but got %v", test.name, test.valid, err)
+		}
+	}
+
+	// 没有开启 gossip 加密的话不需要签名
+	unencrypted := &node{}
+	if unencrypted.signature(message) != nil || unencrypted.verify(message, nil) != nil {
+		t.Fatal("requests should not be signed or verified without gossip encryption")
+	}
+}
+
+// go test -v -run=^TestTCPServerCheckScope$
+func TestTCPServerCheckScope(t *testing.T) {
+
+	keyring, err := memberlist.NewKeyring(nil, bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &TCPServer{node: &node{keyring: keyring}}
+	signed := &TCPClient{sign: ts.signature}
+	unsigned := &TCPClient{}
+	tag := [][]byte{[]byte("tag")}
+
+	// 广播的内部命令带着签名，客户端自己加上 SCOPE local 是不行的
+	tests := []struct {
+		name  string
+		args  [][]byte
+		local bool
+		valid bool
+	}{
+		{name: "broadcast", args: signed.scoped(invalidateTagCommand, tag, localScope), local: true, valid: true},
+		{name: "unsigned", args: unsigned.scoped(invalidateTagCommand, tag, localScope), local: true, valid: false},
+		{name: "cluster", args: signed.scoped(invalidateTagCommand, tag, ""), local: false, valid: true},
+		{name: "replaced tag", args: append([][]byte{[]byte("other")}, signed.scoped(invalidateTagCommand, tag, localScope)[1:]...), local: true, valid: false},
+	}
+
+	for _, test := range tests {
+		local, err := ts.checkScope(invalidateTagCommand, test.args, test.args[1:])
+		if local != test.local || (err == nil) != test.valid {
+			t.Fatalf("command %s should be local %v and valid %v but got %v, %v", test.name, test.local, test.valid, local, err)
+		}
+	}
+}
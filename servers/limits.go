@@ -0,0 +1,235 @@
+package servers
+
+import (
+	"Rcache/vex"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// idleLimitsTimeout 是按用户或者客户端地址记录的令牌桶多久没有使用就会被删除，删除之后再使用时令牌桶是满的，所以不影响限流。
+	idleLimitsTimeout = time.Minute
+)
+
+var (
+	// commandRateErr 是执行命令太频繁的错误。
+	commandRateErr = fmt.Errorf("%w: too many commands", vex.ThrottledErr)
+
+	// byteRateErr 是发送的数据太多的错误。
+	byteRateErr = fmt.Errorf("%w: too many bytes", vex.ThrottledErr)
+)
+
+// tokenBucket 是令牌桶，令牌以固定的速度放进桶里，桶里最多放一秒钟的令牌，每次请求都需要拿走令牌。
+type tokenBucket struct {
+
+	// rate 是每秒放进桶里的令牌数，也是桶的容量。
+	rate float64
+
+	// tokens 是桶里现在的令牌数，可以是负数，表示比较大的请求预支了之后的令牌。
+	tokens float64
+
+	// last 是上一次计算令牌数的时间。
+	last time.Time
+}
+
+// newTokenBucket 返回每秒放进 rate 个令牌的令牌桶，rate 不是正数的话返回 nil，表示不限流。
+func newTokenBucket(rate int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// refill 按照从上一次计算到 now 经过的时间往桶里放令牌，桶里最多放 rate 个令牌。
+func (tb *tokenBucket) refill(now time.Time) {
+	if tb == nil {
+		return
+	}
+
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+}
+
+// enough 返回桶里的令牌够不够拿走 n 个。
+// n 比桶的容量还大的话，只要桶是满的就允许，然后预支之后的令牌，否则这个请求永远都不能执行。
+func (tb *tokenBucket) enough(n int) bool {
+	if tb == nil {
+		return true
+	}
+
+	need := float64(n)
+	if need > tb.rate {
+		need = tb.rate
+	}
+	return tb.tokens >= need
+}
+
+// consume 从桶里拿走 n 个令牌，调用之前需要使用 enough 检查令牌够不够。
+func (tb *tokenBucket) consume(n int) {
+	if tb != nil {
+		tb.tokens -= float64(n)
+	}
+}
+
+// take 从桶里拿走 n 个令牌，令牌不够的话返回 false。
+func (tb *tokenBucket) take(n int, now time.Time) bool {
+	tb.refill(now)
+	if !tb.enough(n) {
+		return false
+	}
+	tb.consume(n)
+	return true
+}
+
+// limits 是一个连接、客户端或者用户的限流器，分别限制每秒执行的命令数和发送的字节数。
+type limits struct {
+
+	// commands 是限制命令数的令牌桶。
+	commands *tokenBucket
+
+	// bytes 是限制字节数的令牌桶。
+	bytes *tokenBucket
+
+	// lock 保护令牌桶的并发访问。
+	lock *sync.Mutex
+}
+
+// newLimits 返回每秒最多执行 commandRate 个命令、发送 byteRate 个字节的限流器，两个都不是正数的话返回 nil，表示不限流。
+func newLimits(commandRate int, byteRate int) *limits {
+	if commandRate <= 0 && byteRate <= 0 {
+		return nil
+	}
+	return &limits{
+		commands: newTokenBucket(commandRate),
+		bytes:    newTokenBucket(byteRate),
+		lock:     &sync.Mutex{},
+	}
+}
+
+// allow 检查能不能执行一个发送了 size 个字节的命令，不能的话返回被限流的错误，并记录到指标中，被拒绝的命令不会拿走任何令牌。
+func (l *limits) allow(size int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// 两个令牌桶都检查通过之后才拿走令牌，否则被字节数限流的请求也会白白用掉命令数的令牌
+	now := time.Now()
+	l.commands.refill(now)
+	l.bytes.refill(now)
+	if !l.commands.enough(1) {
+		metrics.Add(throttledCommandsMetric, 1)
+		return commandRateErr
+	}
+
+	if !l.bytes.enough(size) {
+		metrics.Add(throttledBytesMetric, 1)
+		return byteRateErr
+	}
+
+	l.commands.consume(1)
+	l.bytes.consume(size)
+	return nil
+}
+
+// idleSince 返回限流器从 since 开始有没有被使用过。
+func (l *limits) idleSince(since time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, bucket := range []*tokenBucket{l.commands, l.bytes} {
+		if bucket != nil && bucket.last.After(since) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitsGroup 按照名称记录着多个限流器，比如每个用户或者每个客户端地址一个。
+type limitsGroup struct {
+
+	// commandRate 是每个限流器每秒最多执行的命令数。
+	commandRate int
+
+	// byteRate 是每个限流器每秒最多发送的字节数。
+	byteRate int
+
+	// limits 是每个名称对应的限流器。
+	limits map[string]*limits
+
+	// lock 保护 limits 的并发访问。
+	lock *sync.Mutex
+}
+
+// newLimitsGroup 返回一个限流器组，两个速度都不是正数的话返回 nil，表示不限流。
+// 一段时间没有使用的限流器会被删除，直到 ctx 被取消。
+func newLimitsGroup(ctx context.Context, commandRate int, byteRate int) *limitsGroup {
+	if commandRate <= 0 && byteRate <= 0 {
+		return nil
+	}
+
+	lg := &limitsGroup{
+		commandRate: commandRate,
+		byteRate:    byteRate,
+		limits:      make(map[string]*limits),
+		lock:        &sync.Mutex{},
+	}
+	go lg.removeIdle(ctx)
+	return lg
+}
+
+// allow 检查名为 name 的限流器能不能执行一个发送了 size 个字节的命令。
+func (lg *limitsGroup) allow(name string, size int) error {
+	if lg == nil {
+		return nil
+	}
+
+	lg.lock.Lock()
+	l, ok := lg.limits[name]
+	if !ok {
+		l = newLimits(lg.commandRate, lg.byteRate)
+		lg.limits[name] = l
+	}
+	lg.lock.Unlock()
+	return l.allow(size)
+}
+
+// removeIdle 定时删除一段时间没有使用的限流器，避免客户端地址太多时占用太多内存。
+func (lg *limitsGroup) removeIdle(ctx context.Context) {
+	ticker := time.NewTicker(idleLimitsTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			since := time.Now().Add(-idleLimitsTimeout)
+			lg.lock.Lock()
+			for name, l := range lg.limits {
+				if l.idleSince(since) {
+					delete(lg.limits, name)
+				}
+			}
+			lg.lock.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// requestSize 返回命令参数的字节数。
+func requestSize(args [][]byte) int {
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	return size
+}
@@ -0,0 +1,68 @@
+package servers
+
+import (
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestTokenBucketTake$
+func TestTokenBucketTake(t *testing.T) {
+
+	// 每一步都在上一步的基础上经过 elapsed 再拿走 n 个令牌，桶里最多放一秒钟的令牌，大请求可以预支
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		n       int
+		allowed bool
+	}{
+		{name: "full bucket", elapsed: 0, n: 6, allowed: true},
+		{name: "remaining tokens", elapsed: 0, n: 4, allowed: true},
+		{name: "empty bucket", elapsed: 0, n: 1, allowed: false},
+		{name: "refilled", elapsed: 100 * time.Millisecond, n: 1, allowed: true},
+		{name: "not enough", elapsed: 100 * time.Millisecond, n: 2, allowed: false},
+		{name: "capped at rate", elapsed: time.Hour, n: 11, allowed: true},
+		{name: "borrowed", elapsed: 50 * time.Millisecond, n: 1, allowed: false},
+		{name: "paid back", elapsed: 200 * time.Millisecond, n: 1, allowed: true},
+	}
+
+	bucket := newTokenBucket(10)
+	now := bucket.last
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if allowed := bucket.take(test.n, now); allowed != test.allowed {
+			t.Fatalf("%s: taking %d tokens should be allowed %v but got %v", test.name, test.n, test.allowed, allowed)
+		}
+	}
+
+	// 速度不是正数的话不限流
+	unlimited := newTokenBucket(0)
+	if !unlimited.take(1<<30, time.Now()) {
+		t.Fatal("nil token bucket should not limit anything")
+	}
+}
+
+// go test -v -run=^TestLimitsAllow$
+func TestLimitsAllow(t *testing.T) {
+
+	// 被字节数限流的命令不能拿走命令数的令牌，否则之后的小请求也会被限流
+	l := newLimits(2, 10)
+	tests := []struct {
+		name string
+		size int
+		err  error
+	}{
+		{name: "borrowed bytes", size: 20, err: nil},
+		{name: "too many bytes", size: 1, err: byteRateErr},
+		{name: "still too many bytes", size: 1, err: byteRateErr},
+	}
+
+	for _, test := range tests {
+		if err := l.allow(test.size); err != test.err {
+			t.Fatalf("%s: sending %d bytes should return %v but got %v", test.name, test.size, test.err, err)
+		}
+	}
+
+	if l.commands.tokens < 1 {
+		t.Fatalf("rejected commands should not take command tokens but %f tokens left", l.commands.tokens)
+	}
+}
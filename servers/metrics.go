@@ -0,0 +1,52 @@
+package servers
+
+import (
+	"expvar"
+)
+
+const (
+	// membersMetric 是集群中节点个数的指标。
+	membersMetric = "membership.members"
+
+	// joinsMetric 是节点加入集群的次数的指标。
+	joinsMetric = "membership.joins"
+
+	// leavesMetric 是节点离开集群的次数的指标。
+	leavesMetric = "membership.leaves"
+
+	// updatesMetric 是节点元信息更新的次数的指标。
+	updatesMetric = "membership.updates"
+
+	// authFailuresMetric 是认证失败的次数的指标。
+	authFailuresMetric = "auth.failures"
+
+	// permissionDeniedMetric 是用户没有权限执行请求的次数的指标。
+	permissionDeniedMetric = "auth.denied"
+
+	// throttledCommandsMetric 是因为执行命令太频繁被限流的次数的指标。
+	throttledCommandsMetric = "throttle.commands"
+
+	// throttledBytesMetric 是因为发送的数据太多被限流的次数的指标。
+	throttledBytesMetric = "throttle.bytes"
+
+	// rejectedConnectionsMetric 是因为连接数太多被拒绝的连接数的指标。
+	rejectedConnectionsMetric = "throttle.connections"
+)
+
+var (
+	// metrics 记录着服务器的各种指标，HTTP 使用 /v1/metrics 获取，TCP 使用 metrics 命令获取。
+	// 它同时也会注册到 expvar 中，名称是 rcache。
+	metrics = expvar.NewMap("rcache")
+
+	// members 是集群中节点的个数。
+	members = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set(membersMetric, members)
+}
+
+// metricsJSON 返回 json 格式的所有指标。
+func metricsJSON() []byte {
+	return []byte(metrics.String())
+}
@@ -2,12 +2,33 @@ package servers
 
 import (
 	"Rcache/helpers"
+	"context"
+	"fmt"
 	"github.com/hashicorp/memberlist"
 	"io/ioutil"
+	"net/http"
 	"stathat.com/c/consistent"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// maxJoinRetryInterval 是重试加入集群时最多等待的时间。
+	maxJoinRetryInterval = 30 * time.Second
+
+	// weightSeparator 是一致性哈希中节点名称和权重序号之间的分隔符，比如 127.0.0.1:5837#2。
+	weightSeparator = "#"
+)
+
+var (
+	// gossipProfiles 是 memberlist 的配置模板。
+	gossipProfiles = map[string]func() *memberlist.Config{
+		LANProfile:   memberlist.DefaultLANConfig,
+		WANProfile:   memberlist.DefaultWANConfig,
+		LocalProfile: memberlist.DefaultLocalConfig,
+	}
+)
 
 // node 代表集群中的一个节点，会保存一些和集群相关的数据。
 type node struct {
@@ -18,48 +39,144 @@ type node struct {
 	// address 记录的是当前节点的访问地址，包含 ip 或者主机、端口等信息。
 	address string
 
+	// endpoints 记录的是当前节点每种协议的访问地址，key 是协议类型。
+	endpoints map[string]string
+
+	// delegate 负责通过 memberlist 广播当前节点的元信息。
+	delegate *nodeDelegate
+
 	// circle 是一致性哈希的实例。
 	circle *consistent.Consistent
 
 	// nodeManager 是节点管理器，用于管理节点。
 	nodeManager *memberlist.Memberlist
+
+	// subscribers 是订阅了集群拓扑变化的订阅者。
+	subscribers *subscribers
+
+	// keyring 是 gossip 加密使用的密钥环，没有开启加密的话是 nil。
+	keyring *memberlist.Keyring
+
+	// certificates 是节点使用的 TLS 证书，没有开启 TLS 的话是 nil。
+	certificates *certificates
+
+	// httpClient 是节点之间转发 http 请求使用的客户端。
+	httpClient *http.Client
+
+	// acl 是认证和授权使用的 ACL，没有开启认证的话是 nil。
+	acl *ACL
+
+	// userLimits 是每个用户的限流器，没有限制的话是 nil。
+	userLimits *limitsGroup
+
+	// clientLimits 是 http 每个客户端地址的限流器，没有限制的话是 nil。
+	clientLimits *limitsGroup
+
+	// ctx 会在节点离开集群的时候被取消，更新一致性哈希的定时任务会因此退出。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
 }
 
 // newNode 创建一个节点实例，并使用 options 去初始化。
 func newNode(options *Options) (*node, error) {
 
-	// 如果没有需要加入的集群，则把当前节点当成新集群
-	if options.Cluster == nil || len(options.Cluster) == 0 {
-		options.Cluster = []string{options.Address}
+	// 同一种协议有多个监听器的话，只广播第一个监听器的地址
+	endpoints := make(map[string]string)
+	for _, listener := range options.listeners() {
+		if _, ok := endpoints[listener.Type]; !ok {
+			endpoints[listener.Type] = listener.endpoint()
+		}
+	}
+
+	delegate, err := newNodeDelegate(NodeMeta{
+		Endpoints: endpoints,
+		Version:   Version,
+		Zone:      options.Zone,
+		Weight:    options.Weight,
+		Status:    ActiveStatus,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := newKeyring(options.GossipKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates, err := newCertificates(options)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := loadACL(options.ACLFile)
+	if err != nil {
+		return nil, err
 	}
 
 	// 创建节点管理器，后续所有和集群相关的操作都需要通过这个节点管理器
-	nodeManager, err := createNodeManager(options)
+	// 创建的过程中就会产生事件，它们会先存起来，等节点创建好了再处理
+	events := newEventDelegate()
+	nodeManager, err := createNodeManager(options, delegate, events, keyring)
 	if err != nil {
 		return nil, err
 	}
 
 	// 创建节点
+	ctx, cancel := context.WithCancel(context.Background())
 	node := &node{
-		options:     options,
-		address:     helpers.JoinAddressAndPort(options.Address, options.Port),
-		circle:      consistent.New(),
-		nodeManager: nodeManager,
+		ctx:          ctx,
+		cancel:       cancel,
+		options:      options,
+		address:      helpers.JoinAddressAndPort(options.Address, options.Port),
+		endpoints:    endpoints,
+		delegate:     delegate,
+		circle:       consistent.New(),
+		nodeManager:  nodeManager,
+		subscribers:  newSubscribers(),
+		keyring:      keyring,
+		certificates: certificates,
+		httpClient:   newHTTPClient(certificates),
+		acl:          acl,
+		userLimits:   newLimitsGroup(ctx, options.UserCommandRate, options.UserByteRate),
+		clientLimits: newLimitsGroup(ctx, options.CommandRate, options.ByteRate),
 	}
 
 	// 注意这里设置了一致性哈希的虚拟节点数，并开启了自动更新一致性哈希内的物理节点信息
 	node.circle.NumberOfReplicas = options.VirtualNodeCount
 	node.autoUpdateCircle()
+	go node.handleEvents(events)
 	return node, nil
 }
 
-func createNodeManager(options *Options) (*memberlist.Memberlist, error) {
+// createNodeManager 创建 memberlist 实例并加入集群，delegate 用于广播节点的元信息，events 用于接收集群拓扑变化的事件。
+// keyring 不为 nil 的话会开启 gossip 加密，没有加密或者使用了其他密钥的消息都会被拒绝。
+func createNodeManager(options *Options, delegate memberlist.Delegate, events memberlist.EventDelegate, keyring *memberlist.Keyring) (*memberlist.Memberlist, error) {
 
-	// 在默认的 LAN 配置上进行设置
-	config := memberlist.DefaultLANConfig()
+	// 在配置模板上进行设置，没有指定模板的话使用 LAN 模板
+	profile, ok := gossipProfiles[options.GossipProfile]
+	if !ok {
+		profile = memberlist.DefaultLANConfig
+	}
+
+	config := profile()
 	config.Name = helpers.JoinAddressAndPort(options.Address, options.Port)
 	config.BindAddr = options.Address
+	if options.GossipAddress != "" {
+		config.BindAddr = options.GossipAddress
+	}
+	config.BindPort = options.GossipPort
+	config.AdvertiseAddr = options.AdvertiseAddress
+	config.AdvertisePort = options.AdvertisePort
+	if options.AdvertisePort == 0 {
+		config.AdvertisePort = options.GossipPort
+	}
 	config.LogOutput = ioutil.Discard // 禁用日志输出
+	config.Delegate = delegate
+	config.Events = events
+	config.Keyring = keyring
 
 	// 创建 memberlist 实例
 	nodeManager, err := memberlist.Create(config)
@@ -68,8 +185,33 @@ func createNodeManager(options *Options) (*memberlist.Memberlist, error) {
 	}
 
 	// 加入到指定的集群
-	_, err = nodeManager.Join(options.Cluster)
-	return nodeManager, err
+	if err = joinCluster(nodeManager, options); err != nil {
+		nodeManager.Shutdown()
+		return nil, err
+	}
+	return nodeManager, nil
+}
+
+// joinCluster 加入 options.Cluster 指定的集群，失败的话会按照指数退避的方式重试 options.JoinRetries 次。
+// 没有指定集群的话当前节点就是一个新的集群。
+func joinCluster(nodeManager *memberlist.Memberlist, options *Options) error {
+	if len(options.Cluster) == 0 {
+		return nil
+	}
+
+	interval := time.Duration(options.JoinRetryInterval) * time.Millisecond
+	for retries := 0; ; retries++ {
+		_, err := nodeManager.Join(options.Cluster)
+		if err == nil || retries >= options.JoinRetries {
+			return err
+		}
+
+		helpers.Warnf("Failed to join cluster %v, retrying in %s: %v", options.Cluster, interval, err)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxJoinRetryInterval {
+			interval = maxJoinRetryInterval
+		}
+	}
 }
 
 func (n *node) nodes() []string {
@@ -81,32 +223,157 @@ func (n *node) nodes() []string {
 	return nodes
 }
 
-//  根据 name 选择出一个适合的 node。
+// nodeInfos 返回集群中所有节点的信息，包括每个节点提供服务的地址、版本、区域、权重和状态。
+func (n *node) nodeInfos() []NodeInfo {
+	members := n.nodeManager.Members()
+	infos := make([]NodeInfo, len(members))
+	for i, member := range members {
+		meta, _ := metaOf(member)
+		infos[i] = NodeInfo{Name: member.Name, Gossip: member.Address(), NodeMeta: meta}
+	}
+	return infos
+}
+
+// endpointOf 返回名为 name 的节点使用 serverType 协议提供服务的地址。
+// 旧版本的节点没有广播元信息，这时认为节点的名称就是它的地址。
+func (n *node) endpointOf(name string, serverType string) (string, error) {
+	if n.isCurrentNode(name) {
+		if endpoint, ok := n.endpoints[serverType]; ok {
+			return endpoint, nil
+		}
+		return "", fmt.Errorf("node %s has no %s endpoint", name, serverType)
+	}
+
+	for _, member := range n.nodeManager.Members() {
+		if member.Name != name {
+			continue
+		}
+
+		meta, ok := metaOf(member)
+		if !ok {
+			return name, nil
+		}
+
+		if endpoint, ok := meta.Endpoints[serverType]; ok {
+			return endpoint, nil
+		}
+		return "", fmt.Errorf("node %s has no %s endpoint", name, serverType)
+	}
+	return name, nil
+}
+
+// drain 把当前节点的状态修改为 draining 并广播给其他节点，它们会把这个节点从一致性哈希中移除，最多等待 timeout 这么久。
+func (n *node) drain(timeout time.Duration) error {
+	meta := n.delegate.currentMeta()
+	meta.Status = DrainingStatus
+	if err := n.delegate.setMeta(meta); err != nil {
+		return err
+	}
+
+	err := n.nodeManager.UpdateNode(timeout)
+	n.updateCircle()
+	return err
+}
+
+// otherNodes 返回集群中除了当前节点之外的所有节点。
+func (n *node) otherNodes() []string {
+	var nodes []string
+	for _, node := range n.nodes() {
+		if !n.isCurrentNode(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 根据 name 选择出一个适合的 node。
 func (n *node) selectNode(name string) (string, error) {
-	return n.circle.Get(name)
+	element, err := n.circle.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	// 权重大于 1 的节点在一致性哈希中有多个元素，需要去掉元素名称中的序号
+	if i := strings.LastIndex(element, weightSeparator); i >= 0 {
+		element = element[:i]
+	}
+	return element, nil
 }
 
-//  判断 address 是否指当前节点。
+// 判断 address 是否指当前节点。
 func (n *node) isCurrentNode(address string) bool {
 	return n.address == address
 }
 
 // 更新一致性哈希的信息。
-// 一致性哈希的信息来源就是 memberlist 实例。
+// 一致性哈希的信息来源就是 memberlist 实例，权重为 w 的节点会在一致性哈希中加入 w 个元素。
+// 正在关闭的节点不会再分配数据，除非集群中所有的节点都在关闭。
 func (n *node) updateCircle() {
-	n.circle.Set(n.nodes())
+	infos := n.nodeInfos()
+	members.Set(int64(len(infos)))
+
+	var active []string
+	var draining []string
+	for _, info := range infos {
+		elements := []string{info.Name}
+		for i := 2; i <= info.Weight; i++ {
+			elements = append(elements, info.Name+weightSeparator+strconv.Itoa(i))
+		}
+
+		if info.Status == DrainingStatus {
+			draining = append(draining, elements...)
+		} else {
+			active = append(active, elements...)
+		}
+	}
+
+	if len(active) == 0 {
+		active = draining
+	}
+	n.circle.Set(active)
 }
 
 // autoUpdateCircle 开启一个定时任务去定期更新一致性哈希的信息。
+// 集群拓扑变化时一致性哈希会马上更新，这个定时任务只是用来兜底的，避免丢失的事件导致一致性哈希一直是旧的。
 func (n *node) autoUpdateCircle() {
 	n.updateCircle()
 	go func() {
 		ticker := time.NewTicker(time.Duration(n.options.UpdateCircleDuration) * time.Second)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				n.updateCircle()
+			case <-n.ctx.Done():
+				return
 			}
 		}
 	}()
 }
+
+// leave 让当前节点离开集群，并停止更新一致性哈希。
+// 离开的消息会广播给其他节点，它们不用等到超时就知道这个节点已经下线了，最多等待 timeout 这么久。
+func (n *node) leave(timeout time.Duration) error {
+	n.cancel()
+	if err := n.nodeManager.Leave(timeout); err != nil {
+		n.nodeManager.Shutdown()
+		return err
+	}
+	return n.nodeManager.Shutdown()
+}
+
+// leaveTimeout 返回离开集群最多能等待的时间，ctx 有截止时间的话就使用剩余的时间。
+func leaveTimeout(ctx context.Context) time.Duration {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	// memberlist 的超时为 0 表示一直等待，所以至少要等待一点点时间
+	if timeout <= 0 {
+		timeout = time.Millisecond
+	}
+	return timeout
+}
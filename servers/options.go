@@ -1,5 +1,110 @@
 package servers
 
+import (
+	"Rcache/helpers"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TCPServerType 是使用自定义协议 vex 提供服务的服务器类型。
+	TCPServerType = "tcp"
+
+	// HTTPServerType 是使用 http 协议提供服务的服务器类型。
+	HTTPServerType = "http"
+
+	// maxZoneSize 是区域名称最多能有多少个字节，节点的元信息需要控制在 memberlist 允许的大小之内。
+	maxZoneSize = 64
+
+	// maxWeight 是节点的最大权重，权重越大一致性哈希中的虚拟节点越多。
+	maxWeight = 100
+
+	// LANProfile 是适合局域网的 memberlist 配置。
+	LANProfile = "lan"
+
+	// WANProfile 是适合广域网的 memberlist 配置，探测和超时的时间更长。
+	WANProfile = "wan"
+
+	// LocalProfile 是适合在一台机器上运行多个节点的 memberlist 配置，探测和超时的时间更短，一般用于测试。
+	LocalProfile = "local"
+)
+
+// Listener 是服务器的一个监听器，包含协议类型和监听的地址、端口。
+// 它的文本格式是 type://address:port，比如 http://127.0.0.1:5838。
+type Listener struct {
+
+	// Type 是监听器的协议类型，可以是 tcp 或者 http。
+	Type string
+
+	// Address 是监听使用的地址。
+	Address string
+
+	// Port 是监听使用的端口。
+	Port int
+}
+
+// ParseListener 解析 type://address:port 格式的监听器。
+func ParseListener(s string) (Listener, error) {
+	serverType, address, ok := strings.Cut(s, "://")
+	if !ok {
+		return Listener{}, fmt.Errorf("listener %s should be like type://address:port", s)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Listener{}, fmt.Errorf("invalid listener %s: %w", s, err)
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return Listener{}, fmt.Errorf("invalid port of listener %s", s)
+	}
+	return Listener{Type: serverType, Address: host, Port: n}, nil
+}
+
+// String 返回 type://address:port 格式的监听器。
+func (l Listener) String() string {
+	return l.Type + "://" + l.endpoint()
+}
+
+// MarshalText 让监听器在配置文件中使用文本格式。
+func (l Listener) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText 解析配置文件和环境变量中文本格式的监听器。
+func (l *Listener) UnmarshalText(text []byte) error {
+	listener, err := ParseListener(string(text))
+	if err != nil {
+		return err
+	}
+	*l = listener
+	return nil
+}
+
+// endpoint 返回监听器的 address:port。
+func (l Listener) endpoint() string {
+	return helpers.JoinAddressAndPort(l.Address, l.Port)
+}
+
+// validate 检查监听器是否合法。
+func (l Listener) validate() error {
+	if err := validateHost(l.Address); err != nil {
+		return err
+	}
+
+	if l.Port <= 0 || l.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535 but got %d", l.Port)
+	}
+
+	if l.Type != TCPServerType && l.Type != HTTPServerType {
+		return fmt.Errorf("unknown server type %s", l.Type)
+	}
+	return nil
+}
+
 // Options 是服务器的选项配置。
 type Options struct {
 
@@ -21,14 +126,235 @@ type Options struct {
 
 	// cluster 是指需要加入的集群，只需要集群中一个节点的地址即可。
 	Cluster []string
+
+	// Listeners 是额外的监听器，可以让一个节点同时使用多种协议或者多个地址提供服务。
+	// 它们和 ServerType、Address、Port 指定的主监听器共享同一个缓存和集群节点，节点在集群中的名称还是使用主监听器的地址。
+	Listeners []Listener
+
+	// Zone 是节点所在的区域，比如机房或者可用区，会作为元信息广播给其他节点。
+	Zone string
+
+	// Weight 是节点在一致性哈希中的权重，权重为 2 的节点分配到的数据大约是权重为 1 的节点的两倍。
+	Weight int
+
+	// GossipAddress 是 memberlist 绑定的地址，为空的话使用 Address。
+	GossipAddress string
+
+	// GossipPort 是 memberlist 绑定的端口，为 0 的话会随机选择一个端口。
+	// 在一台机器上运行多个节点时，每个节点需要使用不同的端口。
+	GossipPort int
+
+	// AdvertiseAddress 是告诉其他节点用来访问 memberlist 的地址，为空的话使用绑定的地址。
+	// 在 NAT 或者容器中运行时，绑定的地址其他节点不一定能访问，这时就需要设置它。
+	AdvertiseAddress string
+
+	// AdvertisePort 是告诉其他节点用来访问 memberlist 的端口，为 0 的话使用绑定的端口。
+	AdvertisePort int
+
+	// GossipProfile 是 memberlist 的配置模板，可以是 lan、wan 或者 local，为空的话使用 lan。
+	GossipProfile string
+
+	// JoinRetries 是加入集群失败之后的重试次数。
+	JoinRetries int
+
+	// JoinRetryInterval 是第一次重试加入集群之前等待的时间，之后每次重试等待的时间翻倍，但不会超过 30 秒。
+	// 单位是毫秒。
+	JoinRetryInterval int
+
+	// GossipKeys 是 gossip 加密使用的密钥，每个密钥是 base64 编码的 16、24 或者 32 个字节，第一个密钥用于加密。
+	// 设置了密钥之后，没有使用这些密钥加密的 gossip 消息都会被拒绝，只在当前节点执行的内部请求也只接受集群中的节点发来的。
+	GossipKeys []string
+
+	// TLSCertFile 是 PEM 格式的证书文件，设置了的话所有的监听器都会使用 TLS，节点之间的请求也会使用 TLS。
+	TLSCertFile string
+
+	// TLSKeyFile 是证书对应的 PEM 格式的私钥文件。
+	TLSKeyFile string
+
+	// TLSCAFile 是 PEM 格式的 CA 证书文件，用于验证客户端的证书和其他节点的证书，为空的话使用系统的根证书。
+	TLSCAFile string
+
+	// TLSClientAuth 表示是否要求客户端提供使用 TLSCAFile 签发的证书，也就是双向 TLS。
+	TLSClientAuth bool
+
+	// TLSMinVersion 是允许使用的最低 TLS 版本，可以是 1.2 或者 1.3。
+	TLSMinVersion string
+
+	// ACLFile 是 json 格式的 ACL 文件，设置了的话所有请求都需要认证，并且只能执行用户有权限的命令，为空表示不开启认证。
+	// 集群中所有节点需要使用同样的 ACL 文件，因为广播的请求会带着用户的认证信息发给其他节点。
+	ACLFile string
+
+	// MaxConnections 是每个 TCP 监听器最多同时处理的连接数，超过的连接会被拒绝，为 0 表示不限制。
+	MaxConnections int
+
+	// CommandRate 是每个 TCP 连接每秒最多执行的命令数，http 按照客户端的地址限制，为 0 表示不限制。
+	CommandRate int
+
+	// ByteRate 是每个 TCP 连接每秒最多发送的字节数，只计算请求中的数据，http 按照客户端的地址限制，为 0 表示不限制。
+	ByteRate int
+
+	// UserCommandRate 是开启认证之后每个用户每秒最多执行的命令数，这个用户所有的连接共享，为 0 表示不限制。
+	UserCommandRate int
+
+	// UserByteRate 是开启认证之后每个用户每秒最多发送的字节数，这个用户所有的连接共享，为 0 表示不限制。
+	UserByteRate int
+
+	// MaxBodySize 是 http 请求体最多的字节数，超过的请求会响应 413，为 0 表示不限制。
+	MaxBodySize int
+}
+
+// primary 返回 ServerType、Address、Port 指定的主监听器。
+func (o *Options) primary() Listener {
+	return Listener{Type: o.ServerType, Address: o.Address, Port: o.Port}
+}
+
+// listeners 返回所有的监听器，第一个是主监听器。
+func (o *Options) listeners() []Listener {
+	return append([]Listener{o.primary()}, o.Listeners...)
+}
+
+// Validate 严格检查选项配置是否合法，比如地址是否正确、时间间隔是否为正数。
+func (o *Options) Validate() error {
+
+	// 每个监听器都要合法，并且不能监听同一个端口
+	endpoints := make(map[string]bool)
+	for _, listener := range o.listeners() {
+		if err := listener.validate(); err != nil {
+			return fmt.Errorf("invalid listener %s: %w", listener, err)
+		}
+
+		if endpoints[listener.endpoint()] {
+			return fmt.Errorf("listener %s uses a duplicated endpoint", listener)
+		}
+		endpoints[listener.endpoint()] = true
+	}
+
+	if o.VirtualNodeCount <= 0 {
+		return fmt.Errorf("VirtualNodeCount must be positive but got %d", o.VirtualNodeCount)
+	}
+
+	if len(o.Zone) > maxZoneSize {
+		return fmt.Errorf("Zone can't be longer than %d bytes", maxZoneSize)
+	}
+
+	if o.Weight <= 0 || o.Weight > maxWeight {
+		return fmt.Errorf("Weight must be between 1 and %d but got %d", maxWeight, o.Weight)
+	}
+
+	if o.GossipAddress != "" {
+		if err := validateHost(o.GossipAddress); err != nil {
+			return fmt.Errorf("invalid GossipAddress: %w", err)
+		}
+	}
+
+	if o.AdvertiseAddress != "" {
+		if err := validateHost(o.AdvertiseAddress); err != nil {
+			return fmt.Errorf("invalid AdvertiseAddress: %w", err)
+		}
+	}
+
+	if o.GossipPort < 0 || o.GossipPort > 65535 || o.AdvertisePort < 0 || o.AdvertisePort > 65535 {
+		return fmt.Errorf("GossipPort and AdvertisePort must be between 0 and 65535 but got %d and %d", o.GossipPort, o.AdvertisePort)
+	}
+
+	if _, ok := gossipProfiles[o.GossipProfile]; !ok && o.GossipProfile != "" {
+		return fmt.Errorf("unknown gossip profile %s", o.GossipProfile)
+	}
+
+	if o.JoinRetries < 0 || o.JoinRetryInterval <= 0 {
+		return fmt.Errorf("JoinRetries can't be negative and JoinRetryInterval must be positive but got %d and %d", o.JoinRetries, o.JoinRetryInterval)
+	}
+
+	if _, err := decodeKeys(o.GossipKeys); err != nil {
+		return err
+	}
+
+	if o.MaxConnections < 0 || o.CommandRate < 0 || o.ByteRate < 0 || o.UserCommandRate < 0 || o.UserByteRate < 0 || o.MaxBodySize < 0 {
+		return fmt.Errorf("MaxConnections, CommandRate, ByteRate, UserCommandRate, UserByteRate and MaxBodySize can't be negative")
+	}
+
+	if err := o.validateTLS(); err != nil {
+		return err
+	}
+
+	if o.UpdateCircleDuration <= 0 {
+		return fmt.Errorf("UpdateCircleDuration must be positive but got %d", o.UpdateCircleDuration)
+	}
+
+	// 集群中的节点可以只写地址，也可以带上 memberlist 的端口
+	for _, node := range o.Cluster {
+		host := node
+		if h, port, err := net.SplitHostPort(node); err == nil {
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				return fmt.Errorf("invalid port of cluster node %s", node)
+			}
+			host = h
+		}
+
+		if err := validateHost(host); err != nil {
+			return fmt.Errorf("invalid cluster node %s: %w", node, err)
+		}
+	}
+	return nil
+}
+
+// validateHost 检查 host 是不是合法的 ip 或者主机名。
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("address can't be empty")
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("invalid address %s", host)
+		}
+
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("invalid address %s", host)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTLS 检查 TLS 相关的配置，证书和私钥必须同时设置，验证客户端的证书需要设置 CA。
+func (o *Options) validateTLS() error {
+	if _, ok := tlsVersions[o.TLSMinVersion]; !ok {
+		return fmt.Errorf("TLSMinVersion must be 1.2 or 1.3 but got %s", o.TLSMinVersion)
+	}
+
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile must be set together")
+	}
+
+	if o.TLSCertFile == "" && (o.TLSCAFile != "" || o.TLSClientAuth) {
+		return fmt.Errorf("TLSCAFile and TLSClientAuth need TLSCertFile and TLSKeyFile")
+	}
+
+	if o.TLSClientAuth && o.TLSCAFile == "" {
+		return fmt.Errorf("TLSClientAuth needs TLSCAFile to verify certificates of clients")
+	}
+	return nil
 }
 
 func DefaultOptions() Options {
 	return Options{
 		Address:              "127.0.0.1",
 		Port:                 5837,
-		ServerType:           "tcp",
+		ServerType:           TCPServerType,
 		VirtualNodeCount:     1024,
-		UpdateCircleDuration: 3,  //这里的单位是秒
+		UpdateCircleDuration: 3, //这里的单位是秒
+		Weight:               1,
+		GossipPort:           7946,
+		GossipProfile:        LANProfile,
+		JoinRetries:          3,
+		JoinRetryInterval:    1000, // 1 s
+		TLSMinVersion:        "1.2",
+		MaxBodySize:          64 << 20, // 64 MB
 	}
 }
@@ -1,6 +1,11 @@
 package servers
 
-import "Rcache/caches"
+import (
+	"Rcache/caches"
+	"context"
+	"errors"
+	"sync"
+)
 
 const (
 	// APIVersion 代表当前服务的版本。
@@ -9,19 +14,152 @@ const (
 	APIVersion = "v1"
 )
 
+var (
+	// Version 是 Rcache 的版本，会作为节点的元信息广播给其他节点，构建时可以使用 -ldflags "-X Rcache/servers.Version=x.y.z" 设置。
+	Version = "dev"
+)
+
 // Server 是服务器的抽象接口。
 type Server interface {
 
-	// Run 方法会启动这个服务器。
+	// Run 方法会启动这个服务器，服务器被 Shutdown 之后会返回 nil。
 	Run() error
-}
 
+	// Shutdown 方法会优雅地关闭这个服务器。
+	// 先停止接收新的连接，等待正在处理的请求完成，然后离开集群，让其他节点马上知道这个节点已经下线了。
+	// ctx 结束时还没处理完的请求会被强制中断。
+	Shutdown(ctx context.Context) error
+
+	// SetReloadHandler 设置重新加载配置的处理器，管理接口收到重新加载的请求时会调用它。
+	// 处理器返回的是修改了但需要重启才能生效的配置名称。
+	SetReloadHandler(handler ReloadHandler)
 
+	// Subscribe 订阅集群拓扑的变化，返回接收事件的管道和取消订阅的函数。
+	Subscribe() (<-chan TopologyEvent, func())
+}
 
+// ReloadHandler 是重新加载配置的处理器，返回修改了但需要重启才能生效的配置名称。
+type ReloadHandler func() (ignored []string, err error)
 
+var (
+	// reloadNotSupportedErr 是服务器没有设置重新加载配置的处理器的错误。
+	reloadNotSupportedErr = errors.New("reloading is not supported")
+)
+
+// NewServer 使用 options 创建服务器，配置了多个监听器的话，返回的服务器会同时运行所有的监听器。
+// 所有的监听器共享 cache 和同一个集群节点。
 func NewServer(cache *caches.Cache, options Options) (Server, error) {
-	if options.ServerType == "tcp" {
-		return NewTCPServer(cache, &options)
+	if len(options.Listeners) == 0 {
+		if options.ServerType == TCPServerType {
+			return NewTCPServer(cache, &options)
+		}
+		return NewHTTPServer(cache, &options)
+	}
+
+	n, err := newNode(&options)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &serverGroup{node: n}
+	for _, listener := range options.listeners() {
+		if listener.Type == TCPServerType {
+			group.servers = append(group.servers, newTCPServer(cache, &options, n, listener))
+		} else {
+			group.servers = append(group.servers, newHTTPServer(cache, &options, n, listener))
+		}
+	}
+	return group, nil
+}
+
+// listenerServer 是只负责一个监听器的服务器，关闭时不会离开集群，集群节点由 serverGroup 统一管理。
+type listenerServer interface {
+
+	// Run 方法会启动这个监听器。
+	Run() error
+
+	// shutdown 方法会停止这个监听器，并等待正在处理的请求完成。
+	shutdown(ctx context.Context) error
+
+	// SetReloadHandler 设置重新加载配置的处理器。
+	SetReloadHandler(handler ReloadHandler)
+}
+
+// serverGroup 是同时运行多个监听器的服务器。
+type serverGroup struct {
+
+	// node 是所有监听器共享的集群节点。
+	node *node
+
+	// servers 是每个监听器对应的服务器。
+	servers []listenerServer
+}
+
+// Run 同时启动所有的监听器，只要有一个监听器失败就返回它的错误，所有监听器都被 Shutdown 之后返回 nil。
+func (sg *serverGroup) Run() error {
+	errs := make(chan error, len(sg.servers))
+	for _, server := range sg.servers {
+		go func(server listenerServer) {
+			errs <- server.Run()
+		}(server)
+	}
+
+	for range sg.servers {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown 先把节点标记为 draining，然后停止所有的监听器并等待正在处理的请求完成，最后离开集群。
+func (sg *serverGroup) Shutdown(ctx context.Context) error {
+	sg.node.drain(leaveTimeout(ctx))
+	errs := make([]error, len(sg.servers))
+	wg := &sync.WaitGroup{}
+	for i, server := range sg.servers {
+		wg.Add(1)
+		go func(i int, server listenerServer) {
+			defer wg.Done()
+			errs[i] = server.shutdown(ctx)
+		}(i, server)
+	}
+	wg.Wait()
+
+	err := sg.node.leave(leaveTimeout(ctx))
+	for _, shutdownErr := range errs {
+		if shutdownErr != nil {
+			return shutdownErr
+		}
+	}
+	return err
+}
+
+// Subscribe 订阅集群拓扑的变化，所有的监听器共享同一个集群节点，所以只需要订阅一次。
+func (sg *serverGroup) Subscribe() (<-chan TopologyEvent, func()) {
+	return sg.node.Subscribe()
+}
+
+// SetReloadHandler 给所有的监听器设置重新加载配置的处理器。
+func (sg *serverGroup) SetReloadHandler(handler ReloadHandler) {
+	for _, server := range sg.servers {
+		server.SetReloadHandler(handler)
+	}
+}
+
+// routingKey 返回一致性哈希选择节点时使用的 key。
+// 默认命名空间的数据还是直接使用 key，这样和以前的数据分布保持一致，其他命名空间的数据会带上命名空间的名称，让不同命名空间的数据分散开。
+func routingKey(namespace string, key string) string {
+	if namespace == caches.DefaultNamespace {
+		return key
+	}
+	return namespace + "/" + key
+}
+
+// ignoredOrEmpty 保证返回的配置名称不是 nil，这样序列化成 json 之后是 [] 而不是 null。
+func ignoredOrEmpty(ignored []string) []string {
+	if ignored == nil {
+		return []string{}
 	}
-	return NewHTTPServer(cache, &options)
+	return ignored
 }
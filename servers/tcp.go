@@ -4,10 +4,16 @@ import (
 	"Rcache/caches"
 	"Rcache/helpers"
 	"Rcache/vex"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -25,6 +31,84 @@ const (
 
 	// nodesCommand 是 nodes 命令。
 	nodesCommand = byte(5)
+
+	// expireCommand 是 expire 命令。
+	expireCommand = byte(6)
+
+	// persistCommand 是 persist 命令。
+	persistCommand = byte(7)
+
+	// ttlCommand 是 ttl 命令。
+	ttlCommand = byte(8)
+
+	// touchCommand 是 touch 命令。
+	touchCommand = byte(9)
+
+	// flushNamespaceCommand 是清空一个命名空间的命令。
+	flushNamespaceCommand = byte(10)
+
+	// invalidateTagCommand 是删除带有指定标签的所有数据的命令。
+	invalidateTagCommand = byte(11)
+
+	// flushCommand 是清空整个缓存的命令。
+	flushCommand = byte(12)
+
+	// metaCommand 是返回数据元信息的命令。
+	metaCommand = byte(13)
+
+	// reloadCommand 是重新加载配置的命令，只在当前节点执行。
+	reloadCommand = byte(14)
+
+	// metricsCommand 是返回服务器指标的命令。
+	metricsCommand = byte(15)
+
+	// keysCommand 是管理 gossip 密钥的命令。
+	keysCommand = byte(16)
+
+	// authCommand 是认证的命令，参数是用户名和密码，或者只有一个令牌。
+	authCommand = byte(17)
+)
+
+const (
+	// pxOption 是 set 命令的可选项，表示以毫秒为单位设置 ttl，类似于 Redis 中的 PX。
+	pxOption = "PX"
+
+	// pxatOption 是 set 命令的可选项，表示以毫秒级的 Unix 时间戳设置过期时间，类似于 Redis 中的 PXAT。
+	pxatOption = "PXAT"
+
+	// modeOption 是 set 命令的可选项，表示数据的过期模式，取值参考 caches.ExpireMode。
+	modeOption = "MODE"
+
+	// maxpxOption 是 set 命令的可选项，表示滑动过期模式下以毫秒为单位的最大寿命。
+	maxpxOption = "MAXPX"
+
+	// nsOption 是所有操作数据的命令都支持的可选项，表示数据所在的命名空间，选项值是命名空间的名称。
+	// 除了 set 命令之外，其他命令的这个选项都紧跟在固定的参数后面，比如 get key NS name。
+	nsOption = "NS"
+
+	// tagOption 是 set 命令的可选项，表示数据的标签，选项值是标签的名称，可以出现多次。
+	tagOption = "TAG"
+
+	// flagsOption 是 set 命令的可选项，表示客户端自定义的标记，选项值是大端存储的 8 字节数字，但是不能超过 32 位。
+	flagsOption = "FLAGS"
+
+	// ctypeOption 是 set 命令的可选项，表示数据的内容类型，选项值是内容类型的字符串，比如 application/json。
+	ctypeOption = "CTYPE"
+
+	// ifverOption 是 set 命令的可选项，表示只有数据当前的版本号等于选项值时才写入，可以实现乐观锁。
+	ifverOption = "IFVER"
+
+	// nxOption 是 set 命令的可选项，选项值不为 0 时表示只有数据不存在时才写入，类似于 Redis 中的 NX。
+	nxOption = "NX"
+
+	// scopeOption 是需要在集群中广播的命令的可选项，选项值是 localScope 时只在当前节点执行。
+	scopeOption = "SCOPE"
+
+	// localScope 表示命令只在当前节点执行，节点之间广播命令时会带上它，避免命令被无限广播。
+	localScope = "local"
+
+	// sigOption 是节点之间广播的内部命令的签名可选项，必须是最后一个可选项，选项值是使用 gossip 密钥计算的签名。
+	sigOption = "SIG"
 )
 
 var (
@@ -33,8 +117,56 @@ var (
 
 	// notFoundErr 是找不到的错误。
 	notFoundErr = errors.New("not found")
+
+	// invalidOptionErr 是命令的可选项不合法的错误。
+	invalidOptionErr = errors.New("invalid option")
 )
 
+var (
+	// commandPermissions 是每个命令需要的权限，认证命令不需要权限。
+	commandPermissions = map[byte]string{
+		getCommand:            ReadPermission,
+		statusCommand:         ReadPermission,
+		nodesCommand:          ReadPermission,
+		ttlCommand:            ReadPermission,
+		metaCommand:           ReadPermission,
+		metricsCommand:        ReadPermission,
+		setCommand:            WritePermission,
+		deleteCommand:         WritePermission,
+		expireCommand:         WritePermission,
+		persistCommand:        WritePermission,
+		touchCommand:          WritePermission,
+		invalidateTagCommand:  WritePermission,
+		flushNamespaceCommand: AdminPermission,
+		flushCommand:          AdminPermission,
+		reloadCommand:         AdminPermission,
+		keysCommand:           AdminPermission,
+	}
+)
+
+// sessionState 是保存在 TCP 连接的会话中的数据。
+type sessionState struct {
+
+	// user 是认证通过的用户，没有认证的话是 nil。
+	user *User
+
+	// peerNonce 是这个连接申请的转发用户时签名使用的一次性随机数，使用之后就会清空。
+	peerNonce []byte
+
+	// limits 是这个连接的限流器，没有限制的话是 nil。
+	limits *limits
+}
+
+// stateOf 返回 session 中保存的数据，还没有的话就使用 options 创建一个。
+func stateOf(session *vex.Session, options *Options) *sessionState {
+	state, ok := session.Data.(*sessionState)
+	if !ok {
+		state = &sessionState{limits: newLimits(options.CommandRate, options.ByteRate)}
+		session.Data = state
+	}
+	return state
+}
+
 // TCPServer 是 TCP 类型的服务器。
 type TCPServer struct {
 	// cache 是内部用于存储数据的缓存组件。
@@ -46,6 +178,12 @@ type TCPServer struct {
 	server *vex.Server
 	// options 存储着这个服务器的选项配置。
 	options *Options
+
+	// listener 是这个服务器使用的监听器。
+	listener Listener
+
+	// reloader 是重新加载配置的处理器，没有设置的话 reload 命令会返回错误。
+	reloader ReloadHandler
 }
 
 // NewTCPServer 返回新的 TCP 服务器。
@@ -55,13 +193,18 @@ func NewTCPServer(cache *caches.Cache, options *Options) (*TCPServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newTCPServer(cache, options, n, options.primary()), nil
+}
 
+// newTCPServer 返回使用 listener 监听的 TCP 服务器，同一个节点的多个监听器共享 cache 和 n。
+func newTCPServer(cache *caches.Cache, options *Options, n *node, listener Listener) *TCPServer {
 	return &TCPServer{
-		node: n,
-		cache:   cache,
-		server:  vex.NewServer(),
-		options: options,
-	}, nil
+		node:     n,
+		cache:    cache,
+		server:   vex.NewServer(),
+		options:  options,
+		listener: listener,
+	}
 }
 
 // Run 运行这个 TCP 服务器。
@@ -72,7 +215,26 @@ func (ts *TCPServer) Run() error {
 	ts.server.RegisterHandler(deleteCommand, ts.deleteHandler)
 	ts.server.RegisterHandler(statusCommand, ts.statusHandler)
 	ts.server.RegisterHandler(nodesCommand, ts.nodesHandler)
-	return ts.server.ListenAndServe("tcp", helpers.JoinAddressAndPort(ts.options.Address, ts.options.Port))
+	ts.server.RegisterHandler(expireCommand, ts.expireHandler)
+	ts.server.RegisterHandler(persistCommand, ts.persistHandler)
+	ts.server.RegisterHandler(ttlCommand, ts.ttlHandler)
+	ts.server.RegisterHandler(touchCommand, ts.touchHandler)
+	ts.server.RegisterHandler(flushNamespaceCommand, ts.flushNamespaceHandler)
+	ts.server.RegisterSessionHandler(invalidateTagCommand, ts.invalidateTagHandler)
+	ts.server.RegisterSessionHandler(flushCommand, ts.flushHandler)
+	ts.server.RegisterHandler(metaCommand, ts.metaHandler)
+	ts.server.RegisterHandler(reloadCommand, ts.reloadHandler)
+	ts.server.RegisterHandler(metricsCommand, ts.metricsHandler)
+	ts.server.RegisterSessionHandler(keysCommand, ts.keysHandler)
+	ts.server.RegisterSessionHandler(authCommand, ts.authHandler)
+	ts.server.SetInterceptor(ts.intercept)
+	ts.server.SetMaxConnections(ts.options.MaxConnections, func() {
+		metrics.Add(rejectedConnectionsMetric, 1)
+	})
+	if ts.certificates != nil {
+		return ts.server.ListenAndServeTLS("tcp", ts.listener.endpoint(), ts.certificates.serverConfig())
+	}
+	return ts.server.ListenAndServe("tcp", ts.listener.endpoint())
 }
 
 // Close 用于关闭服务器。
@@ -80,7 +242,125 @@ func (ts *TCPServer) Close() error {
 	return ts.server.Close()
 }
 
+// SetReloadHandler 设置重新加载配置的处理器，需要在 Run 之前调用。
+func (ts *TCPServer) SetReloadHandler(handler ReloadHandler) {
+	ts.reloader = handler
+}
+
+// Shutdown 优雅地关闭服务器，先把节点标记为 draining，等待正在处理的请求完成之后离开集群。
+func (ts *TCPServer) Shutdown(ctx context.Context) error {
+
+	// 先广播正在关闭的状态，让其他节点不再把请求重定向过来，广播失败也没关系，离开集群时其他节点还是会知道
+	ts.drain(leaveTimeout(ctx))
+	err := ts.shutdown(ctx)
+	if leaveErr := ts.leave(leaveTimeout(ctx)); err == nil {
+		err = leaveErr
+	}
+	return err
+}
+
+// shutdown 停止接收新的连接并等待正在处理的请求完成，但是不会离开集群。
+func (ts *TCPServer) shutdown(ctx context.Context) error {
+	return ts.server.Shutdown(ctx)
+}
+
+// checkNode 使用一致性哈希选择出这个 key 所属的物理节点。
+// 如果这个节点不是当前节点，就返回重定向的错误，并告知客户端正确的节点地址。
+func (ts *TCPServer) checkNode(key string) error {
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return err
+	}
+
+	if !ts.isCurrentNode(node) {
+		endpoint, err := ts.endpointOf(node, TCPServerType)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("redirect to node %s", endpoint)
+	}
+	return nil
+}
+
+// optionOf 从成对出现的可选项中找出名为 name 的选项值。
+func optionOf(options [][]byte, name string) ([]byte, bool) {
+	for i := 0; i+1 < len(options); i += 2 {
+		if strings.ToUpper(string(options[i])) == name {
+			return options[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// namespaceNameOf 从固定参数后面的可选项中解析出命名空间的名称，没有指定的话就是默认的命名空间。
+func namespaceNameOf(options [][]byte) string {
+	if name, ok := optionOf(options, nsOption); ok {
+		return string(name)
+	}
+	return caches.DefaultNamespace
+}
+
+// namespaceOf 从固定参数后面的可选项中解析出已经存在的命名空间，不存在的话返回 notFoundErr。
+// 这里不会创建命名空间，只有 set 命令写入数据时才会创建，否则读取不存在的命名空间就能不停地创建出新的命名空间。
+func (ts *TCPServer) namespaceOf(options [][]byte) (*caches.Namespace, error) {
+	namespace, ok := ts.cache.LookupNamespace(namespaceNameOf(options))
+	if !ok {
+		return nil, notFoundErr
+	}
+	return namespace, nil
+}
+
+// keyNamespaceOf 先检查 key 是否属于当前节点，不属于的话返回重定向的错误，然后再返回可选项中指定的已经存在的命名空间。
+// 当前节点没有这个命名空间不代表 key 所在的节点也没有，所以要先检查节点。
+func (ts *TCPServer) keyNamespaceOf(key string, options [][]byte) (*caches.Namespace, error) {
+	if err := ts.checkNode(routingKey(namespaceNameOf(options), key)); err != nil {
+		return nil, err
+	}
+	return ts.namespaceOf(options)
+}
+
+// broadcast 在集群中其他所有节点上并发地执行 fn，只要有一个节点失败就返回错误。
+// 开启了认证的话，会先使用节点签名的用户名在其他节点上认证，用户的密码和令牌不会发送给其他节点。
+func (ts *TCPServer) broadcast(session *vex.Session, fn func(client *TCPClient) error) error {
+	user := stateOf(session, ts.options).user
+	nodes := ts.otherNodes()
+	errs := make([]error, len(nodes))
+	wg := &sync.WaitGroup{}
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			endpoint, err := ts.endpointOf(node, TCPServerType)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			client, err := ts.dialTCP(endpoint)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer client.Close()
+			client.sign = ts.signature
+			if user != nil {
+				if err = client.authPeer(user.Name); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			errs[i] = fn(client)
+		}(i, node)
+	}
+	wg.Wait()
 
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to broadcast to node %s: %w", nodes[i], err)
+		}
+	}
+	return nil
+}
 
 // getHandler 是处理 get 命令的的处理器。
 func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
@@ -90,20 +370,15 @@ func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
 		return nil, commandNeedsMoreArgumentsErr
 	}
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
 	key := string(args[0])
-	node, err := ts.selectNode(key)
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
 	if err != nil {
 		return nil, err
 	}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
-	}
-
 	// 调用缓存的 Get 方法，如果不存在就返回 notFoundErr 错误
-	value, ok := ts.cache.Get(key)
+	value, ok := namespace.Get(key)
 	if !ok {
 		return value, notFoundErr
 	}
@@ -118,27 +393,89 @@ func (ts *TCPServer) setHandler(args [][]byte) (body []byte, err error) {
 		return nil, commandNeedsMoreArgumentsErr
 	}
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
-	key := string(args[1])
-	node, err := ts.selectNode(key)
+	name, options, err := setOptionsFromArgs(args)
 	if err != nil {
 		return nil, err
 	}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
+	namespace, err := ts.cache.Namespace(name)
+	if err != nil {
+		return nil, err
 	}
 
-	// 读取 ttl，注意这里使用大端的方式读取，所以要求客户端也以大端的方式进行存储
-	ttl := int64(binary.BigEndian.Uint64(args[0]))
-	err = ts.cache.SetWithTTL(key, args[2], ttl)
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[1])
+	if err = ts.checkNode(routingKey(namespace.Name(), key)); err != nil {
+		return nil, err
+	}
+
+	err = namespace.SetWith(key, args[2], options)
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
+// setOptionsFromArgs 从 set 命令的参数中解析出命名空间和选项配置。
+// value 后面是成对出现的可选项，每一对都是选项名和选项值，比如 PX 250，除了 NS、TAG 和 CTYPE 之外的选项值都是大端存储的 8 字节数字。
+func setOptionsFromArgs(args [][]byte) (namespace string, options caches.SetOptions, err error) {
+
+	// 读取 ttl，注意这里使用大端的方式读取，所以要求客户端也以大端的方式进行存储
+	options.Ttl = time.Duration(binary.BigEndian.Uint64(args[0])) * time.Second
+	if len(args[3:])%2 != 0 {
+		return namespace, options, commandNeedsMoreArgumentsErr
+	}
+
+	for i := 3; i < len(args); i += 2 {
+		name := strings.ToUpper(string(args[i]))
+		if name == nsOption {
+			namespace = string(args[i+1])
+			continue
+		}
+
+		if name == tagOption {
+			options.Tags = append(options.Tags, string(args[i+1]))
+			continue
+		}
+
+		if name == ctypeOption {
+			options.ContentType = string(args[i+1])
+			continue
+		}
+
+		number, ok := helpers.BytesToUint64(args[i+1])
+		if !ok {
+			return namespace, options, invalidOptionErr
+		}
+
+		switch name {
+		case pxOption:
+			options.Ttl = time.Duration(number) * time.Millisecond
+		case pxatOption:
+			options.ExpireAt = time.UnixMilli(int64(number))
+		case modeOption:
+			if number > uint64(caches.ExpireSlidingWithMax) {
+				return namespace, options, invalidOptionErr
+			}
+			options.ExpireMode = caches.ExpireMode(number)
+		case maxpxOption:
+			options.MaxLifetime = time.Duration(number) * time.Millisecond
+		case flagsOption:
+			if number > math.MaxUint32 {
+				return namespace, options, invalidOptionErr
+			}
+			options.Flags = uint32(number)
+		case ifverOption:
+			options.IfVersion = number
+		case nxOption:
+			options.IfAbsent = number != 0
+		default:
+			return namespace, options, invalidOptionErr
+		}
+	}
+	return namespace, options, nil
+}
+
 // deleteHandler 是处理 delete 命令的处理器。
 func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
 
@@ -147,32 +484,456 @@ func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
 		return nil, commandNeedsMoreArgumentsErr
 	}
 
-	// 使用一致性哈希选择出这个 key 所属的物理节点
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
 	key := string(args[0])
-	node, err := ts.selectNode(key)
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
+	if err == notFoundErr {
+		// 命名空间不存在的话里面的数据也不存在，和删除不存在的 key 一样
+		return nil, nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
+	// 删除指定的数据
+	err = namespace.Delete(key)
+	if err != nil {
+		return nil, err
 	}
+	return nil, nil
+}
 
-	// 删除指定的数据
-	err = ts.cache.Delete(key)
+// expireHandler 是处理 expire 命令的处理器，参数是 key 和以毫秒为单位的 ttl。
+func (ts *TCPServer) expireHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[0])
+	namespace, err := ts.keyNamespaceOf(key, args[2:])
 	if err != nil {
 		return nil, err
 	}
+
+	ttl, ok := helpers.BytesToUint64(args[1])
+	if !ok {
+		return nil, invalidOptionErr
+	}
+
+	if !namespace.Expire(key, time.Duration(ttl)*time.Millisecond) {
+		return nil, notFoundErr
+	}
 	return nil, nil
 }
 
-// statusHandler 是返回缓存状态的处理器。
+// persistHandler 是处理 persist 命令的处理器。
+func (ts *TCPServer) persistHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[0])
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if !namespace.Persist(key) {
+		return nil, notFoundErr
+	}
+	return nil, nil
+}
+
+// ttlHandler 是处理 ttl 命令的处理器，返回的是大端存储的以毫秒为单位的剩余寿命，0 表示永不过期。
+func (ts *TCPServer) ttlHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[0])
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, ok := namespace.TTL(key)
+	if !ok {
+		return nil, notFoundErr
+	}
+	return helpers.Uint64ToBytes(uint64(ttlMilliseconds(ttl))), nil
+}
+
+// metaHandler 是处理 meta 命令的处理器，返回的是 json 格式的数据元信息。
+func (ts *TCPServer) metaHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[0])
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := namespace.Meta(key)
+	if !ok {
+		return nil, notFoundErr
+	}
+	return json.Marshal(meta)
+}
+
+// touchHandler 是处理 touch 命令的处理器。
+func (ts *TCPServer) touchHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希判断这个 key 是否属于当前节点，如果不是，需要响应重定向信息给客户端
+	key := string(args[0])
+	namespace, err := ts.keyNamespaceOf(key, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if !namespace.Touch(key) {
+		return nil, notFoundErr
+	}
+	return nil, nil
+}
+
+// invalidateTagHandler 是处理删除带有指定标签的所有数据的处理器，返回的是大端存储的删除的数据个数。
+// 带有同一个标签的数据会分布在集群的各个节点上，所以除非指定了 SCOPE local，否则命令会广播到集群中其他所有节点。
+func (ts *TCPServer) invalidateTagHandler(session *vex.Session, args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	local, err := ts.checkScope(invalidateTagCommand, args, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	// 当前节点没有这个命名空间的话，其他节点上可能有，所以还是要广播
+	name := namespaceNameOf(args[1:])
+	tag := string(args[0])
+	invalidated := int64(0)
+	if namespace, ok := ts.cache.LookupNamespace(name); ok {
+		invalidated = int64(namespace.InvalidateTag(tag))
+	}
+
+	if local {
+		return helpers.Uint64ToBytes(uint64(invalidated)), nil
+	}
+
+	err = ts.broadcast(session, func(client *TCPClient) error {
+		count, err := client.Namespace(name).invalidateTag(tag, localScope)
+		atomic.AddInt64(&invalidated, int64(count))
+		return err
+	})
+	return helpers.Uint64ToBytes(uint64(invalidated)), err
+}
+
+// flushHandler 是清空整个缓存的处理器，除非指定了 SCOPE local，否则命令会广播到集群中其他所有节点。
+func (ts *TCPServer) flushHandler(session *vex.Session, args [][]byte) (body []byte, err error) {
+	local, err := ts.checkScope(flushCommand, args, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.cache.Flush()
+	if local {
+		return nil, nil
+	}
+
+	return nil, ts.broadcast(session, func(client *TCPClient) error {
+		return client.flush(localScope)
+	})
+}
+
+// keysHandler 是管理 gossip 密钥的处理器，参数是操作和 base64 编码的密钥，操作可以是 list、install、use 或者 remove。
+// list 返回 json 格式的当前节点的密钥，其他操作除非指定了 SCOPE local，否则会广播到集群中其他所有节点。
+func (ts *TCPServer) keysHandler(session *vex.Session, args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	action := string(args[0])
+	if action == listKeysAction {
+		keys, err := ts.keys()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(keys)
+	}
+
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	local, err := ts.checkScope(keysCommand, args, args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	key := string(args[1])
+	if err = ts.changeKey(action, key); err != nil || local {
+		return nil, err
+	}
+
+	return nil, ts.broadcast(session, func(client *TCPClient) error {
+		return client.changeKey(action, key, localScope)
+	})
+}
+
+// checkScope 返回 options 中是否指定了 SCOPE local，指定了的话说明这是其他节点广播过来的内部命令，还会检查命令的签名。
+// args 是命令所有的参数，options 是其中固定参数后面的可选项。
+func (ts *TCPServer) checkScope(command byte, args [][]byte, options [][]byte) (local bool, err error) {
+	scope, ok := optionOf(options, scopeOption)
+	if !ok || string(scope) != localScope {
+		return false, nil
+	}
+	return true, ts.verifyCommand(command, args)
+}
+
+// verifyCommand 检查内部命令的签名是不是集群中的节点计算的，签名是最后一个可选项，计算签名时不包括它。
+func (ts *TCPServer) verifyCommand(command byte, args [][]byte) error {
+	var signature []byte
+	if n := len(args); n >= 2 && string(args[n-2]) == sigOption {
+		args, signature = args[:n-2], args[n-1]
+	}
+	return ts.verify(commandMessage(command, args), signature)
+}
+
+// commandMessage 返回计算命令签名时使用的内容，也就是命令和每个带着长度的参数。
+func commandMessage(command byte, args [][]byte) []byte {
+	message := []byte{command}
+	for _, arg := range args {
+		message = append(message, helpers.Uint64ToBytes(uint64(len(arg)))...)
+		message = append(message, arg...)
+	}
+	return message
+}
+
+// authHandler 是认证的处理器，参数是用户名和密码，或者只有一个令牌，认证通过之后这个连接上的请求都以这个用户的身份执行。
+// 其他节点转发用户时先只使用 PEER 一个参数申请一次性随机数，然后参数是 PEER、用户名和节点对它们和随机数的签名。
+// 随机数只属于这个连接，并且只能使用一次，所以截获的转发认证没法在其他连接上或者再次使用。
+func (ts *TCPServer) authHandler(session *vex.Session, args [][]byte) (body []byte, err error) {
+	if ts.acl == nil {
+		return nil, authNotEnabledErr
+	}
+
+	state := stateOf(session, ts.options)
+	var user *User
+	switch {
+	case len(args) == 0:
+		return nil, commandNeedsMoreArgumentsErr
+	case len(args) == 1 && string(args[0]) == peerAuth:
+		if ts.keyring == nil {
+			return nil, peerAuthNeedsKeysErr
+		}
+
+		state.peerNonce, err = newPeerNonce()
+		return state.peerNonce, err
+	case len(args) == 1:
+		user, err = ts.acl.authenticateToken(string(args[0]))
+	case len(args) == 3 && string(args[0]) == peerAuth:
+		nonce := state.peerNonce
+		state.peerNonce = nil
+		if nonce == nil {
+			err = peerNonceErr
+			break
+		}
+		user, err = ts.authenticatePeer(commandMessage(authCommand, [][]byte{args[0], args[1], nonce}), string(args[1]), args[2])
+	default:
+		user, err = ts.acl.authenticate(string(args[0]), string(args[1]))
+	}
+
+	if err != nil {
+		metrics.Add(authFailuresMetric, 1)
+		state.user = nil
+		return nil, err
+	}
+
+	state.user = user
+	return []byte(user.Name), nil
+}
+
+// intercept 是所有命令执行之前的拦截器，先按照连接限流，然后检查权限，最后按照用户限流。
+// 连接的限流在认证之前，这样也能限制猜测密码的速度。
+func (ts *TCPServer) intercept(session *vex.Session, command byte, args [][]byte) error {
+	state := stateOf(session, ts.options)
+	size := requestSize(args)
+	if err := state.limits.allow(size); err != nil {
+		return err
+	}
+
+	if err := ts.authorize(session, command, args); err != nil {
+		return err
+	}
+
+	if state.user == nil {
+		return nil
+	}
+	return ts.userLimits.allow(state.user.Name, size)
+}
+
+// authorize 检查命令能不能执行，开启了认证的话，只有认证通过并且拥有权限的用户才能执行命令，没有开启认证的话不能执行管理命令。
+func (ts *TCPServer) authorize(session *vex.Session, command byte, args [][]byte) error {
+	if command == authCommand {
+		return nil
+	}
+
+	if ts.acl == nil {
+		if err := authorizeAnonymous(accessOf(command, args)); err != nil {
+			metrics.Add(permissionDeniedMetric, 1)
+			return err
+		}
+		return nil
+	}
+
+	user := stateOf(session, ts.options).user
+	if user == nil {
+		metrics.Add(authFailuresMetric, 1)
+		return authRequiredErr
+	}
+
+	if err := user.authorize(accessOf(command, args)); err != nil {
+		metrics.Add(permissionDeniedMetric, 1)
+		return err
+	}
+	return nil
+}
+
+// accessOf 返回执行命令需要的权限和访问的数据，参数不够的话不会设置命名空间和 key，命令处理器会返回参数不够的错误。
+func accessOf(command byte, args [][]byte) access {
+	a := access{permission: commandPermissions[command]}
+	if a.permission == "" {
+		a.permission = AdminPermission
+	}
+
+	// options 是命令固定参数后面的可选项，NS 可选项就在里面
+	var options [][]byte
+	switch command {
+	case setCommand:
+		if len(args) >= 3 {
+			a.key, a.keyed = string(args[1]), true
+			options = args[3:]
+		}
+	case getCommand, deleteCommand, persistCommand, ttlCommand, metaCommand, touchCommand:
+		if len(args) >= 1 {
+			a.key, a.keyed = string(args[0]), true
+			options = args[1:]
+		}
+	case expireCommand:
+		if len(args) >= 2 {
+			a.key, a.keyed = string(args[0]), true
+			options = args[2:]
+		}
+	case invalidateTagCommand:
+		if len(args) >= 1 {
+			a.scoped = true
+			options = args[1:]
+		}
+	case statusCommand:
+		a.scoped = len(args) >= 2
+		options = args
+	case flushNamespaceCommand:
+		if len(args) >= 1 {
+			a.namespace, a.scoped = string(args[0]), true
+		}
+		return a
+	default:
+		return a
+	}
+
+	// 操作数据的命令都只访问一个命名空间，没有指定的话就是默认的命名空间
+	a.scoped = a.scoped || a.keyed
+	if name, ok := optionOf(options, nsOption); ok {
+		a.namespace = string(name)
+	}
+	return a
+}
+
+// metricsHandler 是返回 json 格式的服务器指标的处理器。
+func (ts *TCPServer) metricsHandler(args [][]byte) (body []byte, err error) {
+	return metricsJSON(), nil
+}
+
+// reloadHandler 是重新加载配置的处理器，只在当前节点执行，返回的是 json 格式的需要重启才能生效的配置名称。
+func (ts *TCPServer) reloadHandler(args [][]byte) (body []byte, err error) {
+	if ts.reloader == nil {
+		return nil, reloadNotSupportedErr
+	}
+
+	ignored, err := ts.reloader()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ignoredOrEmpty(ignored))
+}
+
+// ttlMilliseconds 将剩余寿命转换成毫秒，不足 1 毫秒的按 1 毫秒算，避免和永不过期混淆。
+func ttlMilliseconds(ttl time.Duration) int64 {
+	if ttl == caches.NeverDie {
+		return caches.NeverDie
+	}
+
+	milliseconds := ttl.Milliseconds()
+	if milliseconds < 1 {
+		milliseconds = 1
+	}
+	return milliseconds
+}
+
+// statusHandler 是返回缓存状态的处理器，如果指定了命名空间，就只返回这个命名空间的状态。
 func (ts *TCPServer) statusHandler(args [][]byte) (body []byte, err error) {
-	return json.Marshal(ts.cache.Status())
+	if len(args) < 2 {
+		return json.Marshal(ts.cache.Status())
+	}
+
+	namespace, err := ts.namespaceOf(args)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(namespace.Status())
+}
+
+// flushNamespaceHandler 是清空一个命名空间的处理器，参数是命名空间的名称。
+// 这里只会清空当前节点中的数据，需要清空整个集群的话就要对每个节点都执行一次。
+func (ts *TCPServer) flushNamespaceHandler(args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 命名空间不存在的话就没有需要清空的数据
+	if namespace, ok := ts.cache.LookupNamespace(string(args[0])); ok {
+		namespace.Flush()
+	}
+	return nil, nil
 }
 
-// nodesHandler 是返回集群所有节点名称的处理器。
+// nodesHandler 是返回集群所有节点信息的处理器，包括节点的名称和每种协议的访问地址。
 func (ts *TCPServer) nodesHandler(args [][]byte) (body []byte, err error) {
-	return json.Marshal(ts.nodes())
-}
\ No newline at end of file
+	return json.Marshal(ts.nodeInfos())
+}
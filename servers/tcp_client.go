@@ -2,15 +2,24 @@ package servers
 
 import (
 	"Rcache/caches"
+	"Rcache/helpers"
 	"Rcache/vex"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/json"
+	"time"
 )
 
 // TCPClient 是 TCP 客户端结构。
 type TCPClient struct {
 	// client 是内部使用的真正的 TCP 客户端。
 	client *vex.Client
+
+	// namespace 是这个客户端操作的命名空间，为空表示默认的命名空间。
+	namespace string
+
+	// sign 用于给节点之间广播的内部命令签名，为 nil 表示不签名，只有节点内部使用的客户端才会设置。
+	sign func(message []byte) []byte
 }
 
 // NewTCPClient 返回一个新的 TCP 客户端。
@@ -26,9 +35,99 @@ func NewTCPClient(address string) (*TCPClient, error) {
 	}, nil
 }
 
+// NewTCPClientWithTLS 返回一个使用 TLS 连接 address 的 TCP 客户端。
+// 服务器开启了 TLSClientAuth 的话，config 中需要带上 CA 签发的客户端证书。
+func NewTCPClientWithTLS(address string, config *tls.Config) (*TCPClient, error) {
+	client, err := vex.NewTLSClient("tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPClient{
+		client: client,
+	}, nil
+}
+
+// EnableCompression 开启传输压缩，比较大的请求和响应都会压缩之后再传输。
+func (tc *TCPClient) EnableCompression() {
+	tc.client.EnableCompression()
+}
+
+// EnableThrottledReplies 开启限流答复码，被限流时返回的错误可以使用 errors.Is(err, vex.ThrottledErr) 判断。
+func (tc *TCPClient) EnableThrottledReplies() {
+	tc.client.EnableThrottledReplies()
+}
+
+// Auth 使用用户名和密码认证，认证通过之后这个连接上的请求都以这个用户的身份执行。
+func (tc *TCPClient) Auth(name string, password string) error {
+	_, err := tc.client.Do(authCommand, [][]byte{[]byte(name), []byte(password)})
+	return err
+}
+
+// AuthToken 使用令牌认证，认证通过之后这个连接上的请求都以令牌所属用户的身份执行。
+func (tc *TCPClient) AuthToken(token string) error {
+	_, err := tc.client.Do(authCommand, [][]byte{[]byte(token)})
+	return err
+}
+
+// authPeer 使用节点签名的用户名认证，节点之间广播命令时使用它代替用户的密码和令牌。
+// 签名中包括了服务端为这个连接生成的一次性随机数，所以需要先申请随机数。
+func (tc *TCPClient) authPeer(name string) error {
+	if tc.sign == nil {
+		return peerAuthNeedsKeysErr
+	}
+
+	nonce, err := tc.client.Do(authCommand, [][]byte{[]byte(peerAuth)})
+	if err != nil {
+		return err
+	}
+
+	args := [][]byte{[]byte(peerAuth), []byte(name)}
+	signature := tc.sign(commandMessage(authCommand, append(args, nonce)))
+	if signature == nil {
+		return peerAuthNeedsKeysErr
+	}
+
+	_, err = tc.client.Do(authCommand, append(args, signature))
+	return err
+}
+
+// Namespace 返回一个操作名为 name 的命名空间的客户端，它和当前客户端共用同一个连接，所以只需要关闭其中一个。
+func (tc *TCPClient) Namespace(name string) *TCPClient {
+	return &TCPClient{
+		client:    tc.client,
+		namespace: name,
+		sign:      tc.sign,
+	}
+}
+
+// withNamespace 在命令的固定参数后面加上命名空间的可选项，默认的命名空间不需要加。
+func (tc *TCPClient) withNamespace(args [][]byte) [][]byte {
+	if tc.namespace == caches.DefaultNamespace {
+		return args
+	}
+	return append(args, []byte(nsOption), []byte(tc.namespace))
+}
+
+// scoped 在命令的参数后面加上 SCOPE 可选项，scope 为空的话不加，客户端可以签名的话还会在最后加上命令的签名。
+func (tc *TCPClient) scoped(command byte, args [][]byte, scope string) [][]byte {
+	if scope == "" {
+		return args
+	}
+
+	args = append(args, []byte(scopeOption), []byte(scope))
+	if tc.sign == nil {
+		return args
+	}
+
+	if signature := tc.sign(commandMessage(command, args)); signature != nil {
+		args = append(args, []byte(sigOption), signature)
+	}
+	return args
+}
+
 // Get 获取指定 key 的 value。
 func (tc *TCPClient) Get(key string) ([]byte, error) {
-	return tc.client.Do(getCommand, [][]byte{[]byte(key)})
+	return tc.client.Do(getCommand, tc.withNamespace([][]byte{[]byte(key)}))
 }
 
 // Set 添加一个键值对到缓存中。
@@ -37,21 +136,142 @@ func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
 	// 注意使用大端的形式存储数字
 	ttlBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
-	_, err := tc.client.Do(setCommand, [][]byte{
+	_, err := tc.client.Do(setCommand, tc.withNamespace([][]byte{
 		ttlBytes, []byte(key), value,
-	})
+	}))
+	return err
+}
+
+// SetWithDuration 添加一个键值对到缓存中，ttl 会以毫秒的精度发送给服务端。
+func (tc *TCPClient) SetWithDuration(key string, value []byte, ttl time.Duration) error {
+	return tc.SetWith(key, value, caches.SetOptions{Ttl: ttl})
+}
+
+// SetWithExpireAt 添加一个键值对到缓存中，并在 expireAt 这个时间点过期。
+func (tc *TCPClient) SetWithExpireAt(key string, value []byte, expireAt time.Time) error {
+	return tc.SetWith(key, value, caches.SetOptions{ExpireAt: expireAt})
+}
+
+// SetWith 使用 options 添加一个键值对到缓存中，比如选择滑动过期模式。
+// 如果 options 中的写入条件不满足，就返回 caches.PreconditionFailedErr。
+func (tc *TCPClient) SetWith(key string, value []byte, options caches.SetOptions) error {
+	_, err := tc.client.Do(setCommand, tc.withNamespace(setArgsOf(key, value, options)))
+	if err != nil && err.Error() == caches.PreconditionFailedErr.Error() {
+		return caches.PreconditionFailedErr
+	}
 	return err
 }
 
+// setArgsOf 将 options 编码成 set 命令的参数，只有设置了的选项才会被编码进去。
+func setArgsOf(key string, value []byte, options caches.SetOptions) [][]byte {
+	args := [][]byte{helpers.Uint64ToBytes(0), []byte(key), value}
+	if options.Ttl != caches.NeverDie {
+		args = append(args, []byte(pxOption), helpers.Uint64ToBytes(uint64(options.Ttl.Milliseconds())))
+	}
+
+	if !options.ExpireAt.IsZero() {
+		args = append(args, []byte(pxatOption), helpers.Uint64ToBytes(uint64(options.ExpireAt.UnixMilli())))
+	}
+
+	if options.ExpireMode != caches.ExpireAbsolute {
+		args = append(args, []byte(modeOption), helpers.Uint64ToBytes(uint64(options.ExpireMode)))
+	}
+
+	if options.MaxLifetime > 0 {
+		args = append(args, []byte(maxpxOption), helpers.Uint64ToBytes(uint64(options.MaxLifetime.Milliseconds())))
+	}
+
+	for _, tag := range options.Tags {
+		args = append(args, []byte(tagOption), []byte(tag))
+	}
+
+	if options.Flags != 0 {
+		args = append(args, []byte(flagsOption), helpers.Uint64ToBytes(uint64(options.Flags)))
+	}
+
+	if options.ContentType != "" {
+		args = append(args, []byte(ctypeOption), []byte(options.ContentType))
+	}
+
+	if options.IfVersion != 0 {
+		args = append(args, []byte(ifverOption), helpers.Uint64ToBytes(options.IfVersion))
+	}
+
+	if options.IfAbsent {
+		args = append(args, []byte(nxOption), helpers.Uint64ToBytes(1))
+	}
+	return args
+}
+
 // Delete 删除指定 key 的 value。
 func (tc *TCPClient) Delete(key string) error {
-	_, err := tc.client.Do(deleteCommand, [][]byte{[]byte(key)})
+	_, err := tc.client.Do(deleteCommand, tc.withNamespace([][]byte{[]byte(key)}))
 	return err
 }
 
-// Status 返回缓存的状态。
+// Expire 重新设置指定 key 的寿命，ttl 会以毫秒的精度发送给服务端。
+func (tc *TCPClient) Expire(key string, ttl time.Duration) error {
+	_, err := tc.client.Do(expireCommand, tc.withNamespace([][]byte{
+		[]byte(key), helpers.Uint64ToBytes(uint64(ttl.Milliseconds())),
+	}))
+	return err
+}
+
+// Persist 移除指定 key 的寿命，让它永不过期。
+func (tc *TCPClient) Persist(key string) error {
+	_, err := tc.client.Do(persistCommand, tc.withNamespace([][]byte{[]byte(key)}))
+	return err
+}
+
+// TTL 返回指定 key 剩余的寿命，精度是毫秒，返回 caches.NeverDie 表示永不过期。
+func (tc *TCPClient) TTL(key string) (time.Duration, error) {
+	body, err := tc.client.Do(ttlCommand, tc.withNamespace([][]byte{[]byte(key)}))
+	if err != nil {
+		return 0, err
+	}
+
+	milliseconds, ok := helpers.BytesToUint64(body)
+	if !ok {
+		return 0, invalidOptionErr
+	}
+	return time.Duration(milliseconds) * time.Millisecond, nil
+}
+
+// Touch 更新指定 key 的访问时间，滑动过期的数据会因此延长寿命。
+func (tc *TCPClient) Touch(key string) error {
+	_, err := tc.client.Do(touchCommand, tc.withNamespace([][]byte{[]byte(key)}))
+	return err
+}
+
+// Meta 返回指定 key 的数据的元信息。
+func (tc *TCPClient) Meta(key string) (*caches.Meta, error) {
+	body, err := tc.client.Do(metaCommand, tc.withNamespace([][]byte{[]byte(key)}))
+	if err != nil {
+		return nil, err
+	}
+	meta := &caches.Meta{}
+	err = json.Unmarshal(body, meta)
+	return meta, err
+}
+
+// GetWithMeta 返回指定 key 的数据和它的元信息。
+// 数据和元信息是分两次获取的，如果中间数据被修改了，它们可能对应不上，需要的话可以比较获取前后的版本号。
+func (tc *TCPClient) GetWithMeta(key string) ([]byte, *caches.Meta, error) {
+	value, err := tc.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta, err := tc.Meta(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, meta, nil
+}
+
+// Status 返回缓存的状态，如果这个客户端指定了命名空间，就只返回这个命名空间的状态。
 func (tc *TCPClient) Status() (*caches.Status, error) {
-	body, err := tc.client.Do(statusCommand, nil)
+	body, err := tc.client.Do(statusCommand, tc.withNamespace(nil))
 	if err != nil {
 		return nil, err
 	}
@@ -60,18 +280,118 @@ func (tc *TCPClient) Status() (*caches.Status, error) {
 	return status, err
 }
 
+// InvalidateTag 删除整个集群中带有 tag 这个标签的所有数据，返回删除的数据个数。
+func (tc *TCPClient) InvalidateTag(tag string) (int, error) {
+	return tc.invalidateTag(tag, "")
+}
+
+// invalidateTag 删除带有 tag 这个标签的所有数据，scope 为 localScope 时只删除所连接的节点中的数据。
+func (tc *TCPClient) invalidateTag(tag string, scope string) (int, error) {
+	args := tc.scoped(invalidateTagCommand, tc.withNamespace([][]byte{[]byte(tag)}), scope)
+	body, err := tc.client.Do(invalidateTagCommand, args)
+	if err != nil {
+		return 0, err
+	}
+
+	invalidated, ok := helpers.BytesToUint64(body)
+	if !ok {
+		return 0, invalidOptionErr
+	}
+	return int(invalidated), nil
+}
+
+// Flush 清空整个集群中所有的数据。
+func (tc *TCPClient) Flush() error {
+	return tc.flush("")
+}
+
+// FlushNode 清空所连接的节点中所有的数据，其他节点不受影响。
+func (tc *TCPClient) FlushNode() error {
+	return tc.flush(localScope)
+}
+
+// flush 清空缓存中所有的数据，scope 为 localScope 时只清空所连接的节点。
+func (tc *TCPClient) flush(scope string) error {
+	_, err := tc.client.Do(flushCommand, tc.scoped(flushCommand, nil, scope))
+	return err
+}
+
+// FlushNamespace 清空这个客户端所连接的节点中这个命名空间的数据。
+func (tc *TCPClient) FlushNamespace() error {
+	_, err := tc.client.Do(flushNamespaceCommand, [][]byte{[]byte(tc.namespace)})
+	return err
+}
+
+// Reload 让所连接的节点重新加载配置，返回修改了但需要重启才能生效的配置名称。
+func (tc *TCPClient) Reload() ([]string, error) {
+	body, err := tc.client.Do(reloadCommand, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ignored []string
+	err = json.Unmarshal(body, &ignored)
+	return ignored, err
+}
+
+// ListKeys 返回所连接的节点的 gossip 密钥的指纹。
+func (tc *TCPClient) ListKeys() (Keys, error) {
+	body, err := tc.client.Do(keysCommand, [][]byte{[]byte(listKeysAction)})
+	if err != nil {
+		return Keys{}, err
+	}
+
+	var keys Keys
+	err = json.Unmarshal(body, &keys)
+	return keys, err
+}
+
+// InstallKey 在集群中所有节点上添加 base64 编码的 gossip 密钥，添加的密钥可以用于解密，但还不会用于加密。
+func (tc *TCPClient) InstallKey(key string) error {
+	return tc.changeKey(installKeyAction, key, "")
+}
+
+// UseKey 把集群中所有节点加密使用的 gossip 密钥设置为 key，这个密钥需要已经添加过了。
+func (tc *TCPClient) UseKey(key string) error {
+	return tc.changeKey(useKeyAction, key, "")
+}
+
+// RemoveKey 在集群中所有节点上删除 gossip 密钥 key，不能删除加密使用的密钥。
+func (tc *TCPClient) RemoveKey(key string) error {
+	return tc.changeKey(removeKeyAction, key, "")
+}
+
+// changeKey 对 gossip 密钥执行 action 操作，scope 为 localScope 时只在所连接的节点执行。
+func (tc *TCPClient) changeKey(action string, key string, scope string) error {
+	args := tc.scoped(keysCommand, [][]byte{[]byte(action), []byte(key)}, scope)
+	_, err := tc.client.Do(keysCommand, args)
+	return err
+}
+
+// Metrics 返回所连接的节点的指标。
+func (tc *TCPClient) Metrics() (map[string]interface{}, error) {
+	body, err := tc.client.Do(metricsCommand, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string]interface{})
+	err = json.Unmarshal(body, &metrics)
+	return metrics, err
+}
+
 // Close 关闭这个客户端。
 func (tc *TCPClient) Close() error {
 	return tc.client.Close()
 }
 
-
-func (tc *TCPClient) Nodes() ([]string, error) {
+// Nodes 返回集群中所有节点的信息，包括节点的名称和每种协议的访问地址。
+func (tc *TCPClient) Nodes() ([]NodeInfo, error) {
 	body, err := tc.client.Do(nodesCommand, nil)
 	if err != nil {
 		return nil, err
 	}
-	var nodes []string
+	var nodes []NodeInfo
 	err = json.Unmarshal(body, &nodes)
 	return nodes, err
 }
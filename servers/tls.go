@@ -0,0 +1,218 @@
+package servers
+
+import (
+	"Rcache/helpers"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// certificatesCheckInterval 是检查证书文件有没有修改过的最小间隔。
+	certificatesCheckInterval = 5 * time.Second
+)
+
+var (
+	// tlsVersions 是 TLSMinVersion 的取值和 TLS 版本的对应关系。
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// certificates 管理着节点使用的证书和 CA。
+// 证书文件修改之后会在下一次握手时重新加载，所以更换证书不需要重启节点。
+type certificates struct {
+
+	// options 存储着证书文件的路径等配置。
+	options *Options
+
+	// certificate 是当前使用的证书。
+	certificate *tls.Certificate
+
+	// pool 是验证对方证书使用的 CA，为 nil 的话使用系统的根证书。
+	pool *x509.CertPool
+
+	// modTime 是加载证书时证书文件最后的修改时间。
+	modTime time.Time
+
+	// checkedAt 是上一次检查证书文件的时间。
+	checkedAt time.Time
+
+	// lock 保护上面的字段。
+	lock *sync.Mutex
+}
+
+// newCertificates 使用 options 加载证书，没有配置证书的话返回 nil，表示不使用 TLS。
+func newCertificates(options *Options) (*certificates, error) {
+	if options.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	c := &certificates{
+		options: options,
+		lock:    &sync.Mutex{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// files 返回需要加载的所有证书文件。
+func (c *certificates) files() []string {
+	files := []string{c.options.TLSCertFile, c.options.TLSKeyFile}
+	if c.options.TLSCAFile != "" {
+		files = append(files, c.options.TLSCAFile)
+	}
+	return files
+}
+
+// latestModTime 返回证书文件中最后的修改时间。
+func (c *certificates) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load 读取证书文件，读取失败的话不会修改当前使用的证书。
+func (c *certificates) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.options.TLSCertFile, c.options.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if c.options.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(c.options.TLSCAFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate is found in %s", c.options.TLSCAFile)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.certificate = &certificate
+	c.pool = pool
+	c.modTime = modTime
+	return nil
+}
+
+// current 返回当前使用的证书和 CA。
+// 距离上一次检查超过 certificatesCheckInterval 的话，会先检查证书文件有没有修改过，修改过就重新加载，重新加载失败的话继续使用旧的证书。
+func (c *certificates) current() (*tls.Certificate, *x509.CertPool) {
+	c.lock.Lock()
+	check := time.Since(c.checkedAt) >= certificatesCheckInterval
+	if check {
+		c.checkedAt = time.Now()
+	}
+	modTime := c.modTime
+	c.lock.Unlock()
+
+	if check {
+		if latest, err := c.latestModTime(); err != nil {
+			helpers.Warnf("Failed to check TLS certificates: %v", err)
+		} else if latest.After(modTime) {
+			if err = c.load(); err != nil {
+				helpers.Warnf("Failed to reload TLS certificates: %v", err)
+			} else {
+				helpers.Infof("TLS certificates are reloaded.")
+			}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.certificate, c.pool
+}
+
+// serverConfig 返回服务器使用的 TLS 配置，每次握手都会使用当前的证书，开启了 TLSClientAuth 的话还会验证客户端的证书。
+func (c *certificates) serverConfig() *tls.Config {
+	minVersion := tlsVersions[c.options.TLSMinVersion]
+	return &tls.Config{
+		MinVersion: minVersion,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			certificate, _ := c.current()
+			return certificate, nil
+		},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			certificate, pool := c.current()
+			config := &tls.Config{
+				MinVersion:   minVersion,
+				Certificates: []tls.Certificate{*certificate},
+				ClientCAs:    pool,
+			}
+			if c.options.TLSClientAuth {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// clientConfig 返回节点访问其他节点时使用的 TLS 配置，会使用 CA 验证对方的证书，并带上自己的证书，这样对方开启了 TLSClientAuth 也能访问。
+func (c *certificates) clientConfig() *tls.Config {
+	certificate, pool := c.current()
+	return &tls.Config{
+		MinVersion:   tlsVersions[c.options.TLSMinVersion],
+		Certificates: []tls.Certificate{*certificate},
+		RootCAs:      pool,
+	}
+}
+
+// newHTTPClient 返回节点之间转发 http 请求使用的客户端，c 为 nil 的话就是默认的客户端。
+func newHTTPClient(c *certificates) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+
+	// 每次建立连接时才获取 TLS 配置，这样重新加载的证书也能马上用上
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialer := &tls.Dialer{Config: c.clientConfig()}
+		return dialer.DialContext(ctx, network, address)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// dialTCP 返回连接 endpoint 的 TCP 客户端，开启了 TLS 的话会使用 TLS 连接。
+func (n *node) dialTCP(endpoint string) (*TCPClient, error) {
+	if n.certificates == nil {
+		return NewTCPClient(endpoint)
+	}
+	return NewTCPClientWithTLS(endpoint, n.certificates.clientConfig())
+}
+
+// scheme 返回节点提供 http 服务使用的协议，开启了 TLS 的话是 https。
+func (n *node) scheme() string {
+	if n.certificates == nil {
+		return "http"
+	}
+	return "https"
+}
@@ -0,0 +1,234 @@
+package servers
+
+import (
+	"Rcache/vex"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 是测试使用的 CA，用来签发服务端和客户端的证书。
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// newTestCA 生成一个自签名的 CA，并把证书写到 dir 目录下的 ca.pem 中。
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Rcache Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+	return &testCA{certificate: certificate, key: key}
+}
+
+// issue 使用 CA 签发序列号是 serial 的证书，可以用于 127.0.0.1 的服务端，也可以用于客户端，然后把证书和私钥写到 certFile 和 keyFile 中。
+func (ca *testCA) issue(t *testing.T, serial int64, certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Rcache Test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+}
+
+// writePEM 把 der 编码成 PEM 格式写到 file 中。
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCertificates 生成 CA 和序列号为 2 的证书，返回开启了双向 TLS 的证书管理器。
+func newTestCertificates(t *testing.T) (*certificates, *testCA) {
+	dir := t.TempDir()
+	options := &Options{
+		TLSCertFile:   filepath.Join(dir, "cert.pem"),
+		TLSKeyFile:    filepath.Join(dir, "key.pem"),
+		TLSCAFile:     filepath.Join(dir, "ca.pem"),
+		TLSClientAuth: true,
+		TLSMinVersion: "1.2",
+	}
+
+	ca := newTestCA(t, dir)
+	ca.issue(t, 2, options.TLSCertFile, options.TLSKeyFile)
+	c, err := newCertificates(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, ca
+}
+
+// newTestTLSServer 在随机端口上启动使用 c 的 vex TLS 服务端，返回监听的地址，测试结束时会关闭服务端。
+func newTestTLSServer(t *testing.T, c *certificates) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := listener.Addr().String()
+	listener.Close()
+
+	server := vex.NewServer()
+	server.RegisterHandler(1, func(args [][]byte) (body []byte, err error) {
+		return []byte("ok"), nil
+	})
+	go server.ListenAndServeTLS("tcp", address, c.serverConfig())
+	t.Cleanup(func() { server.Close() })
+
+	// 等待服务端开始监听
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return address
+		}
+
+		if i >= 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// go test -v -run=^TestTLSServers$
+func TestTLSServers(t *testing.T) {
+
+	c, _ := newTestCertificates(t)
+	_, pool := c.current()
+	address := newTestTLSServer(t, c)
+
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("ok"))
+	}))
+	httpServer.TLS = c.serverConfig()
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	// 带着 CA 签发的证书的客户端可以访问，没有证书的客户端会被双向 TLS 拒绝
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		allowed bool
+	}{
+		{name: "with certificate", config: c.clientConfig(), allowed: true},
+		{name: "without certificate", config: &tls.Config{RootCAs: pool}, allowed: false},
+	}
+
+	for _, test := range tests {
+		client, err := vex.NewTLSClient("tcp", address, test.config)
+		if err == nil {
+			_, err = client.Do(1, nil)
+			client.Close()
+		}
+
+		if (err == nil) != test.allowed {
+			t.Fatalf("tcp client %s should be allowed %v but got %v", test.name, test.allowed, err)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = test.config
+		response, err := (&http.Client{Transport: transport}).Get(httpServer.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+		transport.CloseIdleConnections()
+
+		if (err == nil) != test.allowed {
+			t.Fatalf("http client %s should be allowed %v but got %v", test.name, test.allowed, err)
+		}
+	}
+}
+
+// go test -v -run=^TestCertificatesReload$
+func TestCertificatesReload(t *testing.T) {
+
+	c, ca := newTestCertificates(t)
+	address := newTestTLSServer(t, c)
+
+	serialOf := func() int64 {
+		conn, err := tls.Dial("tcp", address, c.clientConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if serial := serialOf(); serial != 2 {
+		t.Fatalf("server should use the certificate 2 but got %d", serial)
+	}
+
+	// 替换证书文件，并让修改时间晚于加载的时间，跳过检查间隔之后下一次握手就会使用新的证书
+	ca.issue(t, 3, c.options.TLSCertFile, c.options.TLSKeyFile)
+	later := time.Now().Add(time.Minute)
+	for _, file := range c.files() {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.lock.Lock()
+	c.checkedAt = time.Time{}
+	c.lock.Unlock()
+
+	if serial := serialOf(); serial != 3 {
+		t.Fatalf("server should use the reloaded certificate 3 but got %d", serial)
+	}
+}
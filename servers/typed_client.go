@@ -0,0 +1,82 @@
+package servers
+
+import (
+	"Rcache/caches"
+	"fmt"
+)
+
+// TypedClient 是带类型的 TCP 客户端，和 caches.TypedCache 一样会使用 Codec 序列化数据，只是数据存储在远程的缓存服务中。
+type TypedClient[K any, V any] struct {
+
+	// client 是内部使用的 TCP 客户端。
+	client *TCPClient
+
+	// codec 是数据的编解码器。
+	codec caches.Codec
+
+	// keyOf 将 K 类型的 key 转换成缓存中使用的字符串。
+	keyOf func(key K) string
+}
+
+// NewTypedClient 返回一个使用 codec 序列化数据的带类型客户端，keyOf 为 nil 的话会使用 fmt.Sprint 转换 key。
+func NewTypedClient[K any, V any](client *TCPClient, codec caches.Codec, keyOf func(key K) string) *TypedClient[K, V] {
+	if keyOf == nil {
+		keyOf = func(key K) string {
+			return fmt.Sprint(key)
+		}
+	}
+
+	return &TypedClient[K, V]{
+		client: client,
+		codec:  codec,
+		keyOf:  keyOf,
+	}
+}
+
+// Get 返回指定 key 的数据，如果数据不存在就返回 false。
+func (tc *TypedClient[K, V]) Get(key K) (V, bool, error) {
+	var v V
+	data, err := tc.client.Get(tc.keyOf(key))
+	if err != nil {
+		// 服务端找不到数据时会返回 notFoundErr，这里把它转换成 false
+		if err.Error() == notFoundErr.Error() {
+			return v, false, nil
+		}
+		return v, false, err
+	}
+
+	err = tc.codec.Unmarshal(data, &v)
+	return v, err == nil, err
+}
+
+// Set 添加指定的数据到缓存中。
+func (tc *TypedClient[K, V]) Set(key K, value V) error {
+	return tc.SetWith(key, value, caches.SetOptions{})
+}
+
+// SetWith 使用 options 添加指定的数据到缓存中。
+func (tc *TypedClient[K, V]) SetWith(key K, value V, options caches.SetOptions) error {
+	data, err := tc.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return tc.client.SetWith(tc.keyOf(key), data, options)
+}
+
+// Delete 从缓存中删除指定 key 的数据。
+func (tc *TypedClient[K, V]) Delete(key K) error {
+	return tc.client.Delete(tc.keyOf(key))
+}
+
+// GetOrLoad 返回指定 key 的数据，如果数据不存在就使用 load 加载，并使用 options 添加到缓存中。
+func (tc *TypedClient[K, V]) GetOrLoad(key K, options caches.SetOptions, load func(key K) (V, error)) (V, error) {
+	if v, ok, err := tc.Get(key); ok || err != nil {
+		return v, err
+	}
+
+	v, err := load(key)
+	if err != nil {
+		return v, err
+	}
+	return v, tc.SetWith(key, v, options)
+}
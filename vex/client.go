@@ -2,6 +2,7 @@ package vex
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -15,6 +16,9 @@ type Client struct {
 
 	// 通往服务端的读取器。
 	reader io.Reader
+
+	// 请求头部需要设置的标记，开启限流答复码之后会带上 AcceptThrottledFlag，开启压缩之后会带上 CompressedFlag 和 AcceptCompressionFlag。
+	flags byte
 }
 
 // 创建新的客户端。
@@ -25,16 +29,42 @@ func NewClient(network string, address string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newClient(conn), nil
+}
+
+// 创建使用 TLS 连接服务端的客户端，config 为 nil 的话使用默认的配置，也就是使用系统的根证书验证服务端。
+func NewTLSClient(network string, address string, config *tls.Config) (*Client, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn), nil
+}
+
+// 使用已经建立的连接创建客户端。
+func newClient(conn net.Conn) *Client {
 	return &Client{
 		conn:   conn,
 		reader: bufio.NewReader(conn),
-	}, nil
+	}
+}
+
+// 开启传输压缩，比较大的请求和响应都会使用 snappy 压缩之后再传输。
+// 服务端需要支持压缩标记，旧版本的服务端会认为协议版本不匹配。
+func (c *Client) EnableCompression() {
+	c.flags |= CompressedFlag | AcceptCompressionFlag
+}
+
+// 开启限流答复码，请求被限流时返回的错误可以使用 errors.Is(err, ThrottledErr) 判断，否则只能得到普通的错误。
+// 服务端需要支持限流标记，旧版本的服务端会认为协议版本不匹配。
+func (c *Client) EnableThrottledReplies() {
+	c.flags |= AcceptThrottledFlag
 }
 
 func (c *Client) Do(command byte, args [][]byte) (body []byte, err error) {
 
 	// 包装请求然后发送给服务端
-	_, err = writeRequestTo(c.conn, command, args)
+	_, err = writeRequestTo(c.conn, c.flags, command, args)
 	if err != nil {
 		return nil, err
 	}
@@ -45,9 +75,12 @@ func (c *Client) Do(command byte, args [][]byte) (body []byte, err error) {
 		return body, err
 	}
 
-	// 如果是错误答复码，将内容包装成 error 并返回
-	if reply == ErrorReply {
+	// 如果是错误答复码，将内容包装成 error 并返回，开启了限流答复码的话被限流的错误可以使用 errors.Is(err, ThrottledErr) 判断
+	switch reply {
+	case ErrorReply:
 		return body, errors.New(string(body))
+	case ThrottledReply:
+		return body, throttledError(body)
 	}
 	return body, nil
 }
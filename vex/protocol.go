@@ -1,6 +1,10 @@
 package vex
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/golang/snappy"
+)
 
 // Request:
 // version    command    argsLength    {argLength    arg}
@@ -10,15 +14,59 @@ import "errors"
 // version    reply    bodyLength    {body}
 //  1byte     1byte      4byte      unknown
 
+// version 的低 4 位是协议版本号，高位是标记：
+// 请求设置了 CompressedFlag 的话，参数部分会变成 compressedLength(4byte) 加上使用 snappy 压缩过的 {argLength arg}。
+// 响应设置了 CompressedFlag 的话，body 是使用 snappy 压缩过的。
+// 请求设置了 AcceptCompressionFlag 表示客户端能够处理压缩过的响应，服务端只有看到这个标记才会压缩响应。
+// 请求设置了 AcceptThrottledFlag 表示客户端认识 ThrottledReply，否则请求被限流时服务端会使用 ErrorReply。
+
 const (
 	ProtocolVersion        = byte(1) // 协议版本号
 	headerLengthInProtocol = 6       // 协议中头部占用的字节数
 	argsLengthInProtocol   = 4       // 协议中参数个数占用的字节数
 	argLengthInProtocol    = 4       // 协议中参数长度占用的字节数
 	bodyLengthInProtocol   = 4       // 协议体长度占用的字节数
+
+	versionMask           = byte(0x0f) // version 中协议版本号占用的位
+	CompressedFlag        = byte(0x80) // 内容使用 snappy 压缩过的标记
+	AcceptCompressionFlag = byte(0x40) // 客户端能够处理压缩过的响应的标记
+	AcceptThrottledFlag   = byte(0x20) // 客户端能够处理限流答复码的标记
+	compressionThreshold  = 1024       // 内容至少要这么大才会压缩，太小的内容压缩之后一般不会变小
 )
 
 var (
 	// 协议版本不匹配错误，如果客户端和服务端的版本不一样就会返回这个错误
 	ProtocolVersionMismatchErr = errors.New("protocol version between client and server doesn't match")
+
+	// 请求被限流的错误，命令处理器和拦截器返回包装了它的错误时，服务端会使用 ThrottledReply 答复。
+	// 客户端收到 ThrottledReply 时返回的错误也可以使用 errors.Is(err, ThrottledErr) 判断，这时可以稍后重试。
+	ThrottledErr = errors.New("throttled")
+
+	// 连接数太多的错误，超过最大连接数的连接会收到这个错误然后被断开。
+	tooManyConnectionsErr = errors.New("too many connections")
 )
+
+// 客户端收到 ThrottledReply 时返回的错误，内容是服务端的错误信息。
+type throttledError string
+
+func (te throttledError) Error() string {
+	return string(te)
+}
+
+// 让 errors.Is(err, ThrottledErr) 成立。
+func (te throttledError) Is(target error) bool {
+	return target == ThrottledErr
+}
+
+// compress 使用 snappy 压缩 data，内容太小或者压缩之后没有变小的话就返回 false。
+func compress(data []byte) ([]byte, bool) {
+	if len(data) < compressionThreshold {
+		return nil, false
+	}
+
+	compressed := snappy.Encode(nil, data)
+	if len(compressed) >= len(data) {
+		return nil, false
+	}
+	return compressed, true
+}
@@ -0,0 +1,96 @@
+package vex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// go test -v -run=^TestRequestRoundTrip$
+func TestRequestRoundTrip(t *testing.T) {
+
+	small := [][]byte{[]byte("key"), []byte("value")}
+	large := [][]byte{[]byte("key"), bytes.Repeat([]byte("value"), compressionThreshold)}
+
+	// 标记要原样传给服务端，只有足够大的参数才会真正压缩
+	tests := []struct {
+		name       string
+		flags      byte
+		args       [][]byte
+		compressed bool
+	}{
+		{name: "no flags", flags: 0, args: small},
+		{name: "no args", flags: 0, args: [][]byte{}},
+		{name: "small compressed", flags: CompressedFlag, args: small, compressed: false},
+		{name: "large compressed", flags: CompressedFlag, args: large, compressed: true},
+		{name: "large uncompressed", flags: 0, args: large, compressed: false},
+		{name: "accept compression", flags: AcceptCompressionFlag, args: small},
+		{name: "accept throttled", flags: AcceptThrottledFlag, args: small},
+		{name: "all flags", flags: CompressedFlag | AcceptCompressionFlag | AcceptThrottledFlag, args: large, compressed: true},
+	}
+
+	for _, test := range tests {
+		buffer := &bytes.Buffer{}
+		if _, err := writeRequestTo(buffer, test.flags, 1, test.args); err != nil {
+			t.Fatal(err)
+		}
+
+		if compressed := buffer.Bytes()[0]&CompressedFlag != 0; compressed != test.compressed {
+			t.Fatalf("request %s should be compressed %v", test.name, test.compressed)
+		}
+
+		flags, command, args, err := readRequestFrom(buffer)
+		if err != nil {
+			t.Fatalf("failed to read request %s: %v", test.name, err)
+		}
+
+		expected := test.flags &^ CompressedFlag
+		if test.compressed {
+			expected |= CompressedFlag
+		}
+
+		if flags != expected || command != 1 || !reflect.DeepEqual(args, test.args) {
+			t.Fatalf("request %s should be read back but got flags %x, command %d", test.name, flags, command)
+		}
+	}
+}
+
+// go test -v -run=^TestResponseRoundTrip$
+func TestResponseRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		reply       byte
+		body        []byte
+		compressing bool
+	}{
+		{name: "success", reply: SuccessReply, body: []byte("value")},
+		{name: "error", reply: ErrorReply, body: []byte("error")},
+		{name: "throttled", reply: ThrottledReply, body: []byte("throttled")},
+		{name: "small compressing", reply: SuccessReply, body: []byte("value"), compressing: true},
+		{name: "large compressing", reply: SuccessReply, body: bytes.Repeat([]byte("value"), compressionThreshold), compressing: true},
+	}
+
+	for _, test := range tests {
+		buffer := &bytes.Buffer{}
+		if _, err := writeResponseTo(buffer, test.reply, test.body, test.compressing); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, body, err := readResponseFrom(buffer)
+		if err != nil || reply != test.reply || !bytes.Equal(body, test.body) {
+			t.Fatalf("response %s should be read back but got reply %d, %v", test.name, reply, err)
+		}
+	}
+}
+
+// go test -v -run=^TestReadRequestVersionMismatch$
+func TestReadRequestVersionMismatch(t *testing.T) {
+
+	buffer := &bytes.Buffer{}
+	writeRequestTo(buffer, 0, 1, nil)
+	buffer.Bytes()[0] = ProtocolVersion + 1
+	if _, _, _, err := readRequestFrom(buffer); err != ProtocolVersionMismatchErr {
+		t.Fatalf("unknown version should be rejected but got %v", err)
+	}
+}
@@ -1,62 +1,97 @@
 package vex
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
+
+	"github.com/golang/snappy"
 )
 
-//读取请求，解析出命令
-func readRequestFrom(reader io.Reader) (command byte, args [][]byte, err error) {
+// 读取请求，解析出命令，flags 是请求头部中的标记
+func readRequestFrom(reader io.Reader) (flags byte, command byte, args [][]byte, err error) {
 	header := make([]byte, headerLengthInProtocol)
 	_, err = io.ReadFull(reader, header)
 	if err != nil {
-		return 0, nil, err
+		return 0, 0, nil, err
 	}
-	version := header[0]
+	version := header[0] & versionMask
 	if version != ProtocolVersion {
-		return 0, nil, ProtocolVersionMismatchErr
+		return 0, 0, nil, ProtocolVersionMismatchErr
 	}
+	flags = header[0] &^ versionMask
 	command = header[1]
 	header = header[2:]
 	//将头部的信息转化为一个数字
 	argsLength := binary.BigEndian.Uint32(header) //此时的argsLength就是本次请求的个数
 
+	// 参数压缩过的话，先读出整个压缩块并解压，再从解压后的内容中解析参数
+	if flags&CompressedFlag != 0 {
+		compressedLength := make([]byte, bodyLengthInProtocol)
+		if _, err = io.ReadFull(reader, compressedLength); err != nil {
+			return 0, 0, nil, err
+		}
+
+		compressed := make([]byte, binary.BigEndian.Uint32(compressedLength))
+		if _, err = io.ReadFull(reader, compressed); err != nil {
+			return 0, 0, nil, err
+		}
+
+		decompressed, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		reader = bytes.NewReader(decompressed)
+	}
+
 	args = make([][]byte, argsLength)
 	if argsLength > 0 {
 		argLength := make([]byte, argLengthInProtocol)
 		for i := uint32(0); i < argsLength; i++ {
 			_, err = io.ReadFull(reader, argLength)
 			if err != nil {
-				return 0, nil, err
+				return 0, 0, nil, err
 			}
 
 			arg := make([]byte, binary.BigEndian.Uint32(argLength))
 			_, err = io.ReadFull(reader, arg)
 			if err != nil {
-				return 0, nil, err
+				return 0, 0, nil, err
 			}
 			args[i] = arg
 		}
 	}
-	return command, args, nil
+	return flags, command, args, nil
 }
 
-//将请求的具体内容写入到writer
-func writeRequestTo(writer io.Writer, command byte, args [][]byte) (int, error) {
-	// 创建一个缓存区，并将协议版本号、命令和参数个数等写入缓存区
-	request := make([]byte, headerLengthInProtocol)
-	request[0] = ProtocolVersion
-	request[1] = command
-	binary.BigEndian.PutUint32(request[2:], uint32(len(args)))
+// 将请求的具体内容写入到writer，flags 是需要设置在请求头部的标记
+// 设置了 CompressedFlag 的话，参数足够大并且压缩之后变小了才会真正压缩
+func writeRequestTo(writer io.Writer, flags byte, command byte, args [][]byte) (int, error) {
+	// 将参数都添加到缓存区
+	var argsBytes []byte
+	argLength := make([]byte, argLengthInProtocol)
+	for _, arg := range args {
+		binary.BigEndian.PutUint32(argLength, uint32(len(arg)))
+		argsBytes = append(argsBytes, argLength...)
+		argsBytes = append(argsBytes, arg...)
+	}
 
-	if len(args) > 0 {
-		// 将参数都添加到缓存区
-		argLength := make([]byte, argLengthInProtocol)
-		for _, arg := range args {
-			binary.BigEndian.PutUint32(argLength, uint32(len(arg)))
-			request = append(request, argLength...)
-			request = append(request, arg...)
+	compressing := flags&CompressedFlag != 0
+	flags &^= CompressedFlag
+	if compressing {
+		if compressed, ok := compress(argsBytes); ok {
+			flags |= CompressedFlag
+			compressedLength := make([]byte, bodyLengthInProtocol)
+			binary.BigEndian.PutUint32(compressedLength, uint32(len(compressed)))
+			argsBytes = append(compressedLength, compressed...)
 		}
 	}
+
+	// 创建一个缓存区，并将协议版本号、命令和参数个数等写入缓存区
+	request := make([]byte, headerLengthInProtocol, headerLengthInProtocol+len(argsBytes))
+	request[0] = ProtocolVersion | flags
+	request[1] = command
+	binary.BigEndian.PutUint32(request[2:], uint32(len(args)))
+	request = append(request, argsBytes...)
 	return writer.Write(request)
 }
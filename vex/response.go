@@ -4,24 +4,28 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+
+	"github.com/golang/snappy"
 )
 
 const (
-	SuccessReply = 0 // 成功的答复码
-	ErrorReply   = 1 // 发生错误的答复码
+	SuccessReply   = 0 // 成功的答复码
+	ErrorReply     = 1 // 发生错误的答复码
+	ThrottledReply = 2 // 请求被限流的答复码，只有请求设置了 AcceptThrottledFlag 才会使用
 )
 
-//解析从服务端发送过来的响应
+// 解析从服务端发送过来的响应
 func readResponseFrom(reader io.Reader) (reply byte, body []byte, err error) {
 	header := make([]byte, headerLengthInProtocol)
 	_, err = io.ReadFull(reader, header)
 	if err != nil {
 		return ErrorReply, nil, err
 	}
-	version := header[0]
+	version := header[0] & versionMask
 	if version != ProtocolVersion {
 		return ErrorReply, nil, errors.New("response " + ProtocolVersionMismatchErr.Error())
 	}
+	compressed := header[0]&CompressedFlag != 0
 	reply = header[1]
 	header = header[2:]
 	body = make([]byte, binary.BigEndian.Uint32(header))
@@ -29,17 +33,32 @@ func readResponseFrom(reader io.Reader) (reply byte, body []byte, err error) {
 	if err != nil {
 		return ErrorReply, nil, err
 	}
+
+	if compressed {
+		body, err = snappy.Decode(nil, body)
+		if err != nil {
+			return ErrorReply, nil, err
+		}
+	}
 	return reply, body, nil
 }
 
-//服务端将响应写入到writer
-func writeResponseTo(writer io.Writer, reply byte, body []byte) (int, error) {
+// 服务端将响应写入到writer，compressing 为 true 的话，body 足够大并且压缩之后变小了才会真正压缩
+func writeResponseTo(writer io.Writer, reply byte, body []byte, compressing bool) (int, error) {
+	version := ProtocolVersion
+	if compressing {
+		if compressed, ok := compress(body); ok {
+			version |= CompressedFlag
+			body = compressed
+		}
+	}
+
 	// 将响应体相关数据写入响应缓存区，并发送
 	bodyLengthBytes := make([]byte, bodyLengthInProtocol)
 	binary.BigEndian.PutUint32(bodyLengthBytes, uint32(len(body)))
 
 	response := make([]byte, 2, headerLengthInProtocol+len(body))
-	response[0] = ProtocolVersion
+	response[0] = version
 	response[1] = reply
 	response = append(response, bodyLengthBytes...)
 	response = append(response, body...)
@@ -47,5 +66,5 @@ func writeResponseTo(writer io.Writer, reply byte, body []byte) (int, error) {
 }
 
 func writeErrorResponseTo(writer io.Writer, msg string) (int, error) {
-	return writeResponseTo(writer, ErrorReply, []byte(msg))
+	return writeResponseTo(writer, ErrorReply, []byte(msg), false)
 }
@@ -2,10 +2,18 @@ package vex
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	// 拒绝连接时发送错误响应最多等待的时间，避免客户端不读取响应时一直占用 goroutine。
+	rejectTimeout = time.Second
 )
 
 var (
@@ -13,6 +21,22 @@ var (
 	commandHandlerNotFoundErr = errors.New("failed to find a handler of command")
 )
 
+// 会话结构，同一个连接上的所有请求共享同一个会话。
+type Session struct {
+
+	// 客户端的地址。
+	RemoteAddr net.Addr
+
+	// 命令处理器在会话中保存的数据，比如认证之后的用户，服务端不会使用它。
+	Data interface{}
+}
+
+// 可以访问会话的命令处理器。
+type SessionHandler func(session *Session, args [][]byte) (body []byte, err error)
+
+// 拦截器，在命令处理器之前执行，返回错误的话就不会执行命令处理器，而是把错误返回给客户端。
+type Interceptor func(session *Session, command byte, args [][]byte) error
+
 // 服务端结构。
 type Server struct {
 
@@ -20,35 +44,101 @@ type Server struct {
 	listener net.Listener
 
 	// 命令处理器，通过命令可以找到对应的处理器。
-	handlers map[byte]func(args [][]byte) (body []byte, err error)
+	handlers map[byte]SessionHandler
+
+	// 拦截器，为 nil 的话所有请求都会直接交给命令处理器。
+	interceptor Interceptor
+
+	// 最多同时处理的连接数，为 0 表示不限制。
+	maxConnections int
+
+	// 连接因为超过最大连接数被拒绝时调用，可以为 nil。
+	onReject func()
+
+	// 记录着所有的连接，值为 true 表示这个连接正在处理请求。
+	conns map[net.Conn]bool
+
+	// 服务端是否正在关闭，正在关闭的话，连接处理完当前的请求就会断开。
+	closing bool
+
+	// 用于保护 conns 和 closing。
+	lock *sync.Mutex
+
+	// 记录着正在处理的连接数，关闭的时候需要等待它们处理完毕。
+	wg *sync.WaitGroup
 }
 
 // 创建新的服务端。
 func NewServer() *Server {
 	return &Server{
-		handlers: map[byte]func(args [][]byte) (body []byte, err error){},
+		handlers: map[byte]SessionHandler{},
+		conns:    map[net.Conn]bool{},
+		lock:     &sync.Mutex{},
+		wg:       &sync.WaitGroup{},
 	}
 }
 
 // 注册命令处理器。
 func (s *Server) RegisterHandler(command byte, handler func(args [][]byte) (body []byte, err error)) {
+	s.handlers[command] = func(session *Session, args [][]byte) (body []byte, err error) {
+		return handler(args)
+	}
+}
+
+// 注册可以访问会话的命令处理器，比如需要知道客户端地址的命令。
+func (s *Server) RegisterSessionHandler(command byte, handler SessionHandler) {
 	s.handlers[command] = handler
 }
 
+// 设置拦截器，比如检查客户端有没有权限执行命令，需要在监听之前调用。
+func (s *Server) SetInterceptor(interceptor Interceptor) {
+	s.interceptor = interceptor
+}
+
+// 设置最多同时处理的连接数，max 为 0 表示不限制，超过的连接会收到错误响应然后被断开，同时会调用 onReject。
+// 需要在监听之前调用。
+func (s *Server) SetMaxConnections(max int, onReject func()) {
+	s.maxConnections = max
+	s.onReject = onReject
+}
+
 // 监听并服务于 network 和 address。
 func (s *Server) ListenAndServe(network string, address string) (err error) {
 
 	// 监听指定地址
-	s.listener, err = net.Listen(network, address)
+	listener, err := net.Listen(network, address)
 	if err != nil {
 		return err
 	}
+	return s.serve(listener)
+}
+
+// 监听 network 和 address，并使用 config 通过 TLS 提供服务。
+// config 中可以设置 GetConfigForClient，这样不用重启也能更换证书。
+func (s *Server) ListenAndServeTLS(network string, address string, config *tls.Config) (err error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.serve(tls.NewListener(listener, config))
+}
+
+// 使用 listener 接收连接并处理，直到服务端被关闭。
+func (s *Server) serve(listener net.Listener) (err error) {
+
+	// 监听之前就已经关闭的话，直接停止监听
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.lock.Unlock()
 
 	// 使用 WaitGroup 记录连接数，并等待所有连接处理完毕
-	wg := &sync.WaitGroup{}
 	for {
 		// 等待客户端连接
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if strings.Contains(err.Error(), "use of closed network connection") {
 				break
@@ -56,53 +146,112 @@ func (s *Server) ListenAndServe(network string, address string) (err error) {
 			continue
 		}
 
-		// 记录连接
-		wg.Add(1)
+		// 记录连接，正在关闭或者连接数太多的话就不再处理新的连接了
+		if err = s.track(conn); err != nil {
+			go s.reject(conn, err)
+			continue
+		}
+
 		go func() {
-			defer wg.Done()
+			defer s.wg.Done()
+			defer s.untrack(conn)
 			s.handleConn(conn)
 		}()
 	}
 
 	// 等待所有连接处理完毕
-	wg.Wait()
+	s.wg.Wait()
 	return nil
 }
 
+// 记录连接，如果服务端正在关闭就返回 net.ErrClosed，连接数已经达到上限就返回 tooManyConnectionsErr。
+// 连接数需要在锁里面增加，否则可能和 Shutdown 中的等待同时发生。
+func (s *Server) track(conn net.Conn) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return net.ErrClosed
+	}
+
+	if s.maxConnections > 0 && len(s.conns) >= s.maxConnections {
+		return tooManyConnectionsErr
+	}
+	s.conns[conn] = false
+	s.wg.Add(1)
+	return nil
+}
+
+// 拒绝没有记录的连接，连接数太多的话会先发送错误响应，这样客户端执行第一个命令时就能知道原因。
+func (s *Server) reject(conn net.Conn, err error) {
+	defer conn.Close()
+	if err != tooManyConnectionsErr {
+		return
+	}
+
+	if s.onReject != nil {
+		s.onReject()
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	writeErrorResponseTo(conn, err.Error())
+}
+
+// 删除连接的记录。
+func (s *Server) untrack(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, conn)
+}
+
+// 设置连接是否正在处理请求，如果服务端正在关闭并且连接已经空闲了，就返回 false，这时连接需要断开。
+func (s *Server) setActive(conn net.Conn, active bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conns[conn] = active
+	return active || !s.closing
+}
+
 // 处理连接。
 func (s *Server) handleConn(conn net.Conn) {
 
 	// 将连接包装成缓冲读取器，提高读取的性能
 	reader := bufio.NewReader(conn)
+	session := &Session{RemoteAddr: conn.RemoteAddr()}
 	defer conn.Close()
 
 	for {
 		// 读取并解析请求请求
-		command, args, err := readRequestFrom(reader)
+		flags, command, args, err := readRequestFrom(reader)
 		if err != nil {
 			if err == ProtocolVersionMismatchErr {
 				continue
 			}
 			return
 		}
+		s.setActive(conn, true)
 
 		// 处理请求
-		reply, body, err := s.handleRequest(command, args)
+		reply, body, err := s.handleRequest(session, command, args)
 		if err != nil {
-			writeErrorResponseTo(conn, err.Error())
-			continue
+			// 客户端认识限流答复码的话才使用它，旧的客户端会把不认识的答复码当成成功
+			if errors.Is(err, ThrottledErr) && flags&AcceptThrottledFlag != 0 {
+				writeResponseTo(conn, ThrottledReply, []byte(err.Error()), false)
+			} else {
+				writeErrorResponseTo(conn, err.Error())
+			}
+		} else {
+			// 发送处理结果的响应，只有客户端能够处理的时候才压缩
+			writeResponseTo(conn, reply, body, flags&AcceptCompressionFlag != 0)
 		}
 
-		// 发送处理结果的响应
-		_, err = writeResponseTo(conn, reply, body)
-		if err != nil {
-			continue
+		// 服务端正在关闭的话，处理完当前的请求就断开连接
+		if !s.setActive(conn, false) {
+			return
 		}
 	}
 }
 
 // 处理请求。
-func (s *Server) handleRequest(command byte, args [][]byte) (reply byte, body []byte, err error) {
+func (s *Server) handleRequest(session *Session, command byte, args [][]byte) (reply byte, body []byte, err error) {
 
 	// 从命令处理器集合中选出对应的处理器
 	handle, ok := s.handlers[command]
@@ -110,8 +259,15 @@ func (s *Server) handleRequest(command byte, args [][]byte) (reply byte, body []
 		return ErrorReply, nil, commandHandlerNotFoundErr
 	}
 
+	// 先交给拦截器检查，不通过的话就不执行命令
+	if s.interceptor != nil {
+		if err = s.interceptor(session, command, args); err != nil {
+			return ErrorReply, nil, err
+		}
+	}
+
 	// 将处理结果返回
-	body, err = handle(args)
+	body, err = handle(session, args)
 	if err != nil {
 		return ErrorReply, body, err
 	}
@@ -120,8 +276,44 @@ func (s *Server) handleRequest(command byte, args [][]byte) (reply byte, body []
 
 // 关闭服务端的方法。
 func (s *Server) Close() error {
-	if s.listener == nil {
+	s.lock.Lock()
+	listener := s.listener
+	s.lock.Unlock()
+	if listener == nil {
 		return nil
 	}
-	return s.listener.Close()
+	return listener.Close()
+}
+
+// 优雅地关闭服务端。
+// 先停止接收新的连接并断开空闲的连接，正在处理请求的连接会在发送完响应之后断开。
+// 如果 ctx 结束时还有连接没有处理完，就强制断开它们并返回 ctx 的错误。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closing = true
+	for conn, active := range s.conns {
+		if !active {
+			conn.Close()
+		}
+	}
+	s.lock.Unlock()
+
+	err := s.Close()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.lock.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.lock.Unlock()
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,123 @@
+package vex
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer 在随机端口上启动服务端，返回监听的地址，测试结束时会关闭服务端。
+func newTestServer(t *testing.T, init func(s *Server)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer()
+	s.RegisterHandler(1, func(args [][]byte) (body []byte, err error) {
+		return []byte("ok"), nil
+	})
+	init(s)
+	go s.serve(listener)
+	t.Cleanup(func() { s.Close() })
+	return listener.Addr().String()
+}
+
+// go test -v -run=^TestServerMaxConnections$
+func TestServerMaxConnections(t *testing.T) {
+
+	rejected := int32(0)
+	address := newTestServer(t, func(s *Server) {
+		s.SetMaxConnections(2, func() { atomic.AddInt32(&rejected, 1) })
+	})
+
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		client, err := NewClient("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// 执行一次命令保证服务端已经记录了这个连接
+		if _, err = client.Do(1, nil); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+
+	// 超过上限的连接会在第一个命令收到错误
+	client, err := NewClient("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Do(1, nil); err == nil || err.Error() != tooManyConnectionsErr.Error() {
+		t.Fatalf("connection over the limit should be rejected but got %v", err)
+	}
+
+	if atomic.LoadInt32(&rejected) != 1 {
+		t.Fatalf("onReject should be called once but got %d", rejected)
+	}
+
+	// 断开一个连接之后就可以建立新的连接了
+	clients[0].Close()
+	for i := 0; ; i++ {
+		client, err := NewClient("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Do(1, nil)
+		client.Close()
+		if err == nil {
+			break
+		}
+
+		if i >= 100 {
+			t.Fatalf("new connection should be accepted after closing one but got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// go test -v -run=^TestServerThrottledReply$
+func TestServerThrottledReply(t *testing.T) {
+
+	address := newTestServer(t, func(s *Server) {
+		s.SetInterceptor(func(session *Session, command byte, args [][]byte) error {
+			return fmt.Errorf("%w: too many commands", ThrottledErr)
+		})
+	})
+
+	// 只有开启了限流答复码的客户端才会收到 ThrottledReply，旧的客户端收到的是普通的错误
+	tests := []struct {
+		name      string
+		enable    bool
+		throttled bool
+	}{
+		{name: "default", enable: false, throttled: false},
+		{name: "enabled", enable: true, throttled: true},
+	}
+
+	for _, test := range tests {
+		client, err := NewClient("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.enable {
+			client.EnableThrottledReplies()
+		}
+
+		_, err = client.Do(1, nil)
+		client.Close()
+		if err == nil || errors.Is(err, ThrottledErr) != test.throttled {
+			t.Fatalf("client %s should get throttled error %v but got %v", test.name, test.throttled, err)
+		}
+	}
+}